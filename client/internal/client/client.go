@@ -2,19 +2,26 @@ package client
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net"
+	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/config"
 	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/client/internal/metrics"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
+var ErrUnauthorized = errors.New("the server rejected the credentials of the client")
+
 type connectionStatus = uint
 
 const (
@@ -28,13 +35,28 @@ type client struct {
 	status             connectionStatus
 	conn               *websocket.Conn
 	url                url.URL
+	token              string
+	tls                tlsSettings
+	reconnect          config.ReconnectConfig
 	messagesToSendC    chan interface{}
 	messagesReceivedMC map[uuid.UUID]chan interface{}
+
+	// latest flow control signal of the server (true: pause sending
+	// requests)
+	flowMu sync.Mutex
+	flowC  chan bool
+
+	// closed once the reads have stopped, at shutdown
+	readsDoneC chan struct{}
 }
 
+// maximum duration to wait for the server to acknowledge the close
+// frame of the client
+const closeTimeout = 2 * time.Second
+
 // tryConnect attempts to connect to the server
-// using a backoff strategy
-func (c *client) tryConnect() {
+// using a backoff strategy, until the context is canceled
+func (c *client) tryConnect(ctx context.Context) {
 	// if the client is already try to connect to the server,
 	// just wait until it is connected
 	if c.status == CONNECTING {
@@ -42,7 +64,11 @@ func (c *client) tryConnect() {
 		defer ticker.Stop()
 
 		for {
-			<-ticker.C
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
 			if c.conn == nil {
 				continue
 			}
@@ -57,27 +83,39 @@ func (c *client) tryConnect() {
 		logger.Logger.Debug("trying to connect to server")
 
 		err := c.connect()
+		if errors.Is(err, ErrUnauthorized) {
+			// retrying with the same credentials is pointless
+			metrics.ConnectionAttempts.WithLabelValues("unauthorized").Inc()
+			return backoff.Permanent(err)
+		}
 		if err != nil {
+			metrics.ConnectionAttempts.WithLabelValues("failure").Inc()
 			c.status = CONNECTING
 			logger.Logger.Debug(
 				"reconnection attempt failed",
+				zap.Error(err),
 			)
 			return err
 		}
 
+		metrics.ConnectionAttempts.WithLabelValues("success").Inc()
 		c.status = CONNECTED
 		return nil
 	}
 
 	backoffConfig := backoff.NewExponentialBackOff()
-	backoffConfig.InitialInterval = 1 * time.Second
-	backoffConfig.MaxInterval = 10 * time.Second
-	backoffConfig.MaxElapsedTime = 300 * time.Second
-
-	err := backoff.Retry(operation, backoffConfig)
+	backoffConfig.InitialInterval = time.Duration(c.reconnect.InitialInterval)
+	backoffConfig.MaxInterval = time.Duration(c.reconnect.MaxInterval)
+	backoffConfig.MaxElapsedTime = time.Duration(c.reconnect.MaxElapsedTime)
+
+	err := backoff.Retry(operation, backoff.WithContext(backoffConfig, ctx))
+	if err != nil && ctx.Err() != nil {
+		// the client is shutting down
+		return
+	}
 	if err != nil {
 		logger.Logger.Fatal(
-			"could not reconnect after maximum attempts",
+			"cannot connect to the server",
 			zap.Error(err),
 		)
 	}
@@ -86,27 +124,76 @@ func (c *client) tryConnect() {
 }
 
 func (c *client) connect() error {
-	var err error
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	dialer := *websocket.DefaultDialer
+	if c.tls.enabled {
+		config, err := c.tls.tlsConfig()
+		if err != nil {
+			return err
+		}
+		dialer.TLSClientConfig = config
+	}
 
-	c.conn, _, err = websocket.DefaultDialer.Dial(c.url.String(), nil)
+	conn, resp, err := dialer.Dial(c.url.String(), header)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return ErrUnauthorized
+		}
 		return err
 	}
 
+	c.conn = conn
+
+	// the flow control of a new connection starts unpaused
+	c.setPaused(false)
+
 	return nil
 }
 
+// setPaused records the latest flow control signal (only the latest
+// one matters to the writer); it never blocks, since the buffer of the
+// channel is emptied beforehand
+func (c *client) setPaused(paused bool) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	select {
+	case <-c.flowC:
+	default:
+	}
+
+	c.flowC <- paused
+}
+
 // handle messages to be sent to the server
 func (c *client) handleWrites(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
+	paused := false
+
 	for {
+		// while the server is saturated, the requests stay in the queue
+		messagesToSendC := c.messagesToSendC
+		if paused {
+			messagesToSendC = nil
+		}
+
 		select {
+		case paused = <-c.flowC:
+			if paused {
+				metrics.FlowControlPauses.Inc()
+			}
+			logger.Logger.Debug("flow control", zap.Bool("paused", paused))
+
 		// regularly ping the server
 		case <-ticker.C:
 			if c.conn == nil {
-				c.tryConnect()
+				c.tryConnect(ctx)
 				continue
 			}
 			err := c.send(websocket.PingMessage, []byte{})
@@ -115,40 +202,58 @@ func (c *client) handleWrites(ctx context.Context) {
 					"write error",
 					zap.Error(err),
 				)
-				c.tryConnect()
+				c.tryConnect(ctx)
 				continue
 			}
 
 		// send request to server
-		case message := <-c.messagesToSendC:
+		case message := <-messagesToSendC:
 			err := c.send(websocket.BinaryMessage, message.([]byte))
 			if err != nil {
 				logger.Logger.Error(
 					"write close",
 					zap.Error(err),
 				)
-				c.tryConnect()
+				c.tryConnect(ctx)
 				continue
 			}
 
 		case <-ctx.Done():
-			err := c.send(
-				websocket.CloseMessage,
-				websocket.FormatCloseMessage(
-					websocket.CloseNormalClosure,
-					"",
-				))
-			if err != nil {
-				logger.Logger.Error(
-					"write close",
-					zap.Error(err),
-				)
-			}
+			c.close()
 			return
 		}
 	}
 }
 
+// close sends a close frame to the server, then waits for the server to
+// close the connection in return (or for the timeout) before closing it
+func (c *client) close() {
+	if c.conn == nil {
+		return
+	}
+
+	err := c.send(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(
+			websocket.CloseNormalClosure,
+			"",
+		))
+	if err != nil {
+		logger.Logger.Error(
+			"write close",
+			zap.Error(err),
+		)
+	} else {
+		select {
+		case <-c.readsDoneC:
+		case <-time.After(closeTimeout):
+		}
+	}
+
+	c.conn.Close()
+	logger.Logger.Info("connection to the server closed")
+}
+
 func (c *client) send(messageType int, message []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -158,18 +263,24 @@ func (c *client) send(messageType int, message []byte) error {
 
 // handle messages received from the server
 func (c *client) handleReads(ctx context.Context) {
+	defer close(c.readsDoneC)
+
 	c.conn.SetPongHandler(func(appData string) error {
 		return nil
 	})
 
 	for {
 		_, message, err := c.conn.ReadMessage()
+		if err != nil && ctx.Err() != nil {
+			// the client is shutting down: the connection is not restored
+			return
+		}
 		if err != nil {
 			logger.Logger.Error(
 				"error occurred while reading message from server",
 				zap.Error(err),
 			)
-			c.tryConnect()
+			c.tryConnect(ctx)
 			continue
 		}
 		id, msg, err := processIncomingMessage(message)
@@ -180,6 +291,11 @@ func (c *client) handleReads(ctx context.Context) {
 			)
 		}
 
+		if flowControl, ok := msg.(common.FlowControl); ok {
+			c.setPaused(flowControl.Paused)
+			continue
+		}
+
 		c.mu.RLock()
 		_, ok := c.messagesReceivedMC[id]
 		if !ok {
@@ -191,38 +307,63 @@ func (c *client) handleReads(ctx context.Context) {
 	}
 }
 
+// Run connects the client to the server and exchanges the messages
+// until the context is canceled; done is marked once the connection
+// is closed
 func Run(
 	ctx context.Context,
+	cfg *config.Config,
 	messagesToSendC chan interface{},
 	messagesReceivedMC map[uuid.UUID]chan interface{},
+	done *sync.WaitGroup,
 ) {
-	serverHost := os.Getenv("SERVER_HOST")
-	if len(serverHost) == 0 {
-		serverHost = "localhost"
+	serverUrl := net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+
+	// token authenticating the client to the server
+	token := cfg.Server.Token
+	if len(token) == 0 {
+		logger.Logger.Warn("no server token set: connecting anonymously")
 	}
 
-	serverPort := os.Getenv("SERVER_PORT")
-	if len(serverPort) == 0 {
-		serverPort = "3000"
+	tls, err := newTLSSettings(cfg.TLS)
+	if err != nil {
+		logger.Logger.Fatal("TLS cannot be configured", zap.Error(err))
 	}
 
-	serverUrl := fmt.Sprintf("%s:%s", serverHost, serverPort)
+	scheme := "ws"
+	if tls.enabled {
+		scheme = "wss"
+	} else {
+		logger.Logger.Warn("TLS disabled: the connection to the server is not encrypted")
+	}
 
-	logger.Logger.Sugar().Infof("connecting to %s", serverUrl)
+	logger.Logger.Sugar().Infof("connecting to %s://%s", scheme, serverUrl)
 
 	client := &client{
 		url: url.URL{
-			Scheme: "ws",
+			Scheme: scheme,
 			Host:   serverUrl,
 			Path:   "/",
 		},
+		token:              token,
+		tls:                tls,
+		reconnect:          cfg.Reconnect,
 		messagesToSendC:    messagesToSendC,
 		messagesReceivedMC: messagesReceivedMC,
+		flowC:              make(chan bool, 1),
+		readsDoneC:         make(chan struct{}),
 		status:             NOT_CONNECTED,
 	}
 
-	client.tryConnect()
+	client.tryConnect(ctx)
+	if ctx.Err() != nil {
+		done.Done()
+		return
+	}
 
 	go client.handleReads(ctx)
-	go client.handleWrites(ctx)
+	go func() {
+		defer done.Done()
+		client.handleWrites(ctx)
+	}()
 }
@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/glethuillier/mps/client/internal/common"
 	"github.com/glethuillier/mps/client/internal/logger"
@@ -43,6 +44,9 @@ func processIncomingMessage(msg []byte) (uuid.UUID, interface{}, error) {
 		)
 
 		serverErr := ack.GetError()
+		if quotaErr := decodeQuotaError(ack.QuotaError); quotaErr != nil {
+			return id, quotaErr, nil
+		}
 		if serverErr != "" {
 			return id, fmt.Errorf(serverErr), nil
 		}
@@ -57,22 +61,52 @@ func processIncomingMessage(msg []byte) (uuid.UUID, interface{}, error) {
 			return id, nil, err
 		}
 
-		if file.Error != nil {
+		if quotaErr := decodeQuotaError(file.QuotaError); quotaErr != nil {
+			return id, &common.File{
+				Error: quotaErr,
+			}, nil
+		} else if file.Error != nil {
 			return id, &common.File{
 				Error: fmt.Errorf("error returned by server: %s", *file.Error),
 			}, nil
 		} else {
 			return id, &common.File{
-				Filename: file.Filename,
-				Contents: file.Contents,
-				Proof:    deserializeProof(file.Proof),
+				Filename:       file.Filename,
+				Contents:       file.Contents,
+				Proof:          deserializeProof(file.Proof),
+				Signature:      file.Signature,
+				SignatureKeyId: file.SignatureKeyId,
 			}, nil
 		}
+	// flow control (not related to a request)
+	case messages.MessageType_FLOW_CONTROL:
+		var flowControl messages.FlowControl
+		err = proto.Unmarshal(wrapperMsg.Payload, &flowControl)
+		if err != nil {
+			return id, nil, err
+		}
+
+		return id, common.FlowControl{Paused: flowControl.Paused}, nil
 	}
 
 	return uuid.UUID{}, nil, nil
 }
 
+// decodeQuotaError transforms the details of a quota exceeded by the
+// client into an error (nil if no quota is exceeded)
+func decodeQuotaError(quotaErr *messages.QuotaError) error {
+	if quotaErr == nil {
+		return nil
+	}
+
+	return &common.QuotaError{
+		Limit:      quotaErr.Limit,
+		Max:        quotaErr.Max,
+		Requested:  quotaErr.Requested,
+		RetryAfter: time.Duration(quotaErr.RetryAfterMs) * time.Millisecond,
+	}
+}
+
 // deserializeProof transforms a Protobuf serialized proof sent by the server
 // into a proof part object
 func deserializeProof(proofPaths []*messages.ProofPart) []proofs.ProofPart {
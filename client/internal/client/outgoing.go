@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+
 	"github.com/glethuillier/mps/client/internal/common"
 	"github.com/glethuillier/mps/client/internal/logger"
 	"github.com/google/uuid"
@@ -24,13 +26,18 @@ func GetSender(messagesC chan interface{}) *Sender {
 
 // SendPreflightMessage Protobuf serializes preflight messages
 func (s *Sender) SendPreflightMessage(id uuid.UUID, rootHash string, request common.UploadRequest) {
-	var filenames []string
+	var (
+		filenames []string
+		sizes     []int64
+	)
 	for _, f := range request.Files {
 		filenames = append(filenames, f.Filename)
+		sizes = append(sizes, f.Size)
 	}
 
 	init, err := proto.Marshal(&messages.TransferPreflight{
 		Filenames:     filenames,
+		Sizes:         sizes,
 		HashAlgorithm: messages.HashAlgorithm_SHA512,
 	})
 	if err != nil {
@@ -85,11 +92,12 @@ func (s *Sender) SendDownloadRequest(id uuid.UUID, rootHash string, request comm
 	s.messagesC <- data
 }
 
-// SendFile Protobuf serializes files to be sent to the server
-func (s *Sender) SendFile(id uuid.UUID, rootHash string, request common.File) {
+// SendFile Protobuf serializes files to be sent to the server; the file
+// is not sent (and the error of ctx is returned) once ctx is done
+func (s *Sender) SendFile(ctx context.Context, id uuid.UUID, rootHash, filename string, contents []byte) error {
 	init, err := proto.Marshal(&messages.TransferFile{
-		Filename: request.Filename,
-		Contents: request.Contents,
+		Filename: filename,
+		Contents: contents,
 	})
 	if err != nil {
 		logger.Logger.Error(
@@ -111,5 +119,10 @@ func (s *Sender) SendFile(id uuid.UUID, rootHash string, request common.File) {
 		)
 	}
 
-	s.messagesC <- data
+	select {
+	case s.messagesC <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
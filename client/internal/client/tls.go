@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/glethuillier/mps/client/internal/config"
+)
+
+var ErrPinMismatch = errors.New("the certificate of the server does not match any pin")
+
+// tlsSettings defines how the client secures its connection to the server
+type tlsSettings struct {
+	enabled bool
+
+	// PEM-encoded CA bundle verifying the certificate of the server
+	// (default: the system roots)
+	caFile string
+
+	// PEM-encoded certificate and key of the client (mutual TLS)
+	certFile string
+	keyFile  string
+
+	// hex-encoded SHA-256 digests of the public keys (SPKI) that the
+	// certificate chain of the server must contain
+	pins [][sha256.Size]byte
+}
+
+// newTLSSettings builds the TLS settings from the configuration
+// (already validated)
+func newTLSSettings(cfg config.TLSConfig) (tlsSettings, error) {
+	settings := tlsSettings{
+		enabled:  cfg.Enabled,
+		caFile:   cfg.CAFile,
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+	}
+
+	for _, pin := range cfg.CertPins {
+		digest, err := config.ParsePin(pin)
+		if err != nil {
+			return settings, err
+		}
+		settings.pins = append(settings.pins, digest)
+	}
+
+	return settings, nil
+}
+
+// tlsConfig builds the TLS configuration from the current contents of
+// the files (it is called on each connection, so that renewed
+// certificates are used when the client reconnects)
+func (s tlsSettings) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if s.caFile != "" {
+		pem, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", s.caFile)
+		}
+	}
+
+	if s.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(s.pins) > 0 {
+		// the chain has already been verified against the CA bundle
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state.PeerCertificates, s.pins)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPins checks that one of the certificates of a chain has
+// a pinned public key
+func verifyPins(certs []*x509.Certificate, pins [][sha256.Size]byte) error {
+	for _, cert := range certs {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if digest == pin {
+				return nil
+			}
+		}
+	}
+
+	return ErrPinMismatch
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCertificate(t *testing.T, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return cert
+}
+
+func TestVerifyPins(t *testing.T) {
+	leaf := newCertificate(t, "server")
+	intermediate := newCertificate(t, "intermediate")
+	other := newCertificate(t, "other")
+
+	pin := func(cert *x509.Certificate) [sha256.Size]byte {
+		return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	}
+
+	tests := []struct {
+		name        string
+		pins        [][sha256.Size]byte
+		expectedErr error
+	}{
+		{
+			name: "Pinned leaf",
+			pins: [][sha256.Size]byte{pin(leaf)},
+		},
+		{
+			name: "Pinned intermediate",
+			pins: [][sha256.Size]byte{pin(other), pin(intermediate)},
+		},
+		{
+			name:        "No pinned certificate in the chain",
+			pins:        [][sha256.Size]byte{pin(other)},
+			expectedErr: ErrPinMismatch,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyPins([]*x509.Certificate{leaf, intermediate}, tc.pins)
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
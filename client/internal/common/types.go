@@ -2,6 +2,9 @@ package common
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/glethuillier/mps/lib/pkg/proofs"
 )
@@ -11,10 +14,50 @@ type File struct {
 	Contents []byte
 	Proof    []proofs.ProofPart
 	Error    error
+
+	// signature of the proof by the server and ID of its key; unset if
+	// the server does not sign the proofs
+	Signature      []byte
+	SignatureKeyId string
+}
+
+// UploadedFile is a file received by the API: it is spooled to a
+// temporary file, and hashed, while it is received
+type UploadedFile struct {
+	Filename string
+	Size     int64
+	Hash     []byte
+	File     *os.File
+}
+
+// Remove closes and deletes the temporary file of an uploaded file
+func (f *UploadedFile) Remove() error {
+	return errors.Join(f.File.Close(), os.Remove(f.File.Name()))
 }
 
 type UploadRequest struct {
-	Files []File
+	Files []*UploadedFile
+}
+
+// Receipt describes a batch accepted by the server, as recorded by the
+// client (the files and the creation date of the receipts recorded by
+// older versions of the client are unknown)
+type Receipt struct {
+	ReceiptId     string        `json:"receipt_id"`
+	RootHash      string        `json:"root_hash"`
+	HashAlgorithm string        `json:"hash_algorithm"`
+	CreatedAt     *time.Time    `json:"created_at,omitempty"`
+	FilesCount    int           `json:"files_count"`
+	Size          int64         `json:"size"`
+	Files         []ReceiptFile `json:"files,omitempty"`
+}
+
+// ReceiptFile describes a file of a receipt; its leaf hash is the hash
+// of its contents
+type ReceiptFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	LeafHash string `json:"leaf_hash"`
 }
 
 type DownloadRequest struct {
@@ -22,6 +65,40 @@ type DownloadRequest struct {
 	Filename  string
 }
 
+// FlowControl asks the client to stop sending requests (paused) or
+// to resume
+type FlowControl struct {
+	Paused bool
+}
+
+// QuotaError is returned when the server refuses a request because
+// the client exceeded one of its quotas
+type QuotaError struct {
+	// exceeded limit (e.g., "files_per_batch")
+	Limit string `json:"limit"`
+
+	Max       int64 `json:"max"`
+	Requested int64 `json:"requested,omitempty"`
+
+	// delay before the request can be retried (0 if retrying is
+	// pointless)
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *QuotaError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf(
+			"quota exceeded: %s (max: %d; retry after %s)",
+			e.Limit, e.Max, e.RetryAfter,
+		)
+	}
+
+	return fmt.Sprintf(
+		"quota exceeded: %s (max: %d, requested: %d)",
+		e.Limit, e.Max, e.Requested,
+	)
+}
+
 var ErrMismatchingRoots = errors.New(
 	"the request file is corrupted (root hashes do not match)",
 )
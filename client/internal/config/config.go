@@ -0,0 +1,170 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Config is the configuration of the client; each setting is read from
+// the configuration file (by its key, e.g., `server.host`), from an
+// environment variable (env tag) or from a command-line flag (named
+// after its key, e.g., `-server.host`)
+type Config struct {
+	LogLevel        string   `yaml:"log_level" json:"log_level" env:"LOG_LEVEL" help:"log level (debug, info, warn or error)"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" json:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long the requests in progress can run when the client is shut down"`
+
+	Server    ServerConfig    `yaml:"server" json:"server"`
+	Reconnect ReconnectConfig `yaml:"reconnect" json:"reconnect"`
+	TLS       TLSConfig       `yaml:"tls" json:"tls"`
+	API       APIConfig       `yaml:"api" json:"api"`
+	Database  DatabaseConfig  `yaml:"database" json:"database"`
+	Hash      HashConfig      `yaml:"hash" json:"hash"`
+}
+
+type ServerConfig struct {
+	Host            string   `yaml:"host" json:"host" env:"SERVER_HOST" help:"host of the server"`
+	Port            int      `yaml:"port" json:"port" env:"SERVER_PORT" help:"port of the server"`
+	Token           string   `yaml:"token" json:"token" env:"SERVER_TOKEN" secret:"true" help:"token authenticating the client to the server"`
+	ResponseTimeout Duration `yaml:"response_timeout" json:"response_timeout" env:"RESPONSE_TIMEOUT" help:"how long the client waits for the server to answer a request"`
+}
+
+type ReconnectConfig struct {
+	InitialInterval Duration `yaml:"initial_interval" json:"initial_interval" env:"RECONNECT_INITIAL_INTERVAL" help:"delay before the first reconnection attempt"`
+	MaxInterval     Duration `yaml:"max_interval" json:"max_interval" env:"RECONNECT_MAX_INTERVAL" help:"maximum delay between two reconnection attempts"`
+	MaxElapsedTime  Duration `yaml:"max_elapsed_time" json:"max_elapsed_time" env:"RECONNECT_MAX_ELAPSED_TIME" help:"how long the client tries to reconnect before giving up"`
+}
+
+type TLSConfig struct {
+	Enabled  bool     `yaml:"enabled" json:"enabled" env:"SERVER_TLS" help:"connect to the server over TLS (wss://)"`
+	CAFile   string   `yaml:"ca_file" json:"ca_file" env:"SERVER_CA_FILE" help:"PEM-encoded CA bundle verifying the certificate of the server"`
+	CertPins []string `yaml:"cert_pins" json:"cert_pins" env:"SERVER_CERT_PINS" help:"hex-encoded SHA-256 digests of the pinned public keys of the server (comma-separated)"`
+	CertFile string   `yaml:"cert_file" json:"cert_file" env:"CLIENT_CERT_FILE" help:"PEM-encoded certificate of the client (mutual TLS)"`
+	KeyFile  string   `yaml:"key_file" json:"key_file" env:"CLIENT_KEY_FILE" help:"PEM-encoded key of the client (mutual TLS)"`
+}
+
+type APIConfig struct {
+	Address  string `yaml:"address" json:"address" env:"API_ADDRESS" help:"address the REST API listens on"`
+	Port     int    `yaml:"port" json:"port" env:"API_PORT" help:"port of the REST API (and of its metrics)"`
+	SpoolDir string `yaml:"spool_dir" json:"spool_dir" env:"API_SPOOL_DIR" help:"directory of the uploaded files until they are sent (default: the temporary directory)"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path" json:"path" env:"DATABASE_PATH" help:"path of the SQLite database of the root hashes"`
+}
+
+type HashConfig struct {
+	Algorithm string `yaml:"algorithm" json:"algorithm" env:"HASH_ALGORITHM" help:"hash algorithm of the Merkle trees (sha512)"`
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		LogLevel:        "info",
+		ShutdownTimeout: Duration(30 * time.Second),
+		Server: ServerConfig{
+			Host:            "localhost",
+			Port:            3000,
+			ResponseTimeout: Duration(60 * time.Second),
+		},
+		Reconnect: ReconnectConfig{
+			InitialInterval: Duration(time.Second),
+			MaxInterval:     Duration(10 * time.Second),
+			MaxElapsedTime:  Duration(5 * time.Minute),
+		},
+		API: APIConfig{
+			Address: "0.0.0.0",
+			Port:    3001,
+		},
+		Database: DatabaseConfig{
+			Path: "roots.db",
+		},
+		Hash: HashConfig{
+			Algorithm: "sha512",
+		},
+	}
+}
+
+// Validate checks the settings that do not depend on external files
+// (e.g., the TLS certificates are loaded on each connection); it
+// reports all the invalid settings at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	var level zapcore.Level
+	check(level.Set(c.LogLevel) == nil, "log_level", "unknown level %q", c.LogLevel)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout", "must be positive")
+
+	check(c.Server.Host != "", "server.host", "cannot be empty")
+	check(validPort(c.Server.Port), "server.port", "must be between 1 and 65535")
+	check(c.Server.ResponseTimeout > 0, "server.response_timeout", "must be positive")
+
+	check(c.Reconnect.InitialInterval > 0, "reconnect.initial_interval", "must be positive")
+	check(
+		c.Reconnect.MaxInterval >= c.Reconnect.InitialInterval,
+		"reconnect.max_interval", "cannot be shorter than initial_interval",
+	)
+	check(c.Reconnect.MaxElapsedTime > 0, "reconnect.max_elapsed_time", "must be positive")
+
+	check(
+		(c.TLS.CertFile == "") == (c.TLS.KeyFile == ""),
+		"tls", "cert_file and key_file must be set together",
+	)
+	check(
+		c.TLS.Enabled || (c.TLS.CAFile == "" && c.TLS.CertFile == "" && len(c.TLS.CertPins) == 0),
+		"tls", "settings are set but TLS is not enabled",
+	)
+	for _, pin := range c.TLS.CertPins {
+		_, err := ParsePin(pin)
+		check(err == nil, "tls.cert_pins", "%q is not a SHA-256 digest", pin)
+	}
+
+	check(validPort(c.API.Port), "api.port", "must be between 1 and 65535")
+
+	check(c.Database.Path != "", "database.path", "cannot be empty")
+
+	check(c.Hash.Algorithm == "sha512", "hash.algorithm", "unsupported algorithm %q (supported: sha512)", c.Hash.Algorithm)
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// ParsePin decodes a hex-encoded SHA-256 digest of a public key
+func ParsePin(pin string) ([sha256.Size]byte, error) {
+	digest, err := hex.DecodeString(strings.TrimSpace(pin))
+	if err != nil || len(digest) != sha256.Size {
+		return [sha256.Size]byte{}, fmt.Errorf("%q is not a SHA-256 digest", pin)
+	}
+
+	return [sha256.Size]byte(digest), nil
+}
+
+// Duration is a duration read from its textual form (e.g., "30s")
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.yaml")
+	err := os.WriteFile(path, []byte(`
+server:
+  host: mps.example.com
+  port: 4000
+  token: secret
+api:
+  port: 4001
+reconnect:
+  max_elapsed_time: 1m
+`), 0o600)
+	assert.NoError(t, err)
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("SERVER_PORT", "4443")
+	t.Setenv("SERVER_TLS", "true")
+
+	c, err := Load([]string{"-api.port", "5001", "-database.path", "/data/roots.db"})
+	assert.NoError(t, err)
+
+	// defaults < file < environment < flags
+	assert.Equal(t, "mps.example.com", c.Server.Host)
+	assert.Equal(t, 4443, c.Server.Port)
+	assert.Equal(t, 5001, c.API.Port)
+	assert.Equal(t, "0.0.0.0", c.API.Address)
+	assert.Equal(t, "/data/roots.db", c.Database.Path)
+	assert.Equal(t, Duration(time.Minute), c.Reconnect.MaxElapsedTime)
+	assert.Equal(t, Duration(time.Second), c.Reconnect.InitialInterval)
+	assert.True(t, c.TLS.Enabled)
+
+	// the secrets are redacted, without altering the configuration
+	r := c.Redacted()
+	assert.Equal(t, "REDACTED", r.Server.Token)
+	assert.Equal(t, "secret", c.Server.Token)
+
+	report, err := json.Marshal(r)
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), `"max_elapsed_time":"1m0s"`)
+	assert.NotContains(t, string(report), "secret")
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	unknownKey := filepath.Join(dir, "unknown.yaml")
+	assert.NoError(t, os.WriteFile(unknownKey, []byte("server:\n  hots: mps\n"), 0o600))
+
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		expectedError string
+	}{
+		{
+			name:          "Unknown key in the file",
+			args:          []string{"-config", unknownKey},
+			expectedError: "field hots not found",
+		},
+		{
+			name:          "Invalid environment variable",
+			env:           map[string]string{"SERVER_PORT": "http"},
+			expectedError: "invalid SERVER_PORT",
+		},
+		{
+			name:          "Unexpected argument",
+			args:          []string{"upload"},
+			expectedError: `unexpected argument "upload"`,
+		},
+		{
+			name:          "TLS settings without TLS",
+			env:           map[string]string{"SERVER_CA_FILE": "ca.pem"},
+			expectedError: "tls: settings are set but TLS is not enabled",
+		},
+		{
+			name:          "Invalid pin",
+			args:          []string{"-tls.enabled", "-tls.cert_pins", "abcd"},
+			expectedError: `tls.cert_pins: "abcd" is not a SHA-256 digest`,
+		},
+		{
+			name:          "Unsupported hash algorithm",
+			args:          []string{"-hash.algorithm", "md5"},
+			expectedError: `hash.algorithm: unsupported algorithm "md5"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := Load(tc.args)
+			assert.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Validate())
+
+	// all the invalid settings are reported
+	c.LogLevel = "verbose"
+	c.API.Port = 0
+	c.Reconnect.MaxInterval = Duration(time.Millisecond)
+
+	err := c.Validate()
+	assert.ErrorContains(t, err, "log_level")
+	assert.ErrorContains(t, err, "api.port")
+	assert.ErrorContains(t, err, "reconnect.max_interval")
+}
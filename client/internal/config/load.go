@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+
+	libconfig "github.com/glethuillier/mps/lib/pkg/config"
+)
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the configuration file (flag -config or environment
+// variable CONFIG_FILE), the environment variables and the flags, and
+// validates it
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	args, err := libconfig.Load("client", c, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", args[0])
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return c, nil
+}
+
+// Redacted returns a copy of the configuration whose secrets are
+// replaced (e.g., on the /config endpoint)
+func (c *Config) Redacted() *Config {
+	r := *c
+	libconfig.Redact(&r)
+
+	return &r
+}
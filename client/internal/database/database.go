@@ -5,8 +5,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/glethuillier/mps/client/internal/common"
 	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/client/internal/metrics"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 )
@@ -15,59 +18,252 @@ type Database struct {
 	*sql.DB
 }
 
-// CreateDatabase creates a local SQLite3 database
+// CreateDatabase creates a local SQLite3 database (or opens an
+// existing one) and migrates its schema to the latest version
 func CreateDatabase(dataSourceName string) (*Database, error) {
 	db, err := sql.Open("sqlite3", dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS FILES (
-			ReceiptId TEXT,
-			RootHash BLOB
-		)
-	`)
-	if err != nil {
+	database := &Database{db}
+	if err = database.migrate(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &Database{db}, nil
+	return database, nil
 }
 
-// AddRootHash adds root hashes to the database
-func (db *Database) AddRootHash(ReceiptId string, RootHash []byte) error {
-	query := `
-		INSERT INTO FILES (ReceiptId, RootHash)
-		VALUES (?, ?)
-		`
+// ErrReceiptNotFound is returned when a receipt is not in the database
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// AddReceipt adds a receipt to the database: its root hash and the
+// metadata of its files
+func (db *Database) AddReceipt(receipt *common.Receipt) error {
+	defer metrics.ObserveQuery("add_receipt", time.Now())
+
+	rootHash, err := hex.DecodeString(receipt.RootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	statement, err := db.Prepare(query)
+	_, err = tx.Exec(
+		"INSERT INTO FILES (ReceiptId, RootHash) VALUES (?, ?)",
+		receipt.ReceiptId,
+		rootHash,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return fmt.Errorf("failed to execute statement: %w", err)
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(
-		ReceiptId,
-		RootHash,
+	var createdAt *int64
+	if receipt.CreatedAt != nil {
+		t := receipt.CreatedAt.Unix()
+		createdAt = &t
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO RECEIPTS (receipt_id, root_hash, hash_algorithm, created_at) VALUES (?, ?, ?, ?)",
+		receipt.ReceiptId,
+		rootHash,
+		receipt.HashAlgorithm,
+		createdAt,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement: %w", err)
 	}
 
+	for _, f := range receipt.Files {
+		leafHash, err := hex.DecodeString(f.LeafHash)
+		if err != nil {
+			return fmt.Errorf("invalid leaf hash of %s: %w", f.Filename, err)
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO RECEIPT_FILES (receipt_id, filename, size, leaf_hash) VALUES (?, ?, ?, ?)",
+			receipt.ReceiptId,
+			f.Filename,
+			f.Size,
+			leafHash,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	logger.Logger.Debug(
-		"added root hash to the database",
-		zap.String("receipt_id", ReceiptId),
-		zap.String("root_hash", hex.EncodeToString(RootHash)),
+		"added receipt to the database",
+		zap.String("receipt_id", receipt.ReceiptId),
+		zap.String("root_hash", receipt.RootHash),
+		zap.Int("files", len(receipt.Files)),
 	)
 
 	return nil
 }
 
+const receiptsQuery = `
+	SELECT
+		r.receipt_id,
+		r.root_hash,
+		r.hash_algorithm,
+		r.created_at,
+		COUNT(f.filename),
+		COALESCE(SUM(f.size), 0)
+	FROM
+		RECEIPTS r
+	LEFT JOIN
+		RECEIPT_FILES f
+	ON
+		f.receipt_id = r.receipt_id`
+
+// ListReceipts returns the receipts, from the oldest to the most recent
+// (the receipts whose creation date is unknown first), and the total
+// number of receipts
+func (db *Database) ListReceipts(offset, limit int) ([]common.Receipt, int, error) {
+	defer metrics.ObserveQuery("list_receipts", time.Now())
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM RECEIPTS").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error executing query: %v", err)
+	}
+
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+
+	rows, err := db.Query(receiptsQuery+`
+	GROUP BY
+		r.receipt_id
+	ORDER BY
+		r.created_at, r.rowid
+	LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	receipts := []common.Receipt{}
+	for rows.Next() {
+		receipt, err := scanReceipt(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		receipts = append(receipts, *receipt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return receipts, total, nil
+}
+
+// GetReceipt returns a receipt along with its files
+func (db *Database) GetReceipt(receiptId string) (*common.Receipt, error) {
+	defer metrics.ObserveQuery("get_receipt", time.Now())
+
+	receipt, err := scanReceipt(db.QueryRow(receiptsQuery+`
+	WHERE
+		r.receipt_id = ?
+	GROUP BY
+		r.receipt_id`, receiptId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrReceiptNotFound, receiptId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	SELECT
+		filename,
+		size,
+		leaf_hash
+	FROM
+		RECEIPT_FILES
+	WHERE
+		receipt_id = ?
+	ORDER BY
+		filename`, receiptId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			f        common.ReceiptFile
+			leafHash []byte
+		)
+		if err := rows.Scan(&f.Filename, &f.Size, &leafHash); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		f.LeafHash = hex.EncodeToString(leafHash)
+		receipt.Files = append(receipt.Files, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return receipt, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanReceipt returns a receipt, without its files; sql.ErrNoRows is
+// returned as is
+func scanReceipt(row scanner) (*common.Receipt, error) {
+	var (
+		r         common.Receipt
+		rootHash  []byte
+		createdAt sql.NullInt64
+	)
+
+	err := row.Scan(
+		&r.ReceiptId,
+		&rootHash,
+		&r.HashAlgorithm,
+		&createdAt,
+		&r.FilesCount,
+		&r.Size,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error scanning row: %v", err)
+	}
+
+	r.RootHash = hex.EncodeToString(rootHash)
+	if createdAt.Valid {
+		t := time.Unix(createdAt.Int64, 0).UTC()
+		r.CreatedAt = &t
+	}
+
+	return &r, nil
+}
+
 // GetRootHash retrieves the root hash associated with a given
 // receipt ID
 func (db *Database) GetRootHash(receiptId string) (string, error) {
+	defer metrics.ObserveQuery("get_root_hash", time.Now())
+
 	var rootHash []byte
 	query := `SELECT RootHash FROM FILES WHERE ReceiptId = ?`
 
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceipts(t *testing.T) {
+	logger.Init("")
+
+	path := filepath.Join(t.TempDir(), "roots.db")
+
+	// database created by a client recording only the root hashes
+	legacy, err := sql.Open("sqlite3", path)
+	assert.NoError(t, err)
+
+	initial, err := migrationsFS.ReadFile("migrations/0001_initial.sql")
+	assert.NoError(t, err)
+
+	_, err = legacy.Exec(string(initial))
+	assert.NoError(t, err)
+
+	_, err = legacy.Exec("INSERT INTO FILES (ReceiptId, RootHash) VALUES ('receipt-legacy', x'0102')")
+	assert.NoError(t, err)
+	assert.NoError(t, legacy.Close())
+
+	db, err := CreateDatabase(path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, db.AddReceipt(&common.Receipt{
+		ReceiptId:     "receipt-a",
+		RootHash:      "0a0b",
+		HashAlgorithm: "sha512",
+		CreatedAt:     &createdAt,
+		Files: []common.ReceiptFile{
+			{Filename: "b.txt", Size: 2, LeafHash: "bb"},
+			{Filename: "a.txt", Size: 1, LeafHash: "aa"},
+		},
+	}))
+
+	// the legacy receipts are listed, without their metadata
+	receipts, total, err := db.ListReceipts(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	if assert.Len(t, receipts, 2) {
+		assert.Equal(t, "receipt-legacy", receipts[0].ReceiptId)
+		assert.Equal(t, "0102", receipts[0].RootHash)
+		assert.Nil(t, receipts[0].CreatedAt)
+		assert.Zero(t, receipts[0].FilesCount)
+
+		assert.Equal(t, "receipt-a", receipts[1].ReceiptId)
+		assert.Equal(t, createdAt, *receipts[1].CreatedAt)
+		assert.Equal(t, 2, receipts[1].FilesCount)
+		assert.Equal(t, int64(3), receipts[1].Size)
+		assert.Empty(t, receipts[1].Files)
+	}
+
+	receipts, total, err = db.ListReceipts(1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Len(t, receipts, 1)
+
+	receipt, err := db.GetReceipt("receipt-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "0a0b", receipt.RootHash)
+	assert.Equal(t, []common.ReceiptFile{
+		{Filename: "a.txt", Size: 1, LeafHash: "aa"},
+		{Filename: "b.txt", Size: 2, LeafHash: "bb"},
+	}, receipt.Files)
+
+	// the root hash is used to verify the downloads
+	rootHash, err := db.GetRootHash("receipt-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "0a0b", rootHash)
+
+	_, err = db.GetReceipt("receipt-b")
+	assert.ErrorIs(t, err, ErrReceiptNotFound)
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"embed"
+
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/lib/pkg/schema"
+	"go.uber.org/zap"
+)
+
+// migrationsFS holds the schema migrations
+// (`migrations/{version}_{name}.sql`)
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// ErrUnknownSchema is returned when the database has been migrated
+// by a more recent version of the client
+var ErrUnknownSchema = schema.ErrUnknownVersion
+
+// SchemaVersion returns the version of the schema of the database
+// (0 if no migration has been applied)
+func (db *Database) SchemaVersion() (int, error) {
+	return schema.Version(db.DB)
+}
+
+// migrate applies the pending migrations, each one in a transaction, and
+// refuses to run against a schema more recent than the known migrations
+// (the SQLite database file is used by a single client: the migrations
+// are not locked)
+func (db *Database) migrate() error {
+	migrations, err := schema.Load(migrationsFS, "migrations")
+	if err != nil {
+		return err
+	}
+
+	applied, err := schema.Migrate(db.DB, schema.Dialect{
+		TimestampType: "INTEGER",
+	}, migrations)
+
+	for _, m := range applied {
+		logger.Logger.Info(
+			"database migrated",
+			zap.Int("version", m.Version),
+			zap.String("name", m.Name),
+		)
+	}
+
+	return err
+}
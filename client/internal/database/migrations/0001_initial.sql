@@ -0,0 +1,5 @@
+-- schema of the databases created before versioned migrations
+CREATE TABLE IF NOT EXISTS FILES (
+	ReceiptId TEXT,
+	RootHash  BLOB
+);
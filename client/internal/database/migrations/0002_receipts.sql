@@ -0,0 +1,22 @@
+-- metadata of the receipts: the receipts created before this migration
+-- only have their root hash (their files and creation date are unknown)
+CREATE TABLE RECEIPTS (
+	receipt_id     TEXT    PRIMARY KEY,
+	root_hash      BLOB    NOT NULL,
+	hash_algorithm TEXT    NOT NULL,
+	created_at     INTEGER
+);
+
+CREATE TABLE RECEIPT_FILES (
+	receipt_id TEXT    NOT NULL REFERENCES RECEIPTS (receipt_id),
+	filename   TEXT    NOT NULL,
+	size       INTEGER NOT NULL,
+	leaf_hash  BLOB    NOT NULL,
+	PRIMARY KEY (receipt_id, filename)
+);
+
+INSERT INTO RECEIPTS (receipt_id, root_hash, hash_algorithm)
+SELECT ReceiptId, MIN(RootHash), 'sha512'
+FROM FILES
+WHERE ReceiptId IS NOT NULL AND RootHash IS NOT NULL
+GROUP BY ReceiptId;
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mps_client"
+
+var (
+	// uploads
+
+	Uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Number of upload requests, by result.",
+	}, []string{"result"})
+
+	UploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes of the batches of files accepted by the server.",
+	})
+
+	UploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time to upload a batch of files and get its receipt.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	// downloads
+
+	Downloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Number of download requests, by result.",
+	}, []string{"result"})
+
+	DownloadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Number of bytes of the verified files.",
+	})
+
+	DownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Time to download and verify a file.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	VerificationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verification_failures_total",
+		Help:      "Number of downloaded files whose proof does not match the stored root hash.",
+	})
+
+	// proofs
+
+	MerkleBuildDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "merkle_build_duration_seconds",
+		Help:      "Time to build the Merkle tree of a batch.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	VerificationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "verification_duration_seconds",
+		Help:      "Time to verify the proof of a downloaded file.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	// connection
+
+	ConnectionAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connection_attempts_total",
+		Help:      "Number of attempts to (re)connect to the server, by result.",
+	}, []string{"result"})
+
+	FlowControlPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flow_control_pauses_total",
+		Help:      "Number of times the server asked the client to pause sending requests.",
+	})
+
+	ActiveRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_requests",
+		Help:      "Number of requests waiting for a response from the server.",
+	})
+
+	// database
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time to run database operations, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"operation"})
+)
+
+// ObserveQuery records the duration of a database operation started at
+// a given time (to be deferred)
+func ObserveQuery(operation string, start time.Time) {
+	QueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/logger"
+	libproofs "github.com/glethuillier/mps/lib/pkg/proofs"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// entries of the archives: the files of the receipt, then the manifest
+// (the files are kept apart, so that none of them can be mistaken for
+// the manifest)
+const (
+	archiveFilesPrefix = "files/"
+	archiveManifest    = "manifest.json"
+)
+
+// ErrUnknownFiles is returned when the files of a receipt have not been
+// recorded by the client (receipts recorded by earlier versions)
+var ErrUnknownFiles = errors.New("the files of the receipt are unknown")
+
+// ArchiveManifest describes an archive of a receipt: each file, its
+// hash and its proof, and whether it has been verified
+type ArchiveManifest struct {
+	ReceiptId     string         `json:"receipt_id"`
+	RootHash      string         `json:"root_hash"`
+	HashAlgorithm string         `json:"hash_algorithm"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Verified      bool           `json:"verified"`
+	Files         []ArchiveEntry `json:"files"`
+}
+
+// ArchiveEntry describes a file of an archive; a file that cannot be
+// downloaded or verified is not in the archive, and its error is set
+type ArchiveEntry struct {
+	Filename string              `json:"filename"`
+	Size     int64               `json:"size"`
+	LeafHash string              `json:"leaf_hash"`
+	Proof    []libproofs.Sibling `json:"proof,omitempty"`
+	Verified bool                `json:"verified"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// archiveWriter writes the entries of an archive (tar or zip)
+type archiveWriter interface {
+	writeEntry(name string, data []byte) error
+	Close() error
+}
+
+type tarArchive struct {
+	*tar.Writer
+}
+
+func (a tarArchive) writeEntry(name string, data []byte) error {
+	err := a.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.Write(data)
+	return err
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (a zipArchive) writeEntry(name string, data []byte) error {
+	w, err := a.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// newArchiveWriter returns the writer of an archive format ("tar" or
+// "zip")
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case "tar":
+		return tarArchive{tar.NewWriter(w)}, nil
+	case "zip":
+		return zipArchive{zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q (supported: tar, zip)", format)
+	}
+}
+
+// GetArchivableReceipt returns a receipt whose files are known, so that
+// it can be archived
+func (s *Service) GetArchivableReceipt(receiptId string) (*common.Receipt, error) {
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(receipt.Files) == 0 {
+		return nil, ErrUnknownFiles
+	}
+
+	return receipt, nil
+}
+
+// WriteArchive downloads the files of a receipt one at a time, verifies
+// each of them against the root hash, and streams them as an archive
+// followed by its manifest. A file that does not verify is left out and
+// marked in the manifest, unless strict is set: the archive is then
+// abandoned at the first such file and an error is returned.
+func (s *Service) WriteArchive(
+	ctx context.Context,
+	receipt *common.Receipt,
+	format string,
+	strict bool,
+	w io.Writer,
+) (*ArchiveManifest, error) {
+	archive, err := newArchiveWriter(format, w)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &ArchiveManifest{
+		ReceiptId:     receipt.ReceiptId,
+		RootHash:      receipt.RootHash,
+		HashAlgorithm: receipt.HashAlgorithm,
+		CreatedAt:     time.Now().UTC(),
+		Verified:      true,
+	}
+
+	for _, f := range receipt.Files {
+		entry := ArchiveEntry{
+			Filename: f.Filename,
+			Size:     f.Size,
+			LeafHash: f.LeafHash,
+		}
+
+		file, err := s.ProcessDownloadRequest(ctx, uuid.New(), common.DownloadRequest{
+			ReceiptId: receipt.ReceiptId,
+			Filename:  f.Filename,
+		})
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("file %s: %w", f.Filename, err)
+			}
+
+			logger.Logger.Warn(
+				"file left out of the archive",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.String("filename", f.Filename),
+				zap.Error(err),
+			)
+
+			entry.Error = err.Error()
+			manifest.Verified = false
+			manifest.Files = append(manifest.Files, entry)
+			continue
+		}
+
+		if err = archive.writeEntry(archiveFilesPrefix+f.Filename, file.Contents); err != nil {
+			return nil, err
+		}
+
+		entry.Verified = true
+		entry.Size = int64(len(file.Contents))
+		entry.Proof = libproofs.SiblingsFromProof(file.Proof)
+
+		// (hash of the file as downloaded and verified)
+		h := s.hashFunc()
+		h.Write(file.Contents)
+		entry.LeafHash = hex.EncodeToString(h.Sum(nil))
+
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = archive.writeEntry(archiveManifest, data); err != nil {
+		return nil, err
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
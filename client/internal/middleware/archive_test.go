@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/config"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/lib/pkg/messages"
+	"github.com/glethuillier/mps/lib/pkg/proofs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestService returns a service whose download requests are answered
+// by serve, in place of the server
+func newTestService(t *testing.T, serve func(filename string) *common.File) *Service {
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "roots.db")
+	cfg.Server.ResponseTimeout = config.Duration(time.Second)
+
+	sendC := make(chan interface{})
+	s, err := GetService(cfg, sendC, make(map[uuid.UUID]chan interface{}))
+	assert.NoError(t, err)
+
+	go func() {
+		for data := range sendC {
+			var wrapper messages.WrapperMessage
+			assert.NoError(t, proto.Unmarshal(data.([]byte), &wrapper))
+
+			var request messages.DownloadRequest
+			assert.NoError(t, proto.Unmarshal(wrapper.Payload, &request))
+
+			s.mu.RLock()
+			receivedC := s.messagesReceivedC[uuid.MustParse(wrapper.MessageId)]
+			s.mu.RUnlock()
+
+			receivedC <- serve(request.Filename)
+		}
+	}()
+	t.Cleanup(func() { close(sendC) })
+
+	return s
+}
+
+func TestWriteArchive(t *testing.T) {
+	logger.Init("")
+
+	// (a file named as the manifest of the archive)
+	contents := map[string][]byte{
+		"manifest.json": []byte("a"),
+		"notes.txt":     []byte("bb"),
+	}
+
+	leaves := make(map[string][]byte)
+	for filename, data := range contents {
+		digest := sha512.Sum512(data)
+		leaves[filename] = digest[:]
+	}
+
+	// tree of two files: the sibling of each file is the other file
+	left, right := "manifest.json", "notes.txt"
+	if bytes.Compare(leaves[left], leaves[right]) > 0 {
+		left, right = right, left
+	}
+	root := sha512.Sum512(append(append([]byte{}, leaves[left]...), leaves[right]...))
+	proof := map[string][]proofs.ProofPart{
+		left:  {{SiblingType: proofs.RightSibling, SiblingHash: hex.EncodeToString(leaves[right])}},
+		right: {{SiblingType: proofs.LeftSibling, SiblingHash: hex.EncodeToString(leaves[left])}},
+	}
+
+	receipt := &common.Receipt{
+		ReceiptId:     "0aa9a4bc-7554-4d6b-bebb-77b23dfc321b",
+		RootHash:      hex.EncodeToString(root[:]),
+		HashAlgorithm: "sha512",
+	}
+	for _, filename := range []string{"manifest.json", "notes.txt"} {
+		receipt.Files = append(receipt.Files, common.ReceiptFile{
+			Filename: filename,
+			Size:     int64(len(contents[filename])),
+			LeafHash: hex.EncodeToString(leaves[filename]),
+		})
+	}
+
+	// the server corrupts notes.txt
+	s := newTestService(t, func(filename string) *common.File {
+		data := contents[filename]
+		if filename == "notes.txt" {
+			data = []byte("bB")
+		}
+
+		return &common.File{Filename: filename, Contents: data, Proof: proof[filename]}
+	})
+	assert.NoError(t, s.db.AddReceipt(receipt))
+
+	archived, err := s.GetArchivableReceipt(receipt.ReceiptId)
+	assert.NoError(t, err)
+
+	t.Run("tar", func(t *testing.T) {
+		var b bytes.Buffer
+		manifest, err := s.WriteArchive(context.Background(), archived, "tar", false, &b)
+		assert.NoError(t, err)
+		assert.False(t, manifest.Verified)
+		assert.True(t, manifest.Files[0].Verified)
+		assert.Equal(t, proof["manifest.json"][0].SiblingHash, manifest.Files[0].Proof[0].Hash)
+		assert.False(t, manifest.Files[1].Verified)
+		assert.Equal(t, common.ErrMismatchingRoots.Error(), manifest.Files[1].Error)
+
+		// the corrupted file is left out of the archive
+		entries := make(map[string][]byte)
+		archive := tar.NewReader(&b)
+		for {
+			header, err := archive.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+
+			data, err := io.ReadAll(archive)
+			assert.NoError(t, err)
+			entries[header.Name] = data
+		}
+
+		assert.Len(t, entries, 2)
+		assert.Equal(t, []byte("a"), entries[archiveFilesPrefix+"manifest.json"])
+
+		var written ArchiveManifest
+		assert.NoError(t, json.Unmarshal(entries[archiveManifest], &written))
+		assert.Equal(t, receipt.RootHash, written.RootHash)
+		assert.Len(t, written.Files, 2)
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var b bytes.Buffer
+		_, err := s.WriteArchive(context.Background(), archived, "zip", false, &b)
+		assert.NoError(t, err)
+
+		archive, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		assert.NoError(t, err)
+		assert.Len(t, archive.File, 2)
+	})
+
+	t.Run("proof bundle", func(t *testing.T) {
+		bundle, err := s.GetProofBundle(context.Background(), receipt.ReceiptId, "manifest.json")
+		assert.NoError(t, err)
+		assert.Equal(t, receipt.RootHash, bundle.RootHash)
+
+		// the bundle is verified offline, against the file alone
+		assert.NoError(t, proofs.VerifyBundle(bundle, bytes.NewReader(contents["manifest.json"])))
+		assert.ErrorIs(t, proofs.VerifyBundle(bundle, bytes.NewReader([]byte("A"))), proofs.ErrInvalidProof)
+
+		_, err = s.GetProofBundle(context.Background(), receipt.ReceiptId, "notes.txt")
+		assert.ErrorIs(t, err, common.ErrMismatchingRoots)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := s.WriteArchive(context.Background(), archived, "tar", true, io.Discard)
+		assert.ErrorIs(t, err, common.ErrMismatchingRoots)
+	})
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	libproofs "github.com/glethuillier/mps/lib/pkg/proofs"
+	"github.com/google/uuid"
+)
+
+// NewProofBundle returns the proof bundle of a file downloaded (and
+// verified) from a receipt, so that the file can be verified offline
+func (s *Service) NewProofBundle(receiptId string, file *common.File) (*libproofs.Bundle, error) {
+	rootHash, err := s.db.GetRootHash(receiptId)
+	if err != nil {
+		return nil, err
+	}
+
+	h := s.hashFunc()
+	h.Write(file.Contents)
+
+	bundle := &libproofs.Bundle{
+		Version:       libproofs.BundleVersion,
+		HashAlgorithm: s.hashName,
+		TreeVersion:   libproofs.TreeVersion,
+		ReceiptId:     receiptId,
+		Filename:      file.Filename,
+		LeafHash:      hex.EncodeToString(h.Sum(nil)),
+		Siblings:      libproofs.SiblingsFromProof(file.Proof),
+		RootHash:      rootHash,
+	}
+
+	if len(file.Signature) > 0 {
+		bundle.Signature = &libproofs.Signature{
+			Algorithm: libproofs.SignatureAlgorithm,
+			KeyId:     file.SignatureKeyId,
+			Value:     base64.StdEncoding.EncodeToString(file.Signature),
+		}
+	}
+
+	return bundle, nil
+}
+
+// GetProofBundle downloads a file of a receipt, verifies it, and
+// returns its proof bundle
+func (s *Service) GetProofBundle(ctx context.Context, receiptId, filename string) (*libproofs.Bundle, error) {
+	file, err := s.ProcessDownloadRequest(ctx, uuid.New(), common.DownloadRequest{
+		ReceiptId: receiptId,
+		Filename:  filename,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.NewProofBundle(receiptId, file)
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/lib/pkg/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProofBundle(t *testing.T) {
+	logger.Init("")
+
+	// tree of two files: the sibling of a.txt is b.txt
+	leafA, leafB := sha512.Sum512([]byte("a")), sha512.Sum512([]byte("b"))
+	root := sha512.Sum512(append(leafA[:], leafB[:]...))
+	proof := []proofs.ProofPart{
+		{SiblingType: proofs.RightSibling, SiblingHash: hex.EncodeToString(leafB[:])},
+	}
+
+	receipt := &common.Receipt{
+		ReceiptId:     "7d5c3f0b-2b7e-4f3e-8f1c-3c6a1d8e9b21",
+		RootHash:      hex.EncodeToString(root[:]),
+		HashAlgorithm: "sha512",
+	}
+
+	s := newTestService(t, nil)
+	assert.NoError(t, s.db.AddReceipt(receipt))
+
+	t.Run("unsigned", func(t *testing.T) {
+		bundle, err := s.NewProofBundle(receipt.ReceiptId, &common.File{
+			Filename: "a.txt",
+			Contents: []byte("a"),
+			Proof:    proof,
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, bundle.Signature)
+		assert.NoError(t, proofs.VerifyBundle(bundle, bytes.NewReader([]byte("a"))))
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		public, private, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+
+		// the server signs the payload of the bundle the client builds
+		signed := &proofs.Bundle{
+			Version:       proofs.BundleVersion,
+			HashAlgorithm: "sha512",
+			TreeVersion:   proofs.TreeVersion,
+			ReceiptId:     receipt.ReceiptId,
+			Filename:      "a.txt",
+			LeafHash:      hex.EncodeToString(leafA[:]),
+			Siblings:      proofs.SiblingsFromProof(proof),
+			RootHash:      receipt.RootHash,
+		}
+
+		bundle, err := s.NewProofBundle(receipt.ReceiptId, &common.File{
+			Filename:       "a.txt",
+			Contents:       []byte("a"),
+			Proof:          proof,
+			Signature:      ed25519.Sign(private, proofs.SignedPayload(signed)),
+			SignatureKeyId: proofs.KeyId(public),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, proofs.KeyId(public), bundle.Signature.KeyId)
+		assert.NoError(t, proofs.VerifySignature(bundle, public))
+
+		other, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, proofs.VerifySignature(bundle, other), proofs.ErrInvalidProof)
+	})
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"context"
 	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"sync"
@@ -10,8 +12,10 @@ import (
 
 	"github.com/glethuillier/mps/client/internal/client"
 	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/config"
 	"github.com/glethuillier/mps/client/internal/database"
 	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/client/internal/metrics"
 	"github.com/glethuillier/mps/client/internal/proofs"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -19,19 +23,24 @@ import (
 
 // receiveDataWithTimeout monitors incoming messages from
 // the server and times out if the server has not sent
-// an expected message in a given time
+// an expected message in a given time; an error received
+// on errC (if not nil) is returned at once
 func receiveDataWithTimeout(
 	ctx context.Context,
 	messagesReceivedC chan interface{},
+	errC <-chan error,
+	timeout time.Duration,
 ) (interface{}, error) {
-	// TODO: timeout should be configurable
-	timer := time.After(60 * time.Second)
+	timer := time.After(timeout)
 
 	for {
 		select {
 		case message := <-messagesReceivedC:
 			return message, nil
 
+		case err := <-errC:
+			return nil, err
+
 		case <-timer:
 			return nil,
 				fmt.Errorf("the server has not processed all files")
@@ -46,8 +55,22 @@ type Service struct {
 	mu                sync.RWMutex
 	db                *database.Database
 	hashAlgorithm     hash.Hash
+	hashFunc          func() hash.Hash
+	hashName          string
+	responseTimeout   time.Duration
 	sender            *client.Sender
 	messagesReceivedC map[uuid.UUID]chan interface{}
+
+	// directory of the uploaded files while they are sent (the
+	// temporary directory of the system if empty)
+	spoolDir string
+
+	// requests in progress (new requests are refused once the
+	// client is shutting down, and idleC is closed once they are
+	// complete)
+	draining       bool
+	activeRequests int
+	idleC          chan struct{}
 }
 
 func (s *Service) addReceiveChan(id uuid.UUID) {
@@ -64,22 +87,44 @@ func (s *Service) deleteReceiveChan(id uuid.UUID) {
 	delete(s.messagesReceivedC, id)
 }
 
-func GetService(messagesToSendC chan interface{}, messagesReceivedC map[uuid.UUID]chan interface{}) (*Service, error) {
+// newHash returns the hash function of the Merkle trees named in the
+// configuration
+func newHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+func GetService(
+	cfg *config.Config,
+	messagesToSendC chan interface{},
+	messagesReceivedC map[uuid.UUID]chan interface{},
+) (*Service, error) {
 	sender := client.GetSender(messagesToSendC)
 
-	db, err := database.CreateDatabase("roots.db")
+	hashFunc, err := newHash(cfg.Hash.Algorithm)
 	if err != nil {
-		return nil, fmt.Errorf("the database cannot be created: %w", err)
+		return nil, err
 	}
 
-	// TODO: make it configurable
-	hashAlgorithm := sha512.New()
+	db, err := database.CreateDatabase(cfg.Database.Path)
+	if err != nil {
+		return nil, fmt.Errorf("the database cannot be created: %w", err)
+	}
 
 	return &Service{
 		db:                db,
-		hashAlgorithm:     hashAlgorithm,
+		hashAlgorithm:     hashFunc(),
+		hashFunc:          hashFunc,
+		hashName:          cfg.Hash.Algorithm,
+		responseTimeout:   time.Duration(cfg.Server.ResponseTimeout),
 		sender:            sender,
 		messagesReceivedC: messagesReceivedC,
+		spoolDir:          cfg.API.SpoolDir,
+		idleC:             make(chan struct{}),
 	}, nil
 }
 
@@ -87,27 +132,60 @@ func (s *Service) ProcessUploadRequest(
 	ctx context.Context,
 	requestId uuid.UUID,
 	request common.UploadRequest,
-) (string, error) {
+) (receiptId string, err error) {
 	s.addReceiveChan(requestId)
 	defer s.deleteReceiveChan(requestId)
 
-	// build the Merkle tree
-	tree, err := proofs.BuildMerkleTree(s.hashAlgorithm, request.Files)
+	start := time.Now()
+	metrics.ActiveRequests.Inc()
+	defer func() {
+		metrics.ActiveRequests.Dec()
+		metrics.UploadDuration.Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			metrics.Uploads.WithLabelValues("error").Inc()
+			return
+		}
+
+		metrics.Uploads.WithLabelValues("success").Inc()
+		for _, f := range request.Files {
+			metrics.UploadedBytes.Add(float64(f.Size))
+		}
+	}()
+
+	// build the Merkle tree from the hashes computed while the files
+	// were spooled
+	var leaves [][]byte
+	for _, f := range request.Files {
+		leaves = append(leaves, f.Hash)
+	}
+
+	buildStart := time.Now()
+	tree, err := proofs.BuildMerkleTreeFromLeaves(s.hashAlgorithm, leaves)
+	metrics.MerkleBuildDuration.Observe(time.Since(buildStart).Seconds())
 	if err != nil {
 		logger.Logger.Error("cannot build the tree",
 			zap.Error(err))
+		return "", err
 	}
 
 	rootHash := tree.Root.GetHashAsString()
 
 	s.sender.SendPreflightMessage(requestId, rootHash, request)
 
-	for _, f := range request.Files {
-		go s.sender.SendFile(requestId, rootHash, f)
-	}
+	// the files are read from the spool one at a time, so that only the
+	// file being sent is kept in memory
+	// (a file that cannot be read fails the request without waiting
+	// for the server)
+	// (the files are no longer sent once the request returns)
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+
+	sendErrC := make(chan error, 1)
+	go s.sendFiles(sendCtx, requestId, rootHash, request.Files, sendErrC)
 
 	// get the confirmation from the server
-	response, err := receiveDataWithTimeout(ctx, s.messagesReceivedC[requestId])
+	response, err := receiveDataWithTimeout(ctx, s.messagesReceivedC[requestId], sendErrC, s.responseTimeout)
 	if err != nil {
 		return "", err
 	}
@@ -116,7 +194,30 @@ func (s *Service) ProcessUploadRequest(
 	case error:
 		return "", resp
 	case string:
-		s.db.AddRootHash(resp, tree.Root.GetHash())
+		createdAt := time.Now().UTC()
+		receipt := &common.Receipt{
+			ReceiptId:     resp,
+			RootHash:      rootHash,
+			HashAlgorithm: s.hashName,
+			CreatedAt:     &createdAt,
+		}
+		for _, f := range request.Files {
+			receipt.Files = append(receipt.Files, common.ReceiptFile{
+				Filename: f.Filename,
+				Size:     f.Size,
+				LeafHash: hex.EncodeToString(f.Hash),
+			})
+		}
+
+		if err := s.db.AddReceipt(receipt); err != nil {
+			// the files are stored by the server: the receipt ID is
+			// returned anyway
+			logger.Logger.Error(
+				"the receipt cannot be saved in the database",
+				zap.String("receipt_id", resp),
+				zap.Error(err),
+			)
+		}
 		return resp, nil
 	}
 
@@ -127,14 +228,32 @@ func (s *Service) ProcessDownloadRequest(
 	ctx context.Context,
 	requestId uuid.UUID,
 	request common.DownloadRequest,
-) (*common.File, error) {
+) (downloaded *common.File, err error) {
 	s.addReceiveChan(requestId)
 	defer s.deleteReceiveChan(requestId)
 
+	start := time.Now()
+	metrics.ActiveRequests.Inc()
+	defer func() {
+		metrics.ActiveRequests.Dec()
+		metrics.DownloadDuration.Observe(time.Since(start).Seconds())
+
+		switch {
+		case errors.Is(err, common.ErrMismatchingRoots):
+			metrics.Downloads.WithLabelValues("verification_failed").Inc()
+			metrics.VerificationFailures.Inc()
+		case err != nil:
+			metrics.Downloads.WithLabelValues("error").Inc()
+		default:
+			metrics.Downloads.WithLabelValues("success").Inc()
+			metrics.DownloadedBytes.Add(float64(len(downloaded.Contents)))
+		}
+	}()
+
 	s.sender.SendDownloadRequest(requestId, request.ReceiptId, request)
 
 	// get the file from the server
-	data, err := receiveDataWithTimeout(ctx, s.messagesReceivedC[requestId])
+	data, err := receiveDataWithTimeout(ctx, s.messagesReceivedC[requestId], nil, s.responseTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -155,10 +274,23 @@ func (s *Service) ProcessDownloadRequest(
 	}
 
 	// verify the proof
+	verificationStart := time.Now()
 	verificationErr := proofs.VerifyFile(s.hashAlgorithm, file, rootHash, file.Proof)
+	metrics.VerificationDuration.Observe(time.Since(verificationStart).Seconds())
 	if verificationErr != nil {
 		return nil, common.ErrMismatchingRoots
 	} else {
 		return file, nil
 	}
 }
+
+// ListReceipts returns the receipts recorded by the client, from the
+// oldest to the most recent, and the total number of receipts
+func (s *Service) ListReceipts(offset, limit int) ([]common.Receipt, int, error) {
+	return s.db.ListReceipts(offset, limit)
+}
+
+// GetReceipt returns a receipt recorded by the client and its files
+func (s *Service) GetReceipt(receiptId string) (*common.Receipt, error) {
+	return s.db.GetReceipt(receiptId)
+}
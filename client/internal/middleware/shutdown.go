@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/glethuillier/mps/client/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ErrShuttingDown is returned to the requests refused while the client
+// is shutting down
+var ErrShuttingDown = errors.New("the client is shutting down")
+
+// BeginRequest registers a request in progress, unless the client is
+// shutting down
+func (s *Service) BeginRequest() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return ErrShuttingDown
+	}
+
+	s.activeRequests++
+	return nil
+}
+
+// EndRequest unregisters a request in progress
+func (s *Service) EndRequest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.activeRequests--
+	if s.draining && s.activeRequests == 0 {
+		close(s.idleC)
+	}
+}
+
+// Shutdown refuses the new requests, then waits until the requests in
+// progress are complete or the context is canceled
+func (s *Service) Shutdown(ctx context.Context) {
+	s.mu.Lock()
+	if !s.draining {
+		s.draining = true
+		if s.activeRequests == 0 {
+			close(s.idleC)
+		}
+	}
+	active := s.activeRequests
+	s.mu.Unlock()
+
+	logger.Logger.Info("waiting for the requests in progress", zap.Int("requests", active))
+
+	select {
+	case <-s.idleC:
+	case <-ctx.Done():
+		s.mu.RLock()
+		logger.Logger.Warn(
+			"requests abandoned",
+			zap.Int("requests", s.activeRequests),
+		)
+		s.mu.RUnlock()
+	}
+}
+
+// Close closes the database
+func (s *Service) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	logger.Init("")
+
+	t.Run("requests in progress", func(t *testing.T) {
+		s := newTestService(t, nil)
+
+		assert.NoError(t, s.BeginRequest())
+		assert.NoError(t, s.BeginRequest())
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			s.EndRequest()
+			time.Sleep(100 * time.Millisecond)
+			s.EndRequest()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// the shutdown waits for both requests; the new ones are refused
+		start := time.Now()
+		s.Shutdown(ctx)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.NoError(t, ctx.Err())
+		assert.ErrorIs(t, s.BeginRequest(), ErrShuttingDown)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		s := newTestService(t, nil)
+		assert.NoError(t, s.BeginRequest())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		s.Shutdown(ctx)
+		assert.Error(t, ctx.Err())
+		assert.ErrorIs(t, s.BeginRequest(), ErrShuttingDown)
+
+		// the request abandoned at the deadline can still end
+		s.EndRequest()
+	})
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// SpoolFile writes a file being received to a temporary file, hashing
+// it on the fly; the caller removes the file once it has been uploaded
+func (s *Service) SpoolFile(filename string, r io.Reader) (*common.UploadedFile, error) {
+	f, err := os.CreateTemp(s.spoolDir, "mps-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := &common.UploadedFile{
+		Filename: filename,
+		File:     f,
+	}
+
+	h := s.hashFunc()
+	uploaded.Size, err = io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		if err := uploaded.Remove(); err != nil {
+			logger.Logger.Error(
+				"the spooled file cannot be removed",
+				zap.String("path", f.Name()),
+				zap.Error(err),
+			)
+		}
+		return nil, err
+	}
+
+	uploaded.Hash = h.Sum(nil)
+
+	return uploaded, nil
+}
+
+// sendFiles sends the files of a batch to the server, one at a time; if
+// a file cannot be read, the error is sent to errC and the batch is
+// abandoned. It stops once ctx is done (i.e., once the request has
+// returned, the spooled files being then removed).
+func (s *Service) sendFiles(
+	ctx context.Context,
+	requestId uuid.UUID,
+	rootHash string,
+	files []*common.UploadedFile,
+	errC chan<- error,
+) {
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
+		contents, err := io.ReadAll(io.NewSectionReader(f.File, 0, f.Size))
+		if err != nil {
+			logger.Logger.Error(
+				"the spooled file cannot be read",
+				zap.String("filename", f.Filename),
+				zap.Error(err),
+			)
+			select {
+			case errC <- fmt.Errorf("the file %s cannot be read: %w", f.Filename, err):
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		if err := s.sender.SendFile(ctx, requestId, rootHash, f.Filename, contents); err != nil {
+			return
+		}
+	}
+}
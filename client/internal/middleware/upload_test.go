@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/config"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/lib/pkg/messages"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// newUploadTestService returns a service spooling the files to spoolDir,
+// whose batches are accepted once all their files have been received (in
+// place of the server); the files received are recorded in received
+func newUploadTestService(
+	t *testing.T,
+	spoolDir string,
+	expectedFiles int,
+	received map[string][]byte,
+) *Service {
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "roots.db")
+	cfg.Server.ResponseTimeout = config.Duration(time.Minute)
+	cfg.API.SpoolDir = spoolDir
+
+	sendC := make(chan interface{})
+	s, err := GetService(cfg, sendC, make(map[uuid.UUID]chan interface{}))
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	go func() {
+		for data := range sendC {
+			var wrapper messages.WrapperMessage
+			assert.NoError(t, proto.Unmarshal(data.([]byte), &wrapper))
+
+			if wrapper.Type != messages.MessageType_TRANSFER_FILE {
+				continue
+			}
+
+			var file messages.TransferFile
+			assert.NoError(t, proto.Unmarshal(wrapper.Payload, &file))
+
+			mu.Lock()
+			received[file.Filename] = file.Contents
+			complete := len(received) == expectedFiles
+			mu.Unlock()
+
+			if complete {
+				s.mu.RLock()
+				receivedC := s.messagesReceivedC[uuid.MustParse(wrapper.MessageId)]
+				s.mu.RUnlock()
+
+				receivedC <- uuid.NewString()
+			}
+		}
+	}()
+	t.Cleanup(func() { close(sendC) })
+
+	return s
+}
+
+// spoolMultipart spools the files of a multipart body, as the upload
+// handler does
+func spoolMultipart(t *testing.T, s *Service, contents map[string][]byte) []*common.UploadedFile {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for filename, data := range contents {
+		w, err := form.CreateFormFile("file", filename)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, form.Close())
+
+	var files []*common.UploadedFile
+	reader := multipart.NewReader(&body, form.Boundary())
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		file, err := s.SpoolFile(part.FileName(), part)
+		assert.NoError(t, err)
+		files = append(files, file)
+	}
+
+	return files
+}
+
+func TestUploadSpooledFiles(t *testing.T) {
+	logger.Init("")
+
+	contents := map[string][]byte{
+		"a.txt": []byte("contents of a"),
+		"b.txt": bytes.Repeat([]byte("b"), 1<<20),
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		received := make(map[string][]byte)
+		s := newUploadTestService(t, spoolDir, len(contents), received)
+
+		files := spoolMultipart(t, s, contents)
+		assert.Len(t, files, len(contents))
+
+		// the files are hashed while they are spooled
+		for _, f := range files {
+			digest := sha512.Sum512(contents[f.Filename])
+			assert.Equal(t, digest[:], f.Hash)
+			assert.Equal(t, int64(len(contents[f.Filename])), f.Size)
+		}
+
+		spooled, err := os.ReadDir(spoolDir)
+		assert.NoError(t, err)
+		assert.Len(t, spooled, len(contents))
+
+		receiptId, err := s.ProcessUploadRequest(context.Background(), uuid.New(), common.UploadRequest{Files: files})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, receiptId)
+		assert.Equal(t, contents, received)
+
+		for _, f := range files {
+			assert.NoError(t, f.Remove())
+		}
+
+		spooled, err = os.ReadDir(spoolDir)
+		assert.NoError(t, err)
+		assert.Empty(t, spooled)
+	})
+
+	t.Run("unreadable file", func(t *testing.T) {
+		s := newUploadTestService(t, t.TempDir(), len(contents), make(map[string][]byte))
+
+		files := spoolMultipart(t, s, contents)
+		for _, f := range files {
+			assert.NoError(t, f.File.Close())
+		}
+
+		// the request fails without waiting for the server
+		start := time.Now()
+		_, err := s.ProcessUploadRequest(context.Background(), uuid.New(), common.UploadRequest{Files: files})
+		assert.ErrorContains(t, err, "cannot be read")
+		assert.Less(t, time.Since(start), 10*time.Second)
+	})
+}
+
+func TestSendFilesStopsWhenDone(t *testing.T) {
+	logger.Init("")
+
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "roots.db")
+	cfg.API.SpoolDir = t.TempDir()
+
+	// nothing reads the messages to send: the first file blocks
+	s, err := GetService(cfg, make(chan interface{}), make(map[uuid.UUID]chan interface{}))
+	assert.NoError(t, err)
+
+	files := spoolMultipart(t, s, map[string][]byte{
+		"a.txt": []byte("a"),
+		"b.txt": []byte("b"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.sendFiles(ctx, uuid.New(), "root", files, make(chan error, 1))
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the files are still being sent")
+	}
+}
@@ -22,7 +22,25 @@ type node struct {
 
 // BuildMerkleTree builds a Merkle tree based from a list of files
 func BuildMerkleTree(hashAlgorithm hash.Hash, files []common.File) (*Tree, error) {
-	if len(files) == 0 {
+	hasher := GetHasher(hashAlgorithm)
+
+	var leaves [][]byte
+	for _, f := range files {
+		h, err := hasher.hashLeaf(&f)
+		if err != nil {
+			return nil, err
+		}
+
+		leaves = append(leaves, h)
+	}
+
+	return BuildMerkleTreeFromLeaves(hashAlgorithm, leaves)
+}
+
+// BuildMerkleTreeFromLeaves builds a Merkle tree from the hashes of the
+// files (e.g., computed while the files were received)
+func BuildMerkleTreeFromLeaves(hashAlgorithm hash.Hash, leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
 		return nil, fmt.Errorf("no files to process")
 	}
 
@@ -30,15 +48,10 @@ func BuildMerkleTree(hashAlgorithm hash.Hash, files []common.File) (*Tree, error
 		Hasher: *GetHasher(hashAlgorithm),
 	}
 
-	nodes := make([]*node, 0)
+	nodes := make([]*node, 0, len(leaves))
 
 	// create the nodes-leaves
-	for _, f := range files {
-		h, err := t.Hasher.hashLeaf(&f)
-		if err != nil {
-			return nil, err
-		}
-
+	for _, h := range leaves {
 		nodes = append(nodes, &node{hash: h})
 	}
 
@@ -135,6 +135,32 @@ func TestBuildMerkleTree(t *testing.T) {
 	}
 }
 
+func TestBuildMerkleTreeFromLeaves(t *testing.T) {
+	files := []common.File{
+		{Contents: []byte{1}},
+		{Contents: []byte{2}},
+		{Contents: []byte{3}},
+	}
+
+	var leaves [][]byte
+	for _, f := range files {
+		digest := sha512.Sum512(f.Contents)
+		leaves = append(leaves, digest[:])
+	}
+
+	// the tree built from the hashes of the files is the tree built
+	// from the files
+	expected, err := BuildMerkleTree(sha512.New(), files)
+	assert.NoError(t, err)
+
+	tree, err := BuildMerkleTreeFromLeaves(sha512.New(), leaves)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Root.GetHashAsString(), tree.Root.GetHashAsString())
+
+	_, err = BuildMerkleTreeFromLeaves(sha512.New(), nil)
+	assert.Error(t, err)
+}
+
 // transparentHash is a hash algorithm that... does not
 // hash the input data (the purpose of this custom hash
 // algorithm is to help ensure that the tree is build
@@ -2,35 +2,102 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/glethuillier/mps/client/internal/common"
+	"github.com/glethuillier/mps/client/internal/config"
+	"github.com/glethuillier/mps/client/internal/database"
 	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/client/internal/metrics"
 	"github.com/glethuillier/mps/client/internal/middleware"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type serverResponse struct {
-	ReceiptId string `json:"receiptId,omitempty"`
-	Error     string `json:"error,omitempty"`
+	ReceiptId string             `json:"receiptId,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Quota     *common.QuotaError `json:"quota,omitempty"`
+}
+
+type receiptsResponse struct {
+	Receipts []common.Receipt `json:"receipts"`
+	Total    int              `json:"total"`
 }
 
 type downloadRequest struct {
 	ReceiptId string `json:"receipt_id"`
 	Filename  string `json:"filename"`
+
+	// attach the proof bundle of the file (Proof-Bundle header)
+	ProofBundle bool `json:"proof_bundle"`
+}
+
+// trackRequest registers the requests in progress, so that the client
+// lets them finish when it is shut down, and refuses the requests
+// received while it is shutting down
+func trackRequest(service *middleware.Service, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := service.BeginRequest(); err != nil {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			err := json.NewEncoder(w).Encode(serverResponse{Error: err.Error()})
+			if err != nil {
+				logger.Logger.Error(
+					"cannot send error",
+					zap.Error(err),
+				)
+			}
+			return
+		}
+		defer service.EndRequest()
+
+		next(w, r)
+	}
+}
+
+// writeError sends the error returned by the middleware: the requests
+// refused because the client exceeded a quota of the server are
+// answered with 429 Too Many Requests, along with the exceeded quota
+func writeError(w http.ResponseWriter, err error) {
+	response := serverResponse{Error: err.Error()}
+	status := http.StatusInternalServerError
+
+	var quotaErr *common.QuotaError
+	if errors.As(err, &quotaErr) {
+		response.Quota = quotaErr
+		status = http.StatusTooManyRequests
+
+		if quotaErr.RetryAfter > 0 {
+			seconds := int(math.Ceil(quotaErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Logger.Error(
+			"cannot send error",
+			zap.Error(err),
+		)
+	}
 }
 
-// uploadFilesHandler handles requests to upload a batch of files
-func uploadFilesHandler(
-	ctx context.Context,
-	service *middleware.Service,
-) http.HandlerFunc {
+// uploadFilesHandler handles requests to upload a batch of files: the
+// parts of the form are streamed to the spool of the middleware, so
+// that the batch is never held in memory
+func uploadFilesHandler(ctx context.Context, service *middleware.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := r.ParseMultipartForm(32 << 20) // limit to 32Mb
+		reader, err := r.MultipartReader()
 		if err != nil {
 			http.Error(w, "Unable to parse form", http.StatusBadRequest)
 			logger.Logger.Error(
@@ -40,29 +107,63 @@ func uploadFilesHandler(
 			return
 		}
 
-		form := r.MultipartForm
-		files := form.File["file"]
-
-		var uploadedFiles []common.File
+		var uploadedFiles []*common.UploadedFile
+		defer func() {
+			for _, f := range uploadedFiles {
+				if err := f.Remove(); err != nil {
+					logger.Logger.Error(
+						"the spooled file cannot be removed",
+						zap.String("filename", f.Filename),
+						zap.Error(err),
+					)
+				}
+			}
+		}()
 
-		for _, fileHeader := range files {
-			file, err := fileHeader.Open()
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
 			if err != nil {
-				http.Error(w, "Unable to open file", http.StatusInternalServerError)
+				http.Error(w, "Unable to parse form", http.StatusBadRequest)
+				logger.Logger.Error(
+					"unable to parse request form",
+					zap.Error(err),
+				)
+				return
+			}
+
+			// the other fields of the form are ignored
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			if part.FileName() == "" {
+				part.Close()
+				http.Error(w, "Missing filename", http.StatusBadRequest)
 				return
 			}
-			file.Close()
 
-			// get contents
-			contents, err := io.ReadAll(file)
+			file, err := service.SpoolFile(part.FileName(), part)
+			part.Close()
 			if err != nil {
 				http.Error(w, "Unable to get file contents", http.StatusInternalServerError)
+				logger.Logger.Error(
+					"unable to spool file",
+					zap.String("filename", part.FileName()),
+					zap.Error(err),
+				)
+				return
 			}
 
-			uploadedFiles = append(uploadedFiles, common.File{
-				Filename: fileHeader.Filename,
-				Contents: contents,
-			})
+			uploadedFiles = append(uploadedFiles, file)
+		}
+
+		if len(uploadedFiles) == 0 {
+			http.Error(w, "No file", http.StatusBadRequest)
+			return
 		}
 
 		requestID := uuid.New()
@@ -74,14 +175,7 @@ func uploadFilesHandler(
 			})
 
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			err = json.NewEncoder(w).Encode(serverResponse{Error: err.Error()})
-			if err != nil {
-				logger.Logger.Error(
-					"cannot send error",
-					zap.Error(err),
-				)
-			}
+			writeError(w, err)
 		} else {
 			w.WriteHeader(http.StatusOK)
 			err := json.NewEncoder(w).Encode(serverResponse{ReceiptId: receiptId})
@@ -120,39 +214,275 @@ func downloadFilesHandler(ctx context.Context, service *middleware.Service) http
 			},
 		)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			err := json.NewEncoder(w).Encode(serverResponse{Error: file.Error.Error()})
+			writeError(w, err)
+			return
+		}
+
+		// the proof bundle (base64-encoded JSON) is attached on demand
+		var proofBundle string
+		if req.ProofBundle {
+			bundle, err := service.NewProofBundle(req.ReceiptId, file)
 			if err != nil {
-				logger.Logger.Error(
-					"cannot send error",
-					zap.Error(err),
-				)
+				writeError(w, err)
+				return
 			}
-		} else {
-			// return file
-			w.Header().Set("Content-Disposition", "attachment; filename="+file.Filename)
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(file.Contents)))
-
-			// custom proof-related headers
-			for i, p := range file.Proof {
-				w.Header().Set(
-					fmt.Sprintf("Proof-Sibling-%d-%s", i, p.SiblingType.String()),
-					p.SiblingHash,
-				)
+
+			data, err := json.Marshal(bundle)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			proofBundle = base64.StdEncoding.EncodeToString(data)
+		}
+
+		// return file
+		w.Header().Set("Content-Disposition", "attachment; filename="+file.Filename)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(file.Contents)))
+
+		// custom proof-related headers
+		for i, p := range file.Proof {
+			w.Header().Set(
+				fmt.Sprintf("Proof-Sibling-%d-%s", i, p.SiblingType.String()),
+				p.SiblingHash,
+			)
+		}
+
+		w.Header().Set("Proof-Root-Hash", req.ReceiptId)
+
+		if proofBundle != "" {
+			w.Header().Set("Proof-Bundle", proofBundle)
+		}
+
+		w.Write(file.Contents)
+	}
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Logger.Error(
+			"cannot send response",
+			zap.Error(err),
+		)
+	}
+}
+
+// maxReceiptsLimit is the maximum number of receipts listed at once
+const maxReceiptsLimit = 1000
+
+// listReceiptsHandler lists the receipts recorded by the client, from
+// the oldest to the most recent
+func listReceiptsHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		offset, limit := 0, 100
+		for name, dest := range map[string]*int{
+			"offset": &offset,
+			"limit":  &limit,
+		} {
+			if v := query.Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					writeJSON(w, http.StatusBadRequest, serverResponse{Error: "invalid " + name})
+					return
+				}
+				*dest = n
+			}
+		}
+
+		if limit == 0 || limit > maxReceiptsLimit {
+			writeJSON(w, http.StatusBadRequest, serverResponse{
+				Error: fmt.Sprintf("invalid limit (must be between 1 and %d)", maxReceiptsLimit),
+			})
+			return
+		}
+
+		receipts, total, err := service.ListReceipts(offset, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, receiptsResponse{Receipts: receipts, Total: total})
+	}
+}
+
+// getReceiptHandler shows a receipt and its files
+func getReceiptHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		receipt, err := service.GetReceipt(r.PathValue("id"))
+		if errors.Is(err, database.ErrReceiptNotFound) {
+			writeJSON(w, http.StatusNotFound, serverResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, receipt)
+	}
+}
+
+// archiveContentTypes are the content types of the archive formats
+var archiveContentTypes = map[string]string{
+	"tar": "application/x-tar",
+	"zip": "application/zip",
+}
+
+// responseTracker records whether a response has been started
+type responseTracker struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *responseTracker) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// archiveHandler streams the files of a receipt, each verified against
+// the root hash, as an archive (tar or zip) ending with a manifest; the
+// Archive-Verified trailer reports whether all the files are verified
+func archiveHandler(ctx context.Context, service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "tar"
+		}
+
+		contentType, ok := archiveContentTypes[format]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, serverResponse{Error: "invalid format (supported: tar, zip)"})
+			return
+		}
+
+		strict := r.URL.Query().Get("strict") == "true"
+
+		receipt, err := service.GetArchivableReceipt(r.PathValue("id"))
+		switch {
+		case errors.Is(err, database.ErrReceiptNotFound):
+			writeJSON(w, http.StatusNotFound, serverResponse{Error: err.Error()})
+			return
+		case errors.Is(err, middleware.ErrUnknownFiles):
+			writeJSON(w, http.StatusConflict, serverResponse{Error: err.Error()})
+			return
+		case err != nil:
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", "attachment; filename="+receipt.ReceiptId+"."+format)
+		w.Header().Set("Trailer", "Archive-Verified")
+
+		tracker := &responseTracker{ResponseWriter: w}
+		manifest, err := service.WriteArchive(ctx, receipt, format, strict, tracker)
+		if err != nil {
+			logger.Logger.Error(
+				"the archive cannot be sent",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.Error(err),
+			)
+
+			if !tracker.started {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Disposition")
+				w.Header().Del("Trailer")
+				if errors.Is(err, common.ErrMismatchingRoots) {
+					writeJSON(w, http.StatusBadGateway, serverResponse{Error: err.Error()})
+				} else {
+					writeError(w, err)
+				}
+				return
 			}
 
-			w.Header().Set("Proof-Root-Hash", req.ReceiptId)
+			// the archive is incomplete: the connection is aborted so
+			// that the caller does not take it for a complete archive
+			panic(http.ErrAbortHandler)
+		}
+
+		w.Header().Set("Archive-Verified", strconv.FormatBool(manifest.Verified))
+	}
+}
+
+// proofBundleHandler downloads a file of a receipt, verifies it, and
+// returns its proof bundle
+func proofBundleHandler(ctx context.Context, service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filename := r.URL.Query().Get("filename")
+		if filename == "" {
+			writeJSON(w, http.StatusBadRequest, serverResponse{Error: "missing filename"})
+			return
+		}
+
+		bundle, err := service.GetProofBundle(ctx, r.PathValue("id"), filename)
+		switch {
+		case errors.Is(err, common.ErrMismatchingRoots):
+			writeJSON(w, http.StatusBadGateway, serverResponse{Error: err.Error()})
+		case err != nil:
+			writeError(w, err)
+		default:
+			writeJSON(w, http.StatusOK, bundle)
+		}
+	}
+}
+
+// configHandler reports the configuration of the client, without its
+// secrets
+func configHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
 
-			w.Write(file.Contents)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cfg.Redacted()); err != nil {
+			logger.Logger.Error(
+				"cannot send configuration",
+				zap.Error(err),
+			)
 		}
 	}
 }
 
-func Run(ctx context.Context, service *middleware.Service) {
-	http.HandleFunc("/upload", uploadFilesHandler(ctx, service))
-	http.HandleFunc("/download", downloadFilesHandler(ctx, service))
+// Run serves the API until the context is canceled
+func Run(ctx context.Context, service *middleware.Service, cfg *config.Config) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", trackRequest(service, uploadFilesHandler(ctx, service)))
+	mux.HandleFunc("/download", trackRequest(service, downloadFilesHandler(ctx, service)))
+	mux.HandleFunc("GET /receipts", trackRequest(service, listReceiptsHandler(service)))
+	mux.HandleFunc("GET /receipts/{id}", trackRequest(service, getReceiptHandler(service)))
+	mux.HandleFunc("GET /receipts/{id}/proof", trackRequest(service, proofBundleHandler(ctx, service)))
+	mux.HandleFunc("GET /receipts/{id}/archive", trackRequest(service, archiveHandler(ctx, service)))
+	mux.HandleFunc("/config", configHandler(cfg))
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(cfg.API.Address, strconv.Itoa(cfg.API.Port)),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-	logger.Logger.Info("API server started at :3001")
-	http.ListenAndServe("0.0.0.0:3001", nil)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Logger.Error("the API cannot be shut down", zap.Error(err))
+		}
+	}()
+
+	logger.Logger.Sugar().Infof("API server started at %s", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Logger.Error("API ListenAndServe: ", zap.Error(err))
+	}
 }
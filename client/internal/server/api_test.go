@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/glethuillier/mps/client/internal/config"
+	"github.com/glethuillier/mps/client/internal/logger"
+	"github.com/glethuillier/mps/client/internal/middleware"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackRequest(t *testing.T) {
+	logger.Init("")
+
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "roots.db")
+
+	service, err := middleware.GetService(cfg, make(chan interface{}), make(map[uuid.UUID]chan interface{}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { service.Close() })
+
+	// the handler is still running when the shutdown starts
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := trackRequest(service, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	inProgress := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(inProgress, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	}()
+	<-started
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		service.Shutdown(context.Background())
+	}()
+
+	// wait until the shutdown has started
+	for service.BeginRequest() == nil {
+		service.EndRequest()
+	}
+
+	// the requests received while the client is shutting down are
+	// refused
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "30", recorder.Header().Get("Retry-After"))
+
+	var response serverResponse
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, middleware.ErrShuttingDown.Error(), response.Error)
+
+	// the request in progress finishes, and so does the shutdown
+	close(release)
+	<-done
+	<-shutdownDone
+	assert.Equal(t, http.StatusNoContent, inProgress.Code)
+}
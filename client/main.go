@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/glethuillier/mps/client/internal/client"
+	"github.com/glethuillier/mps/client/internal/config"
 	"github.com/glethuillier/mps/client/internal/logger"
 	"github.com/glethuillier/mps/client/internal/middleware"
 	"github.com/glethuillier/mps/client/internal/server"
@@ -14,23 +20,29 @@ import (
 	"go.uber.org/zap"
 )
 
+// after the requests have been drained, the API and the connection to
+// the server have their own time to close
+const closeTimeout = 10 * time.Second
+
 func main() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	logger.Init(logLevel)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger.Init(cfg.LogLevel)
+
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeout)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	done := make(chan struct{})
-
 	signalC := make(chan os.Signal, 1)
 	signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-signalC
-		logger.Logger.Info("closing the client...")
-		cancel()
-		done <- struct{}{}
-	}()
 
 	messagesToSendC := make(chan interface{})
 
@@ -38,16 +50,65 @@ func main() {
 	// dispatched by request ID (map of channels)
 	messagesReceivedMC := make(map[uuid.UUID]chan interface{})
 
-	service, err := middleware.GetService(messagesToSendC, messagesReceivedMC)
+	service, err := middleware.GetService(cfg, messagesToSendC, messagesReceivedMC)
 	if err != nil {
 		logger.Logger.Panic("cannot run the middleware", zap.Error(err))
 	}
 
+	var closed sync.WaitGroup
+
 	// caller -> client
-	go server.Run(ctx, service)
+	closed.Add(1)
+	go func() {
+		defer closed.Done()
+		server.Run(ctx, service, cfg)
+	}()
 
 	// client <-> server
-	go client.Run(ctx, messagesToSendC, messagesReceivedMC)
+	closed.Add(1)
+	go client.Run(ctx, cfg, messagesToSendC, messagesReceivedMC, &closed)
+
+	<-signalC
+	logger.Logger.Info("closing the client...", zap.Duration("timeout", shutdownTimeout))
 
-	<-done
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	// no new request is accepted; the requests in progress can finish
+	service.Shutdown(shutdownCtx)
+
+	// the API and the connection to the server are closed
+	cancel()
+
+	closedC := make(chan struct{})
+	go func() {
+		closed.Wait()
+		close(closedC)
+	}()
+
+	// the database is only closed once nothing can use it anymore
+	if waitPhase(closedC, closeTimeout, "connections still open at the end of the shutdown") {
+		if err := service.Close(); err != nil {
+			logger.Logger.Error("the database cannot be closed", zap.Error(err))
+		}
+	} else {
+		logger.Logger.Warn("the database is left open")
+	}
+
+	logger.Logger.Info("client closed")
+}
+
+// waitPhase waits until a phase of the shutdown is done, or until its
+// timeout expires; it returns whether the phase is done
+func waitPhase(done <-chan struct{}, timeout time.Duration, warning string) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		logger.Logger.Warn(warning, zap.Duration("timeout", timeout))
+		return false
+	}
 }
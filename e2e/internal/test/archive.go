@@ -0,0 +1,51 @@
+package test
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DownloadArchive downloads all the files of a receipt as a tar archive
+// and checks that each file has been verified by the client
+func DownloadArchive(url string, filenames []string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to perform request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-OK response: %s", resp.Status)
+	}
+
+	archived := make(map[string]bool)
+	archive := tar.NewReader(resp.Body)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		archived[header.Name] = true
+	}
+
+	// (the trailer is set once the body has been read)
+	io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Archive-Verified") != "true" {
+		return fmt.Errorf("the archive holds corrupted files")
+	}
+
+	for _, filename := range filenames {
+		if !archived["files/"+filename] {
+			return fmt.Errorf("%s is missing from the archive", filename)
+		}
+	}
+
+	return nil
+}
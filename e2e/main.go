@@ -62,4 +62,15 @@ func main() {
 	}
 
 	fmt.Printf("Files successfully verified (%s)\n", time.Since(t2))
+
+	t3 := time.Now()
+	err = test.DownloadArchive(
+		fmt.Sprintf("%s/receipts/%s/archive", clientBaseUrl, receiptId),
+		filenames,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Archive successfully verified (%s)\n", time.Since(t3))
 }
@@ -2,4 +2,7 @@ module github.com/glethuillier/mps/lib
 
 go 1.22.4
 
-require google.golang.org/protobuf v1.34.1
+require (
+	google.golang.org/protobuf v1.34.1
+	gopkg.in/yaml.v3 v3.0.1
+)
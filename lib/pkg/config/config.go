@@ -0,0 +1,258 @@
+// Package config loads the configurations of the client and of the
+// server, which are structs whose settings are described by tags:
+//
+//   - yaml: key in the configuration file (e.g., "port"; the keys of
+//     the nested structs are prefixed, e.g., "server.port"), which is
+//     also the name of the flag (e.g., -server.port)
+//   - env: environment variable of the setting
+//   - help: description of the setting
+//   - secret: "true" if the setting is a secret, "url" if it is a URL
+//     whose password is a secret
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the secrets in the configurations shown to the
+// users
+const Redacted = "REDACTED"
+
+// field is a setting of a configuration
+type field struct {
+	key    string
+	env    string
+	help   string
+	secret string
+	value  reflect.Value
+}
+
+// Load sets the settings of cfg (a pointer to a struct holding the
+// defaults) from, in increasing order of precedence, the configuration
+// file (flag -config or environment variable CONFIG_FILE), the
+// environment variables and the flags; it returns the arguments that
+// follow the flags. The configuration is not validated.
+func Load(name string, cfg any, args []string) ([]string, error) {
+	fields := structFields("", reflect.ValueOf(cfg).Elem())
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "configuration file (env CONFIG_FILE)")
+
+	// the flags are applied once the file and the environment are read
+	type flagValue struct {
+		field field
+		value string
+	}
+	var flagValues []flagValue
+
+	for _, f := range fields {
+		f := f
+		usage := f.help
+		if f.env != "" {
+			usage = fmt.Sprintf("%s (env %s)", usage, f.env)
+		}
+
+		set := func(s string) error {
+			// checked now to report the invalid flags as such
+			if err := setValue(reflect.New(f.value.Type()).Elem(), s); err != nil {
+				return err
+			}
+			flagValues = append(flagValues, flagValue{f, s})
+			return nil
+		}
+
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(f.key, usage, set)
+		} else {
+			fs.Func(f.key, usage, set)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+
+		if v := os.Getenv(f.env); v != "" {
+			if err := setValue(f.value, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, v := range flagValues {
+		if err := setValue(v.field.value, v.value); err != nil {
+			return nil, fmt.Errorf("invalid -%s: %w", v.field.key, err)
+		}
+	}
+
+	return fs.Args(), nil
+}
+
+// loadFile reads a YAML configuration file; the keys missing from the
+// file keep their current values, and unknown keys are rejected
+func loadFile(cfg any, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(cfg)
+	if errors.Is(err, io.EOF) {
+		// empty file
+		return nil
+	}
+
+	return err
+}
+
+// Redact replaces the secrets of cfg (a pointer to a copy of a
+// configuration, e.g., to be shown to the users); the lists of secrets
+// are replaced rather than modified, so that the copied configuration
+// is not altered
+func Redact(cfg any) {
+	for _, f := range structFields("", reflect.ValueOf(cfg).Elem()) {
+		switch f.secret {
+		case "":
+		case "url":
+			f.value.SetString(redactURL(f.value.String()))
+		default:
+			redactValue(f.value)
+		}
+	}
+}
+
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			v.SetString(Redacted)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+
+		items := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items.Index(i).Set(v.Index(i))
+			redactValue(items.Index(i))
+		}
+		v.Set(items)
+	}
+}
+
+// redactURL replaces the password of a URL (other values, such as file
+// paths, are kept as is)
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+
+	return u.String()
+}
+
+func structFields(prefix string, v reflect.Value) []field {
+	var fields []field
+
+	for i := 0; i < v.NumField(); i++ {
+		t := v.Type().Field(i)
+		key := prefix + t.Tag.Get("yaml")
+
+		if t.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFields(key+".", v.Field(i))...)
+			continue
+		}
+
+		fields = append(fields, field{
+			key:    key,
+			env:    t.Tag.Get("env"),
+			help:   t.Tag.Get("help"),
+			secret: t.Tag.Get("secret"),
+			value:  v.Field(i),
+		})
+	}
+
+	return fields
+}
+
+// setValue sets a setting from its textual value (the lists are
+// comma-separated)
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("value out of range")
+		}
+		v.SetInt(n)
+
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// duration is a setting read from its textual form
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = duration(v)
+	return err
+}
+
+type testConfig struct {
+	Name string `yaml:"name" env:"TEST_NAME" help:"name"`
+
+	Server struct {
+		Port    int      `yaml:"port" env:"TEST_PORT" help:"port"`
+		Timeout duration `yaml:"timeout" help:"timeout"`
+		Debug   bool     `yaml:"debug" help:"debug mode"`
+	} `yaml:"server"`
+
+	Auth struct {
+		Token  string   `yaml:"token" env:"TEST_TOKEN" secret:"true" help:"token"`
+		Tokens []string `yaml:"tokens" env:"TEST_TOKENS" secret:"true" help:"tokens"`
+		URL    string   `yaml:"url" secret:"url" help:"URL"`
+	} `yaml:"auth"`
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.yaml")
+	err := os.WriteFile(path, []byte("name: file\nserver:\n  port: 4000\n  timeout: 1m\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TEST_PORT", "5000")
+	t.Setenv("TEST_TOKENS", "a, b")
+
+	var c testConfig
+	c.Name = "default"
+
+	args, err := Load("test", &c, []string{"-server.port", "6000", "-server.debug", "show"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// defaults < file < environment < flags
+	if !reflect.DeepEqual(args, []string{"show"}) {
+		t.Errorf("unexpected arguments %v", args)
+	}
+	if c.Name != "file" || c.Server.Port != 6000 || !c.Server.Debug {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+	if c.Server.Timeout != duration(time.Minute) {
+		t.Errorf("unexpected timeout %v", time.Duration(c.Server.Timeout))
+	}
+	if !reflect.DeepEqual(c.Auth.Tokens, []string{"a", "b"}) {
+		t.Errorf("unexpected tokens %v", c.Auth.Tokens)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	unknownKey := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknownKey, []byte("server:\n  prot: 4000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		expectedError string
+	}{
+		{
+			name:          "Unknown key in the file",
+			args:          []string{"-config", unknownKey},
+			expectedError: "field prot not found",
+		},
+		{
+			name:          "Invalid environment variable",
+			env:           map[string]string{"TEST_PORT": "http"},
+			expectedError: "invalid TEST_PORT",
+		},
+		{
+			name:          "Invalid flag",
+			args:          []string{"-server.timeout", "soon"},
+			expectedError: "server.timeout",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			var c testConfig
+			_, err := Load("test", &c, tc.args)
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("expected error %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var c testConfig
+	c.Name = "name"
+	c.Auth.Token = "secret"
+	c.Auth.Tokens = []string{"a", "b"}
+	c.Auth.URL = "postgres://mps:secret@db/mps"
+
+	// the secrets are redacted, without altering the configuration
+	r := c
+	Redact(&r)
+
+	if r.Name != "name" || r.Auth.Token != Redacted || r.Auth.URL != "postgres://mps:REDACTED@db/mps" {
+		t.Errorf("unexpected redacted configuration %+v", r.Auth)
+	}
+	if !reflect.DeepEqual(r.Auth.Tokens, []string{Redacted, Redacted}) {
+		t.Errorf("unexpected redacted tokens %v", r.Auth.Tokens)
+	}
+	if c.Auth.Token != "secret" || !reflect.DeepEqual(c.Auth.Tokens, []string{"a", "b"}) {
+		t.Errorf("the configuration is altered: %+v", c.Auth)
+	}
+
+	// the unset secrets and the URLs without password are kept
+	var empty testConfig
+	empty.Auth.URL = "proofs.db"
+	Redact(&empty)
+	if empty.Auth.Token != "" || empty.Auth.Tokens != nil || empty.Auth.URL != "proofs.db" {
+		t.Errorf("unexpected redacted configuration %+v", empty.Auth)
+	}
+}
@@ -0,0 +1,218 @@
+package proofs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BundleVersion is the version of the format of the proof bundles
+const BundleVersion = 1
+
+// TreeVersion identifies how the Merkle trees are built: the leaves are
+// the hashes of the files, sorted, then padded with the hash of an
+// empty value up to a power of two; each parent is the hash of the
+// concatenation of its children
+const TreeVersion = 1
+
+// ErrInvalidProof is returned when a file does not match a proof bundle
+var ErrInvalidProof = errors.New("invalid proof")
+
+// Bundle is a self-contained proof that a file belongs to the tree of a
+// receipt: it can be stored, handed to a third party and verified
+// offline with VerifyBundle
+type Bundle struct {
+	Version       int    `json:"version"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	TreeVersion   int    `json:"tree_version"`
+	ReceiptId     string `json:"receipt_id"`
+	Filename      string `json:"filename"`
+
+	// hash of the file (hex-encoded)
+	LeafHash string `json:"leaf_hash"`
+
+	// siblings from the leaf to the root
+	Siblings []Sibling `json:"siblings"`
+
+	RootHash string `json:"root_hash"`
+
+	// signature of the bundle by the server (optional)
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// Sibling is the sibling of a node on the path from a leaf to the root
+type Sibling struct {
+	// "left" or "right" of the node
+	Position string `json:"position"`
+	Hash     string `json:"hash"`
+}
+
+// Signature is the Ed25519 signature of the payload of a bundle (see
+// SignedPayload), base64-encoded
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyId     string `json:"key_id,omitempty"`
+	Value     string `json:"value"`
+}
+
+// hashFunctions are the hash algorithms of the bundles
+var hashFunctions = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// SiblingsFromProof returns the siblings of a proof
+func SiblingsFromProof(proof []ProofPart) []Sibling {
+	siblings := make([]Sibling, 0, len(proof))
+	for _, p := range proof {
+		siblings = append(siblings, Sibling{
+			Position: p.SiblingType.String(),
+			Hash:     p.SiblingHash,
+		})
+	}
+
+	return siblings
+}
+
+// VerifyBundle checks that a file matches a bundle: its hash must be the
+// leaf hash of the bundle, and the siblings must lead from the leaf to
+// the root hash. The signature, if any, is not verified (see
+// VerifySignature).
+func VerifyBundle(b *Bundle, file io.Reader) error {
+	if b.Version != BundleVersion {
+		return fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	if b.TreeVersion != TreeVersion {
+		return fmt.Errorf("unsupported tree version %d", b.TreeVersion)
+	}
+
+	newHash, ok := hashFunctions[b.HashAlgorithm]
+	if !ok {
+		return fmt.Errorf("unsupported hash algorithm %q", b.HashAlgorithm)
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	current := h.Sum(nil)
+
+	leafHash, err := hex.DecodeString(b.LeafHash)
+	if err != nil {
+		return fmt.Errorf("invalid leaf hash: %w", err)
+	}
+
+	if !bytes.Equal(current, leafHash) {
+		return fmt.Errorf("%w: the hash of the file is not the leaf hash", ErrInvalidProof)
+	}
+
+	for i, s := range b.Siblings {
+		sibling, err := hex.DecodeString(s.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash of sibling %d: %w", i, err)
+		}
+
+		h.Reset()
+		switch GetSiblingType(s.Position) {
+		case LeftSibling:
+			h.Write(sibling)
+			h.Write(current)
+		case RightSibling:
+			h.Write(current)
+			h.Write(sibling)
+		default:
+			return fmt.Errorf("invalid position of sibling %d: %q", i, s.Position)
+		}
+		current = h.Sum(nil)
+	}
+
+	rootHash, err := hex.DecodeString(b.RootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+
+	if !bytes.Equal(current, rootHash) {
+		return fmt.Errorf("%w: the siblings do not lead to the root hash", ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// SignatureAlgorithm is the algorithm of the signatures of the bundles
+const SignatureAlgorithm = "ed25519"
+
+// SignedPayload returns the data covered by the signature of a bundle:
+// its fields (except the signature), one `name=value` line each, in the
+// order of the format; the siblings are listed as `position:hash`,
+// separated by commas
+func SignedPayload(b *Bundle) []byte {
+	siblings := make([]string, 0, len(b.Siblings))
+	for _, s := range b.Siblings {
+		siblings = append(siblings, s.Position+":"+s.Hash)
+	}
+
+	var payload bytes.Buffer
+	for _, field := range [][2]string{
+		{"version", strconv.Itoa(b.Version)},
+		{"hash_algorithm", b.HashAlgorithm},
+		{"tree_version", strconv.Itoa(b.TreeVersion)},
+		{"receipt_id", b.ReceiptId},
+		{"filename", b.Filename},
+		{"leaf_hash", b.LeafHash},
+		{"siblings", strings.Join(siblings, ",")},
+		{"root_hash", b.RootHash},
+	} {
+		payload.WriteString(field[0] + "=" + field[1] + "\n")
+	}
+
+	return payload.Bytes()
+}
+
+// KeyId returns the ID of the public key of a server: the first 8 bytes
+// of its SHA-256 digest (hex-encoded)
+func KeyId(key ed25519.PublicKey) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:8])
+}
+
+// Sign signs a bundle with the Ed25519 key of a server
+func (b *Bundle) Sign(key ed25519.PrivateKey) {
+	b.Signature = &Signature{
+		Algorithm: SignatureAlgorithm,
+		KeyId:     KeyId(key.Public().(ed25519.PublicKey)),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, SignedPayload(b))),
+	}
+}
+
+// VerifySignature checks the signature of a bundle against the Ed25519
+// public key of a server
+func VerifySignature(b *Bundle, key ed25519.PublicKey) error {
+	if b.Signature == nil {
+		return fmt.Errorf("the bundle is not signed")
+	}
+
+	if b.Signature.Algorithm != SignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm %q", b.Signature.Algorithm)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(b.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if !ed25519.Verify(key, SignedPayload(b), signature) {
+		return fmt.Errorf("%w: the signature does not match", ErrInvalidProof)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package proofs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newTestBundle returns the bundle of a file of a tree of two files
+func newTestBundle(file, other []byte) *Bundle {
+	leaf := sha512.Sum512(file)
+	sibling := sha512.Sum512(other)
+
+	position := "right"
+	left, right := leaf[:], sibling[:]
+	if bytes.Compare(left, right) > 0 {
+		position = "left"
+		left, right = right, left
+	}
+	root := sha512.Sum512(append(append([]byte{}, left...), right...))
+
+	return &Bundle{
+		Version:       BundleVersion,
+		HashAlgorithm: "sha512",
+		TreeVersion:   TreeVersion,
+		ReceiptId:     "0aa9a4bc-7554-4d6b-bebb-77b23dfc321b",
+		Filename:      "a.txt",
+		LeafHash:      hex.EncodeToString(leaf[:]),
+		Siblings:      []Sibling{{Position: position, Hash: hex.EncodeToString(sibling[:])}},
+		RootHash:      hex.EncodeToString(root[:]),
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		alter         func(b *Bundle)
+		expectedError string
+	}{
+		{
+			name: "Valid bundle",
+			file: "a",
+		},
+		{
+			name:          "Altered file",
+			file:          "A",
+			expectedError: "the hash of the file is not the leaf hash",
+		},
+		{
+			name: "Altered sibling position",
+			file: "a",
+			alter: func(b *Bundle) {
+				if b.Siblings[0].Position == "left" {
+					b.Siblings[0].Position = "right"
+				} else {
+					b.Siblings[0].Position = "left"
+				}
+			},
+			expectedError: "the siblings do not lead to the root hash",
+		},
+		{
+			name:          "Unsupported hash algorithm",
+			file:          "a",
+			alter:         func(b *Bundle) { b.HashAlgorithm = "md5" },
+			expectedError: `unsupported hash algorithm "md5"`,
+		},
+		{
+			name:          "Unsupported tree version",
+			file:          "a",
+			alter:         func(b *Bundle) { b.TreeVersion = 2 },
+			expectedError: "unsupported tree version 2",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestBundle([]byte("a"), []byte("bb"))
+			if tc.alter != nil {
+				tc.alter(b)
+			}
+
+			err := VerifyBundle(b, strings.NewReader(tc.file))
+			if tc.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBundle([]byte("a"), []byte("bb"))
+	if err := VerifySignature(b, public); err == nil {
+		t.Fatal("an unsigned bundle is verified")
+	}
+
+	b.Sign(private)
+	if b.Signature.KeyId != KeyId(public) {
+		t.Fatalf("unexpected key ID %q", b.Signature.KeyId)
+	}
+
+	if err := VerifySignature(b, public); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b.Filename = "b.txt"
+	if err := VerifySignature(b, public); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("a modified bundle is verified: %v", err)
+	}
+}
+
+func TestSignedPayload(t *testing.T) {
+	b := &Bundle{
+		Version:       BundleVersion,
+		HashAlgorithm: "sha512",
+		TreeVersion:   TreeVersion,
+		ReceiptId:     "0aa9a4bc-7554-4d6b-bebb-77b23dfc321b",
+		Filename:      "a.txt",
+		LeafHash:      "aa",
+		Siblings:      []Sibling{{Position: "right", Hash: "bb"}, {Position: "left", Hash: "cc"}},
+		RootHash:      "dd",
+		Signature:     &Signature{Algorithm: SignatureAlgorithm, Value: "ignored"},
+	}
+
+	expected := "version=1\n" +
+		"hash_algorithm=sha512\n" +
+		"tree_version=1\n" +
+		"receipt_id=0aa9a4bc-7554-4d6b-bebb-77b23dfc321b\n" +
+		"filename=a.txt\n" +
+		"leaf_hash=aa\n" +
+		"siblings=right:bb,left:cc\n" +
+		"root_hash=dd\n"
+
+	if payload := string(SignedPayload(b)); payload != expected {
+		t.Fatalf("unexpected payload:\n%s", payload)
+	}
+}
@@ -0,0 +1,188 @@
+// Package schema applies the schema migrations of the databases of the
+// client and of the server
+package schema
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownVersion is returned when the database has been migrated
+// by a more recent version of the application
+var ErrUnknownVersion = errors.New("the database schema is more recent than the application")
+
+// Dialect describes the differences between the database engines that
+// matter to the migrations
+type Dialect struct {
+	// statement preventing concurrent migrations (within a transaction);
+	// empty if the database is not shared
+	Lock string
+
+	// type of the column of the dates the migrations are applied at
+	// (Unix timestamps)
+	TimestampType string
+
+	// Rebind replaces the `?` placeholders of a query with those of the
+	// engine; nil if the engine uses `?`
+	Rebind func(query string) string
+}
+
+func (d Dialect) rebind(query string) string {
+	if d.Rebind == nil {
+		return query
+	}
+
+	return d.Rebind(query)
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// Load returns the migrations of a directory
+// (`{dir}/{version}_{name}.sql`), sorted by version
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".sql")
+		if !ok {
+			continue
+		}
+
+		v, name, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(v)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration filename: %s", entry.Name())
+		}
+
+		query, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			Query:   string(query),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("missing or duplicate migration before version %d", m.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Version returns the version of the schema of a database (0 if no
+// migration has been applied)
+func Version(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM SCHEMA_MIGRATIONS").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error executing query: %v", err)
+	}
+
+	return version, nil
+}
+
+// Migrate applies the pending migrations, each one in a transaction, and
+// refuses to run against a schema more recent than the migrations; it
+// returns the migrations applied (even if a later one failed)
+func Migrate(db *sql.DB, d Dialect, migrations []Migration) ([]Migration, error) {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS SCHEMA_MIGRATIONS (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT    NOT NULL,
+			applied_at %s NOT NULL
+		)`, d.TimestampType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the migrations table: %w", err)
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		ok, err := apply(db, d, m, len(migrations))
+		if err != nil {
+			return applied, err
+		}
+
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+
+	return applied, nil
+}
+
+// apply applies a migration unless it has already been applied (e.g.,
+// by another instance sharing the database); it reports whether the
+// migration has been applied
+func apply(db *sql.DB, d Dialect, m Migration, latest int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if d.Lock != "" {
+		if _, err = tx.Exec(d.Lock); err != nil {
+			return false, fmt.Errorf("failed to lock the migrations: %w", err)
+		}
+	}
+
+	var version int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM SCHEMA_MIGRATIONS").Scan(&version)
+	if err != nil {
+		return false, fmt.Errorf("error executing query: %v", err)
+	}
+
+	if version > latest {
+		return false, fmt.Errorf("%w (schema version: %d; latest known version: %d)",
+			ErrUnknownVersion, version, latest)
+	}
+
+	if version >= m.Version {
+		return false, nil
+	}
+
+	if _, err = tx.Exec(m.Query); err != nil {
+		return false, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.Exec(
+		d.rebind("INSERT INTO SCHEMA_MIGRATIONS (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version,
+		m.Name,
+		time.Now().UTC().Unix(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,71 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_receipts.sql": {Data: []byte("CREATE TABLE RECEIPTS (id INTEGER)")},
+		"migrations/0001_initial.sql":  {Data: []byte("CREATE TABLE FILES (id INTEGER)")},
+		"migrations/README.md":         {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the migrations are sorted by version
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	for i, expected := range []Migration{
+		{Version: 1, Name: "initial", Query: "CREATE TABLE FILES (id INTEGER)"},
+		{Version: 2, Name: "receipts", Query: "CREATE TABLE RECEIPTS (id INTEGER)"},
+	} {
+		if migrations[i] != expected {
+			t.Errorf("unexpected migration %+v (expected: %+v)", migrations[i], expected)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         []string
+		expectedError string
+	}{
+		{
+			name:          "Invalid version",
+			files:         []string{"0001_initial.sql", "x_receipts.sql"},
+			expectedError: "invalid migration filename: x_receipts.sql",
+		},
+		{
+			name:          "Missing version",
+			files:         []string{"0001_initial.sql", "0003_receipts.sql"},
+			expectedError: "missing or duplicate migration before version 3",
+		},
+		{
+			name:          "Duplicate version",
+			files:         []string{"0001_initial.sql", "0001_receipts.sql"},
+			expectedError: "missing or duplicate migration before version 1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range tc.files {
+				fsys["migrations/"+f] = &fstest.MapFile{Data: []byte("SELECT 1")}
+			}
+
+			_, err := Load(fsys, "migrations")
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("expected error %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
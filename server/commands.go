@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/middleware"
+)
+
+const usage = `usage:
+  server [flags]                                    run the server (see server -h for the flags)
+  server config                                     show the effective configuration (secrets redacted)
+  server export <receipt_id> <archive>              export a receipt and its files as a bundle (e.g., archive: receipt.tar.gz)
+  server import <archive>                           import a bundle exported by a server
+  server hold set <receipt_id> <duration> <reason>  place a receipt under legal hold (e.g., duration: 365d)
+  server hold release <receipt_id> <reason>         release the legal hold of a receipt
+  server hold show <receipt_id>                     show the legal hold and its audit trail
+  server assign <client_id> [<receipt_id>...]       assign the receipts of anonymous clients (all by default) to a client
+  server scrub                                      re-verify all the stored files and list the corruptions
+  server audit verify                               verify that the audit log has not been tampered with`
+
+// runCommand runs an administrative command against the server
+// database and storage
+func runCommand(cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "config":
+		return runConfigCommand(cfg)
+	case "export":
+		return runExportCommand(cfg, args[1:])
+	case "import":
+		return runImportCommand(cfg, args[1:])
+	case "hold":
+		return runHoldCommand(cfg, args[1:])
+	case "assign":
+		return runAssignCommand(cfg, args[1:])
+	case "scrub":
+		return runScrubCommand(cfg)
+	case "audit":
+		return runAuditCommand(cfg, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// runConfigCommand shows the configuration resulting from the file, the
+// environment and the flags
+func runConfigCommand(cfg *config.Config) error {
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// runExportCommand writes the bundle of a receipt to an archive (the
+// archive is removed if the export fails)
+func runExportCommand(cfg *config.Config, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing arguments\n%s", usage)
+	}
+
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+
+	receiptId, path := args[0], args[1]
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := service.ExportReceipt(receiptId, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	signed := "unsigned"
+	if cfg.Bundles.SigningKey != "" {
+		signed = "signed"
+	}
+
+	fmt.Printf(
+		"receipt %s exported to %s (%d files, %s)\n",
+		receiptId, path, len(manifest.Files), signed,
+	)
+
+	return nil
+}
+
+// runImportCommand imports the bundle of a receipt from an archive
+func runImportCommand(cfg *config.Config, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing arguments\n%s", usage)
+	}
+
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	receipt, err := service.ImportReceipt(f, actor())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"receipt %s imported (%d files, root hash %s)\n",
+		receipt.ReceiptId, receipt.FilesCount, receipt.RootHash,
+	)
+
+	return nil
+}
+
+func runHoldCommand(cfg *config.Config, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing arguments\n%s", usage)
+	}
+
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+
+	action, receiptId := args[0], args[1]
+
+	switch action {
+	case "set":
+		if len(args) < 4 {
+			return fmt.Errorf("missing arguments\n%s", usage)
+		}
+
+		duration, err := config.ParseDuration(args[2])
+		if err != nil {
+			return err
+		}
+
+		return service.SetLegalHold(receiptId, duration, strings.Join(args[3:], " "), actor())
+
+	case "release":
+		if len(args) < 3 {
+			return fmt.Errorf("missing arguments\n%s", usage)
+		}
+
+		return service.ReleaseLegalHold(receiptId, strings.Join(args[2:], " "), actor())
+
+	case "show":
+		receipt, err := service.GetReceipt(receiptId)
+		if err != nil {
+			return err
+		}
+
+		events, err := service.GetHoldEvents(receiptId)
+		if err != nil {
+			return err
+		}
+
+		if receipt.LegalHoldUntil != nil {
+			fmt.Printf("receipt %s held until %s\n\n", receiptId, receipt.LegalHoldUntil.Format(time.RFC3339))
+		} else {
+			fmt.Printf("receipt %s not under legal hold\n\n", receiptId)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tACTION\tHELD UNTIL\tACTOR\tREASON")
+		for _, e := range events {
+			heldUntil := "-"
+			if e.HeldUntil != nil {
+				heldUntil = e.HeldUntil.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(
+				w, "%s\t%s\t%s\t%s\t%s\n",
+				e.CreatedAt.Format(time.RFC3339), e.Action, heldUntil, e.Actor, e.Reason,
+			)
+		}
+
+		return w.Flush()
+
+	default:
+		return fmt.Errorf("unknown hold action %q\n%s", action, usage)
+	}
+}
+
+// runAssignCommand assigns receipts of anonymous clients (e.g., uploaded
+// before the tokens were required) to a client, and moves their files to
+// the directory of the client
+func runAssignCommand(cfg *config.Config, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing arguments\n%s", usage)
+	}
+
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	assigned, err := service.AssignLegacyReceipts(args[0], args[1:], actor())
+	for _, r := range assigned {
+		fmt.Printf("receipt %s assigned to %s (%d files)\n", r.ReceiptId, r.ClientId, r.FilesCount)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(assigned) == 0 {
+		fmt.Println("no receipt of an anonymous client")
+	}
+
+	return nil
+}
+
+func runScrubCommand(cfg *config.Config) error {
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := service.Scrub(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"%d receipts and %d files scanned, %d replicas repaired, %d corruptions\n\n",
+		report.ReceiptsScanned,
+		report.FilesScanned,
+		report.ReplicasRepaired,
+		len(report.Corruptions),
+	)
+
+	if len(report.Corruptions) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECEIPT ID\tFILENAME\tREASON")
+	for _, c := range report.Corruptions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.ReceiptId, c.Filename, c.Reason)
+	}
+
+	return w.Flush()
+}
+
+// runAuditCommand verifies the hash chain of the audit log; an error is
+// returned if the chain is broken
+func runAuditCommand(cfg *config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "verify" {
+		return fmt.Errorf("missing arguments\n%s", usage)
+	}
+
+	service, err := middleware.GetService(cfg)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	report, err := service.VerifyAuditLog()
+	if err != nil {
+		return err
+	}
+
+	if !report.Valid {
+		return fmt.Errorf(
+			"the audit log is broken at event %d: %s (%d events verified before)",
+			report.BrokenAt,
+			report.Reason,
+			report.Events,
+		)
+	}
+
+	if report.Events == 0 {
+		fmt.Println("the audit log is empty")
+		return nil
+	}
+
+	fmt.Printf("the audit log is intact: %d events, last hash %s\n", report.Events, report.LastHash)
+
+	return nil
+}
+
+// actor identifies who runs a command (recorded in the audit trail)
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+
+	return "cli"
+}
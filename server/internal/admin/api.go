@@ -0,0 +1,496 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/glethuillier/fvs/server/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// actor identifies the administrator in logs and audit trails
+const actor = "admin-api"
+
+// maxPageSize bounds the number of receipts or events returned at once
+const maxPageSize = 1000
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type receiptsResponse struct {
+	Receipts []common.Receipt `json:"receipts"`
+	Total    int              `json:"total"`
+}
+
+type receiptResponse struct {
+	common.Receipt
+	Files []common.FileInfo `json:"files"`
+}
+
+type proofPart struct {
+	SiblingType string `json:"sibling_type"`
+	SiblingHash string `json:"sibling_hash"`
+}
+
+type proofResponse struct {
+	ReceiptId string      `json:"receipt_id"`
+	Filename  string      `json:"filename"`
+	RootHash  string      `json:"root_hash"`
+	Proof     []proofPart `json:"proof"`
+}
+
+type signingKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	KeyId     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+type holdRequest struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type scrubResponse struct {
+	InProgress  bool                    `json:"in_progress"`
+	LastReport  *middleware.ScrubReport `json:"last_report"`
+	Corruptions []common.Corruption     `json:"corruptions"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Logger.Error(
+			"cannot send response",
+			zap.Error(err),
+		)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// errorStatus returns the status of an error returned by the service: 404
+// if the receipt or the file does not exist, 409 if the receipt is under
+// legal hold, and the given status otherwise
+func errorStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, database.ErrReceiptNotFound), errors.Is(err, database.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrReceiptOnHold):
+		return http.StatusConflict
+	default:
+		return status
+	}
+}
+
+// parseLimit parses the number of items requested, which must be between
+// 1 and maxPageSize
+func parseLimit(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPageSize {
+		return 0, fmt.Errorf("invalid limit (between 1 and %d)", maxPageSize)
+	}
+
+	return n, nil
+}
+
+// requireToken rejects the requests that do not carry the admin token
+// (`Authorization: Bearer <token>`)
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logger.Logger.Warn(
+				"unauthorized admin request",
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("path", r.URL.Path),
+			)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectWhenDraining refuses the requests received while the server is
+// shutting down
+func rejectWhenDraining(service *middleware.Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if service.Draining() {
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusServiceUnavailable, middleware.ErrShuttingDown)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listReceiptsHandler lists and searches receipts
+func listReceiptsHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter := database.ReceiptFilter{
+			Search:   query.Get("search"),
+			ClientId: query.Get("client_id"),
+			Limit:    100,
+		}
+
+		if v := query.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset"))
+				return
+			}
+			filter.Offset = n
+		}
+
+		if v := query.Get("limit"); v != "" {
+			n, err := parseLimit(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			filter.Limit = n
+		}
+
+		receipts, total, err := service.ListReceipts(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if receipts == nil {
+			receipts = []common.Receipt{}
+		}
+
+		writeJSON(w, http.StatusOK, receiptsResponse{Receipts: receipts, Total: total})
+	}
+}
+
+// getReceiptHandler shows a receipt and its files
+func getReceiptHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		receiptId := r.PathValue("id")
+
+		receipt, err := service.GetReceipt(receiptId)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		files, err := service.GetFiles(receiptId)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if files == nil {
+			files = []common.FileInfo{}
+		}
+
+		writeJSON(w, http.StatusOK, receiptResponse{Receipt: *receipt, Files: files})
+	}
+}
+
+// getProofHandler returns the proof of a file of a receipt
+func getProofHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		receiptId := r.PathValue("id")
+		filename := r.URL.Query().Get("filename")
+		if filename == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("missing filename"))
+			return
+		}
+
+		rootHash, proof, err := service.GetProof(receiptId, filename)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		response := proofResponse{
+			ReceiptId: receiptId,
+			Filename:  filename,
+			RootHash:  rootHash,
+			Proof:     []proofPart{},
+		}
+		for _, p := range proof {
+			response.Proof = append(response.Proof, proofPart{
+				SiblingType: p.SiblingType.String(),
+				SiblingHash: p.SiblingHash,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// deleteReceiptHandler deletes a receipt and its files
+func deleteReceiptHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := service.DeleteReceipt(r.PathValue("id"), actor); err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setHoldHandler places a receipt under legal hold
+func setHoldHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req holdRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		duration, err := config.ParseDuration(req.Duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := service.SetLegalHold(r.PathValue("id"), duration, req.Reason, actor); err != nil {
+			writeError(w, errorStatus(err, http.StatusConflict), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// releaseHoldHandler releases the legal hold of a receipt
+func releaseHoldHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req holdRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := service.ReleaseLegalHold(r.PathValue("id"), req.Reason, actor); err != nil {
+			writeError(w, errorStatus(err, http.StatusConflict), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getHoldEventsHandler returns the audit trail of the legal holds
+// of a receipt
+func getHoldEventsHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := service.GetHoldEvents(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if events == nil {
+			events = []common.HoldEvent{}
+		}
+
+		writeJSON(w, http.StatusOK, events)
+	}
+}
+
+// connectionsHandler shows the active connections and upload sessions
+func connectionsHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, service.Connections())
+	}
+}
+
+// getScrubHandler shows the last scrub and the unresolved corruptions
+func getScrubHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		corruptions, err := service.ListCorruptions()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if corruptions == nil {
+			corruptions = []common.Corruption{}
+		}
+
+		writeJSON(w, http.StatusOK, scrubResponse{
+			InProgress:  service.IsScrubbing(),
+			LastReport:  service.LastScrubReport(),
+			Corruptions: corruptions,
+		})
+	}
+}
+
+// startScrubHandler triggers a scrub
+func startScrubHandler(ctx context.Context, service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := service.StartScrub(ctx)
+		if errors.Is(err, middleware.ErrScrubInProgress) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		logger.Logger.Info("scrub triggered", zap.String("actor", actor))
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// retentionHandler shows the last run of the retention job
+func retentionHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, service.LastRetentionReport())
+	}
+}
+
+// listAuditHandler lists the events of the audit log, in the order in
+// which they were recorded
+func listAuditHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter := database.AuditFilter{
+			ClientId:  query.Get("client_id"),
+			ReceiptId: query.Get("receipt_id"),
+			Action:    common.AuditAction(query.Get("action")),
+			Limit:     100,
+		}
+
+		if v := query.Get("after"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid after"))
+				return
+			}
+			filter.After = n
+		}
+
+		if v := query.Get("limit"); v != "" {
+			n, err := parseLimit(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			filter.Limit = n
+		}
+
+		events, err := service.ListAuditEvents(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, events)
+	}
+}
+
+// verifyAuditHandler verifies the hash chain of the audit log
+func verifyAuditHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := service.VerifyAuditLog()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// signingKeyHandler returns the public key verifying the signatures of
+// the proofs sent along with the downloaded files
+func signingKeyHandler(service *middleware.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := service.ProofPublicKey()
+		if key == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("the proofs are not signed (no signing key)"))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, signingKeyResponse{
+			Algorithm: libproofs.SignatureAlgorithm,
+			KeyId:     libproofs.KeyId(key),
+			PublicKey: base64.StdEncoding.EncodeToString(key),
+		})
+	}
+}
+
+// newHandler returns the handler of the admin API: the requests must
+// carry the admin token, and are refused while the server is shutting
+// down
+func newHandler(ctx context.Context, service *middleware.Service, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /receipts", listReceiptsHandler(service))
+	mux.HandleFunc("GET /receipts/{id}", getReceiptHandler(service))
+	mux.HandleFunc("DELETE /receipts/{id}", deleteReceiptHandler(service))
+	mux.HandleFunc("GET /receipts/{id}/proof", getProofHandler(service))
+	mux.HandleFunc("GET /receipts/{id}/hold", getHoldEventsHandler(service))
+	mux.HandleFunc("POST /receipts/{id}/hold", setHoldHandler(service))
+	mux.HandleFunc("DELETE /receipts/{id}/hold", releaseHoldHandler(service))
+	mux.HandleFunc("GET /connections", connectionsHandler(service))
+	mux.HandleFunc("GET /scrub", getScrubHandler(service))
+	mux.HandleFunc("POST /scrub", startScrubHandler(ctx, service))
+	mux.HandleFunc("GET /retention", retentionHandler(service))
+	mux.HandleFunc("GET /audit", listAuditHandler(service))
+	mux.HandleFunc("GET /audit/verify", verifyAuditHandler(service))
+	mux.HandleFunc("GET /signing-key", signingKeyHandler(service))
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	return requireToken(token, rejectWhenDraining(service, mux))
+}
+
+// Run starts the admin API if an admin token is set
+func Run(ctx context.Context, service *middleware.Service, cfg config.AdminConfig) {
+	if len(cfg.Token) == 0 {
+		logger.Logger.Info("admin API disabled (no admin token)")
+		return
+	}
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port)),
+		Handler: newHandler(ctx, service, cfg.Token),
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Logger.Error("the admin API cannot be shut down", zap.Error(err))
+		}
+	}()
+
+	logger.Logger.Sugar().Infof("Admin API started on %s", server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Logger.Error("admin ListenAndServe: ", zap.Error(err))
+	}
+}
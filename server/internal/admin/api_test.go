@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/middleware"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "s3cr3t"
+
+// newTestService returns a service backed by a new SQLite database and
+// a new storage directory, and another connection to its database (to
+// prepare the receipts)
+func newTestService(t *testing.T) (*middleware.Service, *database.Database, string) {
+	dir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.Database.URL = filepath.Join(dir, "proofs.db")
+	cfg.Storage.Dirs = []string{filepath.Join(dir, "downloads")}
+
+	service, err := middleware.GetService(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { service.Close() })
+
+	db, err := database.CreateDatabase(cfg.Database.URL)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return service, db, cfg.Storage.Dirs[0]
+}
+
+// saveReceipt saves a receipt of two files, and its files unless
+// withoutFiles is set; the tree is returned
+func saveReceipt(t *testing.T, db *database.Database, receiptId string, withoutFiles bool) *common.Tree {
+	files := []*common.File{
+		{Filename: "a.txt", Contents: []byte(receiptId)},
+		{Filename: "b.txt", Contents: []byte("b")},
+	}
+
+	tree, err := proofs.BuildMerkleTree(files)
+	assert.NoError(t, err)
+
+	if !withoutFiles {
+		for _, f := range files {
+			assert.NoError(t, helpers.SaveFile(helpers.StorageId("alice", tree.RootHash), f.Filename, f.Contents))
+		}
+	}
+
+	assert.NoError(t, db.SaveTree(&common.Receipt{
+		ReceiptId: receiptId,
+		ClientId:  "alice",
+		CreatedAt: time.Now().UTC(),
+	}, tree))
+
+	return tree
+}
+
+// request sends a request to the admin API, with the admin token
+func request(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+
+	return recorder
+}
+
+func TestAdminAPI(t *testing.T) {
+	logger.Init("")
+
+	const (
+		held    = "11111111-1111-4111-8111-111111111111"
+		notHeld = "22222222-2222-4222-8222-222222222222"
+		unknown = "33333333-3333-4333-8333-333333333333"
+	)
+
+	service, db, _ := newTestService(t)
+	saveReceipt(t, db, held, false)
+	saveReceipt(t, db, notHeld, false)
+
+	heldUntil := time.Now().UTC().Add(365 * 24 * time.Hour)
+	assert.NoError(t, db.SetLegalHold(common.HoldEvent{
+		ReceiptId: held,
+		Action:    common.HoldSet,
+		HeldUntil: &heldUntil,
+		Reason:    "litigation",
+		Actor:     "test",
+		CreatedAt: time.Now().UTC(),
+	}))
+
+	handler := newHandler(context.Background(), service, testToken)
+
+	t.Run("Token", func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer other", "Basic " + testToken, testToken} {
+			r := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+			if authorization != "" {
+				r.Header.Set("Authorization", authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, r)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, authorization)
+		}
+
+		assert.Equal(t, http.StatusOK, request(handler, http.MethodGet, "/receipts", "").Code)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		for _, r := range []struct {
+			method, target, body string
+		}{
+			{http.MethodGet, "/receipts/" + unknown, ""},
+			{http.MethodGet, "/receipts/" + unknown + "/proof?filename=a.txt", ""},
+			{http.MethodGet, "/receipts/" + held + "/proof?filename=c.txt", ""},
+			{http.MethodDelete, "/receipts/" + unknown, ""},
+			{http.MethodPost, "/receipts/" + unknown + "/hold", `{"duration": "1d", "reason": "test"}`},
+			{http.MethodDelete, "/receipts/" + unknown + "/hold", `{"reason": "test"}`},
+		} {
+			recorder := request(handler, r.method, r.target, r.body)
+			assert.Equal(t, http.StatusNotFound, recorder.Code, r.method+" "+r.target)
+		}
+
+		assert.Equal(t, http.StatusOK, request(handler, http.MethodGet, "/receipts/"+held+"/proof?filename=a.txt", "").Code)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=1001", "limit=-1", "limit=a"} {
+			assert.Equal(t, http.StatusBadRequest, request(handler, http.MethodGet, "/receipts?"+query, "").Code, query)
+			assert.Equal(t, http.StatusBadRequest, request(handler, http.MethodGet, "/audit?"+query, "").Code, query)
+		}
+		assert.Equal(t, http.StatusBadRequest, request(handler, http.MethodGet, "/receipts?offset=-1", "").Code)
+
+		recorder := request(handler, http.MethodGet, "/receipts?limit=1&offset=1", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var response receiptsResponse
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		assert.Equal(t, 2, response.Total)
+		assert.Len(t, response.Receipts, 1)
+		assert.Equal(t, notHeld, response.Receipts[0].ReceiptId)
+
+		recorder = request(handler, http.MethodGet, "/receipts?limit=1000", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		// a held receipt cannot be deleted, nor can its hold be shortened
+		assert.Equal(t, http.StatusConflict, request(handler, http.MethodDelete, "/receipts/"+held, "").Code)
+		assert.Equal(t, http.StatusConflict, request(handler, http.MethodPost, "/receipts/"+held+"/hold", `{"duration": "1d", "reason": "test"}`).Code)
+		assert.Equal(t, http.StatusOK, request(handler, http.MethodGet, "/receipts/"+held, "").Code)
+
+		assert.Equal(t, http.StatusNoContent, request(handler, http.MethodDelete, "/receipts/"+notHeld, "").Code)
+		assert.Equal(t, http.StatusNotFound, request(handler, http.MethodGet, "/receipts/"+notHeld, "").Code)
+	})
+}
+
+func TestStartScrubInProgress(t *testing.T) {
+	logger.Init("")
+
+	service, db, storageDir := newTestService(t)
+
+	// the scrub blocks while reading a file of the receipt, which is a
+	// named pipe
+	const receiptId = "44444444-4444-4444-8444-444444444444"
+	tree := saveReceipt(t, db, receiptId, true)
+
+	dir := filepath.Join(storageDir, helpers.StorageId("alice", tree.RootHash))
+	assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644))
+	pipe := filepath.Join(dir, "a.txt")
+	assert.NoError(t, syscall.Mkfifo(pipe, 0644))
+
+	handler := newHandler(context.Background(), service, testToken)
+
+	assert.Equal(t, http.StatusAccepted, request(handler, http.MethodPost, "/scrub", "").Code)
+	assert.Equal(t, http.StatusConflict, request(handler, http.MethodPost, "/scrub", "").Code)
+
+	var response scrubResponse
+	assert.NoError(t, json.NewDecoder(request(handler, http.MethodGet, "/scrub", "").Body).Decode(&response))
+	assert.True(t, response.InProgress)
+
+	// the scrub resumes and completes
+	assert.NoError(t, os.WriteFile(pipe, []byte(receiptId), 0644))
+	for service.IsScrubbing() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	response = scrubResponse{}
+	assert.NoError(t, json.NewDecoder(request(handler, http.MethodGet, "/scrub", "").Body).Decode(&response))
+	assert.False(t, response.InProgress)
+	assert.Equal(t, 2, response.LastReport.FilesScanned)
+	assert.Empty(t, response.Corruptions)
+}
+
+func TestRejectWhenDraining(t *testing.T) {
+	logger.Init("")
+
+	service, _, _ := newTestService(t)
+	handler := rejectWhenDraining(service, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/receipts", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	service.Shutdown(context.Background())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/receipts", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "30", recorder.Header().Get("Retry-After"))
+
+	var response errorResponse
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, middleware.ErrShuttingDown.Error(), response.Error)
+}
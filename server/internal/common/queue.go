@@ -0,0 +1,74 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// RequestQueue is the bounded queue of the requests received on a
+// connection: the reader of the connection waits while the queue is
+// full and the client is asked to pause once the queue fills up (high
+// watermark), then to resume once it has drained (low watermark)
+type RequestQueue struct {
+	c    chan Request
+	high int
+	low  int
+
+	// serializes the transitions so that the signals reach the client
+	// in the order of the transitions
+	mu     sync.Mutex
+	paused bool
+	signal func(paused bool)
+}
+
+// NewRequestQueue returns a queue holding up to size requests; signal
+// is called when the client must pause or resume
+func NewRequestQueue(size int, signal func(paused bool)) *RequestQueue {
+	return &RequestQueue{
+		c:      make(chan Request, size),
+		high:   max(1, size*3/4),
+		low:    size / 4,
+		signal: signal,
+	}
+}
+
+// Push adds a request to the queue, waiting while the queue is full
+func (q *RequestQueue) Push(ctx context.Context, r Request) error {
+	select {
+	case q.c <- r:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.paused && len(q.c) >= q.high {
+		q.paused = true
+		q.signal(true)
+	}
+
+	return nil
+}
+
+// Pop removes the oldest request from the queue, waiting while the
+// queue is empty; it returns false once the context is canceled
+func (q *RequestQueue) Pop(ctx context.Context) (Request, bool) {
+	var r Request
+
+	select {
+	case r = <-q.c:
+	case <-ctx.Done():
+		return nil, false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.paused && len(q.c) <= q.low {
+		q.paused = false
+		q.signal(false)
+	}
+
+	return r, true
+}
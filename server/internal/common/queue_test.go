@@ -0,0 +1,47 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestQueue(t *testing.T) {
+	var signals []bool
+	q := NewRequestQueue(4, func(paused bool) {
+		signals = append(signals, paused)
+	})
+
+	ctx := context.Background()
+
+	// the client is asked to pause at the high watermark (3 requests)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, q.Push(ctx, DownloadRequest{Filename: "a.txt"}))
+	}
+	assert.Equal(t, []bool{true}, signals)
+
+	// ... and to resume at the low watermark (1 request)
+	_, ok := q.Pop(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []bool{true}, signals)
+
+	_, ok = q.Pop(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []bool{true, false}, signals)
+
+	// a full queue blocks the reader until the connection is closed
+	assert.NoError(t, q.Push(ctx, DownloadRequest{}))
+	assert.NoError(t, q.Push(ctx, DownloadRequest{}))
+	assert.NoError(t, q.Push(ctx, DownloadRequest{}))
+
+	closedCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Push(closedCtx, DownloadRequest{}), context.DeadlineExceeded)
+
+	// the requests are returned in order
+	r, ok := q.Pop(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, DownloadRequest{Filename: "a.txt"}, r)
+}
@@ -1,10 +1,18 @@
 package common
 
 import (
+	"fmt"
+	"regexp"
+	"time"
+
 	"github.com/glethuillier/fvs/lib/pkg/proofs"
 	"github.com/google/uuid"
 )
 
+// ClientIdPattern restricts the client IDs, which name the directory
+// where the files of each client (tenant) are stored
+var ClientIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
 type File struct {
 	MessageId uuid.UUID
 	RootHash  string
@@ -12,18 +20,33 @@ type File struct {
 	Contents  []byte
 	Proof     []proofs.ProofPart
 	Error     error
+
+	// signature of the proof (see proofs.SignedPayload) and ID of its
+	// key; unset if the server does not sign the proofs
+	Signature      []byte
+	SignatureKeyId string
 }
 
 type TransferRequest struct {
 	MessageId uuid.UUID
 	RootHash  string
 	Filenames []string
+
+	// sizes of the files, in the order of the filenames (nil if the
+	// client did not declare them)
+	Sizes []int64
+
+	// set if the request is invalid (it is then answered with the error)
+	Error error
 }
 
 type DownloadRequest struct {
 	MessageId uuid.UUID
 	RootHash  string
 	Filename  string
+
+	// set if the request is invalid (it is then answered with the error)
+	Error error
 }
 
 type TransferAck struct {
@@ -37,6 +60,61 @@ type ErrorResponse struct {
 	Error     error
 }
 
+// QuotaError is returned when a request of a client exceeds one of
+// its quotas
+type QuotaError struct {
+	// exceeded limit (e.g., "files_per_batch")
+	Limit string
+
+	Max       int64
+	Requested int64
+
+	// delay before the request can be retried (0 if retrying is
+	// pointless)
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf(
+			"quota exceeded: %s (max: %d; retry after %s)",
+			e.Limit, e.Max, e.RetryAfter,
+		)
+	}
+
+	return fmt.Sprintf(
+		"quota exceeded: %s (max: %d, requested: %d)",
+		e.Limit, e.Max, e.Requested,
+	)
+}
+
+// FlowControl asks the client to stop sending requests (paused) or
+// to resume
+type FlowControl struct {
+	Paused bool
+}
+
+// Request is a message received from a client: a TransferRequest,
+// a *File or a DownloadRequest
+type Request interface {
+	isRequest()
+}
+
+// Response is a message sent to a client: a TransferAck, a *File,
+// an ErrorResponse or a FlowControl
+type Response interface {
+	isResponse()
+}
+
+func (TransferRequest) isRequest() {}
+func (*File) isRequest()           {}
+func (DownloadRequest) isRequest() {}
+
+func (TransferAck) isResponse()   {}
+func (*File) isResponse()         {}
+func (ErrorResponse) isResponse() {}
+func (FlowControl) isResponse()   {}
+
 // Merkle Tree
 
 type Tree struct {
@@ -44,10 +122,112 @@ type Tree struct {
 
 	// filename -> self hash
 	FilenameToHash map[string]string
+	// filename -> size (in bytes)
+	FilenameToSize map[string]int64
 	Nodes          map[string]Node
+
+	// hashes of the nodes, level by level: the first level holds the
+	// sorted leaves (padded to a power of 2), the last one the root
+	Levels [][]string
 }
 type Node struct {
 	Parent      string
 	SiblingType proofs.SiblingType
 	Sibling     string
 }
+
+// Receipts
+
+type Receipt struct {
+	ReceiptId string    `json:"receipt_id"`
+	RootHash  string    `json:"root_hash"`
+	ClientId  string    `json:"client_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// nil if the receipt never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// nil if the receipt is not under legal hold
+	LegalHoldUntil *time.Time `json:"legal_hold_until,omitempty"`
+
+	FilesCount int   `json:"files_count"`
+	Size       int64 `json:"size"`
+}
+
+type HoldAction string
+
+const (
+	HoldSet     HoldAction = "set"
+	HoldRelease HoldAction = "release"
+)
+
+// HoldEvent records a change of the legal hold of a receipt
+type HoldEvent struct {
+	ReceiptId string     `json:"receipt_id"`
+	Action    HoldAction `json:"action"`
+	HeldUntil *time.Time `json:"held_until,omitempty"`
+	Reason    string     `json:"reason"`
+	Actor     string     `json:"actor"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// FileInfo describes a file of a receipt
+type FileInfo struct {
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+}
+
+// Scrubbing
+
+// Corruption describes a stored file (or, if the filename is empty,
+// a tree) that does not match the Merkle tree saved in the database
+type Corruption struct {
+	ReceiptId       string    `json:"receipt_id"`
+	Filename        string    `json:"filename,omitempty"`
+	Reason          string    `json:"reason"`
+	FirstDetectedAt time.Time `json:"first_detected_at"`
+	LastDetectedAt  time.Time `json:"last_detected_at"`
+}
+
+// Audit log
+
+type AuditAction string
+
+const (
+	AuditUploadAccepted  AuditAction = "upload_accepted"
+	AuditUploadRejected  AuditAction = "upload_rejected"
+	AuditDownload        AuditAction = "download"
+	AuditReceiptDeleted  AuditAction = "receipt_deleted"
+	AuditReceiptExpired  AuditAction = "receipt_expired"
+	AuditReceiptImported AuditAction = "receipt_imported"
+	AuditReceiptAssigned AuditAction = "receipt_assigned"
+	AuditHoldSet         AuditAction = "hold_set"
+	AuditHoldReleased    AuditAction = "hold_released"
+)
+
+// AuditEvent records a state-changing operation; its hash covers its
+// fields and the hash of the previous event (PrevHash)
+type AuditEvent struct {
+	Sequence  int64             `json:"sequence"`
+	CreatedAt time.Time         `json:"created_at"`
+	Action    AuditAction       `json:"action"`
+	Actor     string            `json:"actor"`
+	ClientId  string            `json:"client_id,omitempty"`
+	ReceiptId string            `json:"receipt_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// AuditVerification reports whether the hash chain of the audit log
+// is intact
+type AuditVerification struct {
+	Events   int64  `json:"events"`
+	LastHash string `json:"last_hash,omitempty"`
+	Valid    bool   `json:"valid"`
+
+	// first event that breaks the chain (if the chain is broken)
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
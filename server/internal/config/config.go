@@ -0,0 +1,252 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Config is the configuration of the server; each setting is read from
+// the configuration file (by its key, e.g., `server.port`), from an
+// environment variable (env tag) or from a command-line flag (named
+// after its key, e.g., `-server.port`)
+type Config struct {
+	LogLevel string `yaml:"log_level" env:"LOG_LEVEL" help:"log level (debug, info, warn or error)"`
+
+	Server    ServerConfig    `yaml:"server"`
+	Auth      AuthConfig      `yaml:"auth"`
+	TLS       TLSConfig       `yaml:"tls"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Hash      HashConfig      `yaml:"hash"`
+	Limits    LimitsConfig    `yaml:"limits"`
+	Quotas    QuotasConfig    `yaml:"quotas"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Retention RetentionConfig `yaml:"retention"`
+	Worm      WormConfig      `yaml:"worm"`
+	Scrub     ScrubConfig     `yaml:"scrub"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Bundles   BundlesConfig   `yaml:"bundles"`
+}
+
+type ServerConfig struct {
+	Address         string   `yaml:"address" env:"LISTEN_ADDRESS" help:"address the server listens on"`
+	Port            int      `yaml:"port" env:"PORT" help:"port of the WebSocket server"`
+	QueueSize       int      `yaml:"queue_size" env:"QUEUE_SIZE" help:"number of requests (and of responses) queued per connection"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long the uploads in progress can run when the server is shut down"`
+}
+
+type AuthConfig struct {
+	ClientTokens     []string `yaml:"client_tokens" env:"CLIENT_TOKENS" secret:"true" help:"client-id:token pairs (comma-separated)"`
+	ClientTokensFile string   `yaml:"client_tokens_file" env:"CLIENT_TOKENS_FILE" help:"file of client-id:token pairs (one per line)"`
+	AllowAnonymous   bool     `yaml:"allow_anonymous" env:"ALLOW_ANONYMOUS" help:"accept the clients that present no credentials"`
+}
+
+type TLSConfig struct {
+	CertFile       string   `yaml:"cert_file" env:"TLS_CERT_FILE" help:"PEM-encoded certificate of the server"`
+	KeyFile        string   `yaml:"key_file" env:"TLS_KEY_FILE" help:"PEM-encoded key of the server"`
+	ClientCAFile   string   `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" help:"PEM-encoded CA bundle verifying the client certificates"`
+	ReloadInterval Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" help:"how often the TLS files are checked for changes"`
+}
+
+type DatabaseConfig struct {
+	URL string `yaml:"url" env:"DATABASE_URL" secret:"url" help:"PostgreSQL URL or path of the SQLite database"`
+}
+
+type StorageConfig struct {
+	Backend string   `yaml:"backend" env:"STORAGE_BACKEND" help:"storage backend (filesystem)"`
+	Dirs    []string `yaml:"dirs" env:"STORAGE_DIRS" help:"storage directories, each holding a full replica of the files (comma-separated)"`
+}
+
+type HashConfig struct {
+	Algorithm string `yaml:"algorithm" env:"HASH_ALGORITHM" help:"hash algorithm of the Merkle trees (sha512)"`
+}
+
+type LimitsConfig struct {
+	MaxMessageSize    int64 `yaml:"max_message_size" env:"MAX_MESSAGE_SIZE" help:"maximum size of a message received from a client (in bytes)"`
+	MaxPreflightFiles int   `yaml:"max_preflight_files" env:"MAX_PREFLIGHT_FILES" help:"maximum number of files listed by a preflight"`
+}
+
+type QuotasConfig struct {
+	FilesPerBatch      int64   `yaml:"files_per_batch" env:"QUOTA_FILES_PER_BATCH" help:"maximum number of files of a batch (0: unlimited)"`
+	BatchBytes         int64   `yaml:"batch_bytes" env:"QUOTA_BATCH_BYTES" help:"maximum size of a batch in bytes (0: unlimited)"`
+	StoredBytes        int64   `yaml:"stored_bytes" env:"QUOTA_STORED_BYTES" help:"maximum size of the files stored per client in bytes (0: unlimited)"`
+	RequestsPerSecond  float64 `yaml:"requests_per_second" env:"QUOTA_REQUESTS_PER_SECOND" help:"maximum number of requests per second per client (0: unlimited)"`
+	ConcurrentSessions int64   `yaml:"concurrent_sessions" env:"QUOTA_CONCURRENT_SESSIONS" help:"maximum number of batches received at the same time per client (0: unlimited)"`
+	File               string  `yaml:"file" env:"QUOTAS_FILE" help:"file of the limits of specific clients"`
+}
+
+type CacheConfig struct {
+	MaxBytes int64 `yaml:"max_bytes" env:"CACHE_MAX_BYTES" help:"maximum size of the cache in bytes (0: disabled)"`
+}
+
+type RetentionConfig struct {
+	MaxAge      Duration `yaml:"max_age" env:"RETENTION_MAX_AGE" help:"maximum age of a receipt (0: unlimited)"`
+	MaxReceipts int      `yaml:"max_receipts" env:"RETENTION_MAX_RECEIPTS" help:"maximum number of receipts kept (0: unlimited)"`
+	ClientQuota int64    `yaml:"client_quota" env:"RETENTION_CLIENT_QUOTA" help:"maximum number of bytes kept per client (0: unlimited)"`
+	Interval    Duration `yaml:"interval" env:"RETENTION_INTERVAL" help:"how often the retention policy is enforced"`
+}
+
+type WormConfig struct {
+	Mode   bool     `yaml:"mode" env:"WORM_MODE" help:"write-once-read-many mode"`
+	Period Duration `yaml:"period" env:"WORM_PERIOD" help:"how long the receipts are protected in WORM mode (0: forever)"`
+}
+
+type ScrubConfig struct {
+	Interval Duration `yaml:"interval" env:"SCRUB_INTERVAL" help:"how often the stored files are re-verified (0: disabled)"`
+}
+
+type AdminConfig struct {
+	Address string `yaml:"address" env:"ADMIN_ADDRESS" help:"address the admin API listens on (plain HTTP: local by default)"`
+	Port    int    `yaml:"port" env:"ADMIN_PORT" help:"port of the admin API (and of the metrics)"`
+	Token   string `yaml:"token" env:"ADMIN_TOKEN" secret:"true" help:"token of the admin API (the API is disabled without token)"`
+}
+
+type BundlesConfig struct {
+	SigningKey string `yaml:"signing_key" env:"BUNDLE_SIGNING_KEY" secret:"true" help:"key signing the exported receipt bundles and verifying the imported ones"`
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		LogLevel: "info",
+		Server: ServerConfig{
+			Address:         "0.0.0.0",
+			Port:            3000,
+			QueueSize:       32,
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		TLS: TLSConfig{
+			ReloadInterval: Duration(time.Minute),
+		},
+		Database: DatabaseConfig{
+			URL: "proofs.db",
+		},
+		Storage: StorageConfig{
+			Backend: "filesystem",
+			Dirs:    []string{"downloads"},
+		},
+		Hash: HashConfig{
+			Algorithm: "sha512",
+		},
+		Limits: LimitsConfig{
+			MaxMessageSize:    64 << 20,
+			MaxPreflightFiles: 10000,
+		},
+		Cache: CacheConfig{
+			MaxBytes: 64 << 20,
+		},
+		Retention: RetentionConfig{
+			Interval: Duration(time.Hour),
+		},
+		Scrub: ScrubConfig{
+			Interval: Duration(24 * time.Hour),
+		},
+		Admin: AdminConfig{
+			Address: "127.0.0.1",
+			Port:    3002,
+		},
+	}
+}
+
+// Validate checks the settings that do not depend on external files
+// (e.g., the TLS certificates are loaded by the server); it reports
+// all the invalid settings at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	var level zapcore.Level
+	check(level.Set(c.LogLevel) == nil, "log_level", "unknown level %q", c.LogLevel)
+
+	check(validPort(c.Server.Port), "server.port", "must be between 1 and 65535")
+	check(c.Server.QueueSize > 0, "server.queue_size", "must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	check(
+		(c.TLS.CertFile == "") == (c.TLS.KeyFile == ""),
+		"tls", "cert_file and key_file must be set together",
+	)
+	check(
+		c.TLS.ClientCAFile == "" || c.TLS.CertFile != "",
+		"tls.client_ca_file", "requires cert_file and key_file",
+	)
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive")
+
+	check(c.Database.URL != "", "database.url", "cannot be empty")
+
+	check(c.Storage.Backend == "filesystem", "storage.backend", "unsupported backend %q (supported: filesystem)", c.Storage.Backend)
+	check(len(c.Storage.Dirs) > 0, "storage.dirs", "at least one directory is required")
+
+	check(c.Hash.Algorithm == "sha512", "hash.algorithm", "unsupported algorithm %q (supported: sha512)", c.Hash.Algorithm)
+
+	check(c.Limits.MaxMessageSize > 0, "limits.max_message_size", "must be positive")
+	check(c.Limits.MaxPreflightFiles > 0, "limits.max_preflight_files", "must be positive")
+
+	check(c.Quotas.FilesPerBatch >= 0, "quotas.files_per_batch", "cannot be negative")
+	check(c.Quotas.BatchBytes >= 0, "quotas.batch_bytes", "cannot be negative")
+	check(c.Quotas.StoredBytes >= 0, "quotas.stored_bytes", "cannot be negative")
+	check(
+		c.Quotas.RequestsPerSecond >= 0 && !math.IsInf(c.Quotas.RequestsPerSecond, 0),
+		"quotas.requests_per_second", "must be a finite, non-negative number",
+	)
+	check(c.Quotas.ConcurrentSessions >= 0, "quotas.concurrent_sessions", "cannot be negative")
+
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes", "cannot be negative")
+
+	check(c.Retention.MaxAge >= 0, "retention.max_age", "cannot be negative")
+	check(c.Retention.MaxReceipts >= 0, "retention.max_receipts", "cannot be negative")
+	check(c.Retention.ClientQuota >= 0, "retention.client_quota", "cannot be negative")
+	check(c.Retention.Interval > 0, "retention.interval", "must be positive")
+
+	check(c.Worm.Period >= 0, "worm.period", "cannot be negative")
+
+	check(c.Scrub.Interval >= 0, "scrub.interval", "cannot be negative")
+
+	check(validPort(c.Admin.Port), "admin.port", "must be between 1 and 65535")
+
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// Duration is a duration that can be expressed in days (e.g., "90d")
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ParseDuration extends time.ParseDuration with days (e.g., "90d")
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	err := os.WriteFile(path, []byte(`
+server:
+  port: 4000
+  queue_size: 8
+database:
+  url: postgres://mps:secret@db/mps
+storage:
+  dirs: [a, b]
+retention:
+  max_age: 90d
+`), 0o600)
+	assert.NoError(t, err)
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("QUEUE_SIZE", "16")
+	t.Setenv("CLIENT_TOKENS", "alice:a, bob:b")
+
+	c, args, err := Load([]string{"-server.port", "5000", "-worm.mode", "hold", "show"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hold", "show"}, args)
+
+	// defaults < file < environment < flags
+	assert.Equal(t, "0.0.0.0", c.Server.Address)
+	assert.Equal(t, "127.0.0.1", c.Admin.Address)
+	assert.Equal(t, 5000, c.Server.Port)
+	assert.Equal(t, 16, c.Server.QueueSize)
+	assert.Equal(t, []string{"a", "b"}, c.Storage.Dirs)
+	assert.Equal(t, Duration(90*24*time.Hour), c.Retention.MaxAge)
+	assert.Equal(t, []string{"alice:a", "bob:b"}, c.Auth.ClientTokens)
+	assert.True(t, c.Worm.Mode)
+
+	// the secrets are redacted, without altering the configuration
+	r := c.Redacted()
+	assert.Equal(t, []string{"REDACTED", "REDACTED"}, r.Auth.ClientTokens)
+	assert.Equal(t, "postgres://mps:REDACTED@db/mps", r.Database.URL)
+	assert.Equal(t, []string{"alice:a", "bob:b"}, c.Auth.ClientTokens)
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	unknownKey := filepath.Join(dir, "unknown.yaml")
+	assert.NoError(t, os.WriteFile(unknownKey, []byte("server:\n  prot: 4000\n"), 0o600))
+
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		expectedError string
+	}{
+		{
+			name:          "Unknown key in the file",
+			args:          []string{"-config", unknownKey},
+			expectedError: "field prot not found",
+		},
+		{
+			name:          "Missing file",
+			args:          []string{"-config", filepath.Join(dir, "missing.yaml")},
+			expectedError: "no such file",
+		},
+		{
+			name:          "Invalid environment variable",
+			env:           map[string]string{"PORT": "http"},
+			expectedError: "invalid PORT",
+		},
+		{
+			name:          "Invalid flag",
+			args:          []string{"-retention.max_age", "soon"},
+			expectedError: "retention.max_age",
+		},
+		{
+			name:          "Port out of range",
+			args:          []string{"-server.port", "70000"},
+			expectedError: "server.port: must be between 1 and 65535",
+		},
+		{
+			name:          "Key without certificate",
+			env:           map[string]string{"TLS_KEY_FILE": "server.key"},
+			expectedError: "tls: cert_file and key_file must be set together",
+		},
+		{
+			name:          "Unsupported storage backend",
+			args:          []string{"-storage.backend", "s3"},
+			expectedError: `storage.backend: unsupported backend "s3"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			_, _, err := Load(tc.args)
+			assert.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Validate())
+
+	// all the invalid settings are reported
+	c.LogLevel = "verbose"
+	c.Server.QueueSize = 0
+	c.Quotas.BatchBytes = -1
+
+	err := c.Validate()
+	assert.ErrorContains(t, err, "log_level")
+	assert.ErrorContains(t, err, "server.queue_size")
+	assert.ErrorContains(t, err, "quotas.batch_bytes")
+}
+
+func TestParseDuration(t *testing.T) {
+	d, err := ParseDuration("90d")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*24*time.Hour, d)
+
+	d, err = ParseDuration("12h")
+	assert.NoError(t, err)
+	assert.Equal(t, 12*time.Hour, d)
+
+	_, err = ParseDuration("xd")
+	assert.Error(t, err)
+}
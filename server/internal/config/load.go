@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	libconfig "github.com/glethuillier/fvs/lib/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the configuration file (flag -config or environment
+// variable CONFIG_FILE), the environment variables and the flags, and
+// validates it; it returns the arguments that follow the flags (e.g.,
+// an administrative command)
+func Load(args []string) (*Config, []string, error) {
+	c := Default()
+
+	args, err := libconfig.Load("server", c, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return c, args, nil
+}
+
+// Redacted returns a copy of the configuration whose secrets are
+// replaced (e.g., to be shown to the users)
+func (c *Config) Redacted() *Config {
+	r := *c
+	libconfig.Redact(&r)
+
+	return &r
+}
+
+// YAML returns the configuration in the format of the configuration file
+func (c *Config) YAML() ([]byte, error) {
+	var b bytes.Buffer
+
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), encoder.Close()
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+)
+
+// genesisHash is the previous hash of the first event of the audit log
+var genesisHash = strings.Repeat("0", 2*sha256.Size)
+
+// AuditFilter restricts the events returned by ListAuditEvents
+type AuditFilter struct {
+	ClientId  string
+	ReceiptId string
+	Action    common.AuditAction
+
+	// only the events following this sequence number are returned
+	After int64
+	Limit int
+}
+
+// auditHash returns the hash of an event: the SHA-256 digest of its
+// fields (the creation date in seconds) and of the hash of the previous
+// event, encoded as a JSON array
+func auditHash(e *common.AuditEvent, details string) string {
+	data, _ := json.Marshal([]any{
+		e.Sequence,
+		e.CreatedAt.Unix(),
+		e.Action,
+		e.Actor,
+		e.ClientId,
+		e.ReceiptId,
+		details,
+		e.PrevHash,
+	})
+
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// AppendAuditEvent appends an event to the audit log, chaining it to the
+// last event; the sequence number and the hashes of the event are set
+func (db *Database) AppendAuditEvent(event *common.AuditEvent) error {
+	defer metrics.ObserveQuery("append_audit_event", time.Now())
+
+	db.auditMu.Lock()
+	defer db.auditMu.Unlock()
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if lock := db.dialect.auditLock(); lock != "" {
+		if _, err = tx.Exec(lock); err != nil {
+			return fmt.Errorf("failed to lock the audit log: %w", err)
+		}
+	}
+
+	var (
+		sequence int64
+		prevHash = genesisHash
+	)
+	err = tx.QueryRow(
+		"SELECT sequence, hash FROM AUDIT_LOG ORDER BY sequence DESC LIMIT 1",
+	).Scan(&sequence, &prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error executing query: %v", err)
+	}
+
+	event.Sequence = sequence + 1
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Second)
+	event.PrevHash = prevHash
+	event.Hash = auditHash(event, string(details))
+
+	query := `
+	INSERT INTO AUDIT_LOG (sequence, created_at, action, actor, client_id, receipt_id, details, prev_hash, hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = tx.Exec(
+		query,
+		event.Sequence,
+		event.CreatedAt.Unix(),
+		string(event.Action),
+		event.Actor,
+		event.ClientId,
+		event.ReceiptId,
+		string(details),
+		event.PrevHash,
+		event.Hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+const auditQuery = `
+    SELECT
+        sequence,
+        created_at,
+        action,
+        actor,
+        client_id,
+        receipt_id,
+        details,
+        prev_hash,
+        hash
+    FROM
+        AUDIT_LOG`
+
+// ListAuditEvents returns the events of the audit log matching a
+// filter, in the order in which they were appended
+func (db *Database) ListAuditEvents(filter AuditFilter) ([]common.AuditEvent, error) {
+	defer metrics.ObserveQuery("list_audit_events", time.Now())
+
+	conditions := []string{"sequence > ?"}
+	args := []any{filter.After}
+
+	for column, value := range map[string]string{
+		"client_id":  filter.ClientId,
+		"receipt_id": filter.ReceiptId,
+		"action":     string(filter.Action),
+	} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	query := auditQuery + `
+    WHERE
+        ` + strings.Join(conditions, " AND ") + `
+    ORDER BY
+        sequence`
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	var events []common.AuditEvent
+	for rows.Next() {
+		e, details, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal([]byte(details), &e.Details); err != nil {
+			return nil, fmt.Errorf("invalid details of audit event %d: %v", e.Sequence, err)
+		}
+
+		events = append(events, *e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return events, nil
+}
+
+// VerifyAuditLog walks the audit log and recomputes its hash chain:
+// the sequence numbers must follow each other from 1, each event must
+// refer to the hash of the previous one, and its hash must match its
+// fields
+func (db *Database) VerifyAuditLog() (*common.AuditVerification, error) {
+	defer metrics.ObserveQuery("verify_audit_log", time.Now())
+
+	rows, err := db.Query(auditQuery + `
+    ORDER BY
+        sequence`)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	report := &common.AuditVerification{Valid: true}
+	prevHash := genesisHash
+
+	for rows.Next() {
+		e, details, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		var reason string
+		switch {
+		case e.Sequence != report.Events+1:
+			reason = fmt.Sprintf("sequence %d follows sequence %d", e.Sequence, report.Events)
+		case e.PrevHash != prevHash:
+			reason = "the previous hash does not match the previous event"
+		case e.Hash != auditHash(e, details):
+			reason = "the hash does not match the event"
+		}
+
+		if reason != "" {
+			report.Valid = false
+			report.BrokenAt = e.Sequence
+			report.Reason = reason
+			return report, nil
+		}
+
+		report.Events++
+		report.LastHash = e.Hash
+		prevHash = e.Hash
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return report, nil
+}
+
+// scanAuditEvent returns an event of the audit log, without its details,
+// and its details as stored (from which its hash is computed)
+func scanAuditEvent(row scanner) (*common.AuditEvent, string, error) {
+	var (
+		e         common.AuditEvent
+		createdAt int64
+		action    string
+		details   string
+	)
+
+	err := row.Scan(
+		&e.Sequence,
+		&createdAt,
+		&action,
+		&e.Actor,
+		&e.ClientId,
+		&e.ReceiptId,
+		&details,
+		&e.PrevHash,
+		&e.Hash,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("error scanning row: %v", err)
+	}
+
+	e.CreatedAt = time.Unix(createdAt, 0).UTC()
+	e.Action = common.AuditAction(action)
+
+	return &e, details, nil
+}
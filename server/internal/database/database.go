@@ -2,48 +2,98 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"sync"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Database struct {
 	*sql.DB
+	dialect dialect
+
+	// serializes the appends to the audit log
+	auditMu sync.Mutex
+}
+
+// Tx is a transaction whose queries are rebound for the database engine
+type Tx struct {
+	*sql.Tx
+	dialect dialect
 }
 
-// CreateDatabase creates a local SQLite3 database to store
-// the information required to generate the proofs
-func CreateDatabase(dataSourceName string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+// ErrReceiptOnHold is returned when an operation would modify
+// or delete a receipt under legal hold
+var ErrReceiptOnHold = errors.New("receipt is under legal hold")
+
+// ErrReceiptNotFound is returned when a receipt does not exist
+var ErrReceiptNotFound = errors.New("receipt not found")
+
+// ErrFileNotFound is returned when a receipt does not contain a file
+var ErrFileNotFound = errors.New("file not found")
+
+// CreateDatabase opens the database corresponding to a data source name
+// (a PostgreSQL URL or the path of a SQLite database file) and migrates
+// its schema to the latest version
+func CreateDatabase(dsn string) (*Database, error) {
+	d := dialectFromDSN(dsn)
+
+	sqlDB, err := sql.Open(d.driverName(), dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(
-		`
-		CREATE TABLE IF NOT EXISTS RECEIPTS (
-			root_hash_id 	INTEGER PRIMARY KEY AUTOINCREMENT,
-			receipt_id		TEXT    UNIQUE NOT NULL,
-			root_hash       TEXT    UNIQUE NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS FILES (
-			file_id      INTEGER PRIMARY KEY AUTOINCREMENT,
-			root_hash_id REFERENCES RECEIPTS (root_hash_id) NOT NULL,
-			filename     TEXT    NOT NULL,
-			self_hash    TEXT    NOT NULL
-		);
-		CREATE TABLE IF NOT EXISTS TREES (
-			path_id      INTEGER PRIMARY KEY AUTOINCREMENT,
-			root_hash_id INTEGER REFERENCES RECEIPTS (root_hash_id),
-			self_hash    TEXT    NOT NULL,
-			parent_hash  TEXT    NOT NULL,
-			sibling_hash TEXT    NOT NULL,
-			sibling_type TEXT    NOT NULL
-				CHECK (sibling_type IN ('none', 'left', 'right') ) 
-		);
-	`)
+	if err = sqlDB.Ping(); err != nil {
+		return nil, err
+	}
+
+	db := &Database{DB: sqlDB, dialect: d}
+	if err = db.migrate(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *Database) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.rebind(query))
+}
+
+func (db *Database) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Database{db}, nil
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.rebind(query))
 }
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect abstracts the differences between the supported database
+// engines; the queries are written with `?` placeholders and rebound
+// for the engines that use another syntax
+type dialect interface {
+	// name of the directory of the migrations
+	name() string
+	driverName() string
+	rebind(query string) string
+
+	// statement preventing concurrent migrations (within a transaction)
+	migrationsLock() string
+
+	// statement preventing concurrent appends to the audit log (within
+	// a transaction)
+	auditLock() string
+}
+
+// sqliteDialect is the dialect of SQLite (local database file)
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) driverName() string { return "sqlite3" }
+
+// a SQLite database file is used by a single server
+func (sqliteDialect) migrationsLock() string { return "" }
+
+// the appends of a server are serialized, and a SQLite database file
+// is used by a single server
+func (sqliteDialect) auditLock() string { return "" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+// postgresDialect is the dialect of PostgreSQL (shared database, which
+// allows running several instances of the server)
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+func (postgresDialect) driverName() string { return "postgres" }
+
+// several servers can share a PostgreSQL database
+func (postgresDialect) migrationsLock() string {
+	return "SELECT pg_advisory_xact_lock(7368535)"
+}
+
+func (postgresDialect) auditLock() string {
+	return "SELECT pg_advisory_xact_lock(7368536)"
+}
+
+// rebind replaces the `?` placeholders with `$1`, `$2`, etc. (the
+// queries do not contain literal question marks)
+func (postgresDialect) rebind(query string) string {
+	var (
+		b strings.Builder
+		n int
+	)
+
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// dialectFromDSN selects the database engine corresponding to a data
+// source name: PostgreSQL for `postgres://` and `postgresql://` URLs,
+// SQLite otherwise (path of the database file)
+func dialectFromDSN(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect{}
+	}
+
+	return sqliteDialect{}
+}
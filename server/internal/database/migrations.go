@@ -0,0 +1,52 @@
+package database
+
+import (
+	"embed"
+	"path"
+
+	"github.com/glethuillier/fvs/lib/pkg/schema"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// migrationsFS holds the schema migrations of each database engine
+// (`migrations/{engine}/{version}_{name}.sql`)
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// ErrUnknownSchema is returned when the database has been migrated
+// by a more recent version of the server
+var ErrUnknownSchema = schema.ErrUnknownVersion
+
+// SchemaVersion returns the version of the schema of the database
+// (0 if no migration has been applied)
+func (db *Database) SchemaVersion() (int, error) {
+	return schema.Version(db.DB)
+}
+
+// migrate applies the pending migrations, each one in a transaction, and
+// refuses to run against a schema more recent than the known migrations
+// (the migrations are locked when several servers share the database)
+func (db *Database) migrate() error {
+	migrations, err := schema.Load(migrationsFS, path.Join("migrations", db.dialect.name()))
+	if err != nil {
+		return err
+	}
+
+	applied, err := schema.Migrate(db.DB, schema.Dialect{
+		Lock:          db.dialect.migrationsLock(),
+		TimestampType: "BIGINT",
+		Rebind:        db.dialect.rebind,
+	}, migrations)
+
+	for _, m := range applied {
+		logger.Logger.Info(
+			"database migrated",
+			zap.Int("version", m.Version),
+			zap.String("name", m.Name),
+		)
+	}
+
+	return err
+}
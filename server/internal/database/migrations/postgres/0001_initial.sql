@@ -0,0 +1,21 @@
+-- schema of the databases created before versioned migrations
+CREATE TABLE IF NOT EXISTS RECEIPTS (
+	root_hash_id BIGSERIAL PRIMARY KEY,
+	receipt_id   TEXT      UNIQUE NOT NULL,
+	root_hash    TEXT      UNIQUE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS FILES (
+	file_id      BIGSERIAL PRIMARY KEY,
+	root_hash_id BIGINT    NOT NULL REFERENCES RECEIPTS (root_hash_id),
+	filename     TEXT      NOT NULL,
+	self_hash    TEXT      NOT NULL
+);
+CREATE TABLE IF NOT EXISTS TREES (
+	path_id      BIGSERIAL PRIMARY KEY,
+	root_hash_id BIGINT    REFERENCES RECEIPTS (root_hash_id),
+	self_hash    TEXT      NOT NULL,
+	parent_hash  TEXT      NOT NULL,
+	sibling_hash TEXT      NOT NULL,
+	sibling_type TEXT      NOT NULL
+		CHECK (sibling_type IN ('none', 'left', 'right') )
+);
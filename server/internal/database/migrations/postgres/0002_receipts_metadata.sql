@@ -0,0 +1,36 @@
+-- receipts: client (tenant), timestamps and legal hold (the existing
+-- receipts are considered created now); a root hash is unique per client
+ALTER TABLE RECEIPTS ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE RECEIPTS ADD COLUMN IF NOT EXISTS created_at BIGINT NOT NULL
+	DEFAULT EXTRACT(EPOCH FROM now())::BIGINT;
+ALTER TABLE RECEIPTS ALTER COLUMN created_at DROP DEFAULT;
+ALTER TABLE RECEIPTS ADD COLUMN IF NOT EXISTS expires_at BIGINT;
+ALTER TABLE RECEIPTS ADD COLUMN IF NOT EXISTS legal_hold_until BIGINT;
+ALTER TABLE RECEIPTS DROP CONSTRAINT IF EXISTS receipts_root_hash_key;
+ALTER TABLE RECEIPTS DROP CONSTRAINT IF EXISTS receipts_client_id_root_hash_key;
+ALTER TABLE RECEIPTS ADD CONSTRAINT receipts_client_id_root_hash_key
+	UNIQUE (client_id, root_hash);
+
+-- size of the files (unknown for the existing files)
+ALTER TABLE FILES ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS HOLDS_AUDIT (
+	event_id   BIGSERIAL PRIMARY KEY,
+	receipt_id TEXT      NOT NULL,
+	action     TEXT      NOT NULL
+		CHECK (action IN ('set', 'release') ),
+	held_until BIGINT,
+	reason     TEXT      NOT NULL,
+	actor      TEXT      NOT NULL,
+	created_at BIGINT    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS CORRUPTED_FILES (
+	corruption_id     BIGSERIAL PRIMARY KEY,
+	receipt_id        TEXT      NOT NULL,
+	filename          TEXT      NOT NULL,
+	reason            TEXT      NOT NULL,
+	first_detected_at BIGINT    NOT NULL,
+	last_detected_at  BIGINT    NOT NULL,
+	UNIQUE (receipt_id, filename)
+);
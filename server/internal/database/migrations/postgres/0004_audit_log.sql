@@ -0,0 +1,32 @@
+-- append-only log of the state-changing operations; each event is
+-- chained to the previous one (its hash covers its fields and the hash
+-- of the previous event), so that a modified, deleted or inserted event
+-- breaks the chain
+CREATE TABLE IF NOT EXISTS AUDIT_LOG (
+	sequence   BIGINT PRIMARY KEY,
+	created_at BIGINT NOT NULL,
+	action     TEXT   NOT NULL,
+	actor      TEXT   NOT NULL,
+	client_id  TEXT   NOT NULL,
+	receipt_id TEXT   NOT NULL,
+	details    TEXT   NOT NULL,
+	prev_hash  TEXT   NOT NULL,
+	hash       TEXT   NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_by_receipt ON AUDIT_LOG (receipt_id);
+CREATE INDEX IF NOT EXISTS audit_log_by_client ON AUDIT_LOG (client_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_change ON AUDIT_LOG;
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON AUDIT_LOG
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON AUDIT_LOG;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON AUDIT_LOG
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,42 @@
+-- receipts: client (tenant), timestamps and legal hold; a root hash is
+-- unique per client (SQLite cannot alter constraints: the table is
+-- rebuilt, and the existing receipts are considered created now)
+CREATE TABLE RECEIPTS_NEW (
+	root_hash_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	receipt_id       TEXT    UNIQUE NOT NULL,
+	root_hash        TEXT    NOT NULL,
+	client_id        TEXT    NOT NULL DEFAULT '',
+	created_at       INTEGER NOT NULL,
+	expires_at       INTEGER,
+	legal_hold_until INTEGER,
+	UNIQUE (client_id, root_hash)
+);
+INSERT INTO RECEIPTS_NEW (root_hash_id, receipt_id, root_hash, created_at)
+	SELECT root_hash_id, receipt_id, root_hash, CAST(strftime('%s', 'now') AS INTEGER)
+	FROM RECEIPTS;
+DROP TABLE RECEIPTS;
+ALTER TABLE RECEIPTS_NEW RENAME TO RECEIPTS;
+
+-- size of the files (unknown for the existing files)
+ALTER TABLE FILES ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE HOLDS_AUDIT (
+	event_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	receipt_id TEXT    NOT NULL,
+	action     TEXT    NOT NULL
+		CHECK (action IN ('set', 'release') ),
+	held_until INTEGER,
+	reason     TEXT    NOT NULL,
+	actor      TEXT    NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+CREATE TABLE CORRUPTED_FILES (
+	corruption_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	receipt_id        TEXT    NOT NULL,
+	filename          TEXT    NOT NULL,
+	reason            TEXT    NOT NULL,
+	first_detected_at INTEGER NOT NULL,
+	last_detected_at  INTEGER NOT NULL,
+	UNIQUE (receipt_id, filename)
+);
@@ -0,0 +1,16 @@
+-- trees stored level by level: the sibling of the node at a given
+-- position is at (position XOR 1) and its parent at (position / 2) on
+-- the next level, so that a proof is read with one row per level
+CREATE TABLE TREE_NODES (
+	root_hash_id INTEGER NOT NULL REFERENCES RECEIPTS (root_hash_id),
+	level        INTEGER NOT NULL,
+	position     INTEGER NOT NULL,
+	hash         TEXT    NOT NULL,
+	PRIMARY KEY (root_hash_id, level, position)
+);
+
+-- position of the leaf of each file (unknown for the trees saved in
+-- TREES, which are indexed when the server starts)
+ALTER TABLE FILES ADD COLUMN leaf_index INTEGER;
+
+CREATE INDEX FILES_BY_FILENAME ON FILES (root_hash_id, filename);
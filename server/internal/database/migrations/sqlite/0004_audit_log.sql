@@ -0,0 +1,28 @@
+-- append-only log of the state-changing operations; each event is
+-- chained to the previous one (its hash covers its fields and the hash
+-- of the previous event), so that a modified, deleted or inserted event
+-- breaks the chain
+CREATE TABLE AUDIT_LOG (
+	sequence   INTEGER PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	action     TEXT    NOT NULL,
+	actor      TEXT    NOT NULL,
+	client_id  TEXT    NOT NULL,
+	receipt_id TEXT    NOT NULL,
+	details    TEXT    NOT NULL,
+	prev_hash  TEXT    NOT NULL,
+	hash       TEXT    NOT NULL
+);
+
+CREATE INDEX AUDIT_LOG_BY_RECEIPT ON AUDIT_LOG (receipt_id);
+CREATE INDEX AUDIT_LOG_BY_CLIENT ON AUDIT_LOG (client_id);
+
+CREATE TRIGGER AUDIT_LOG_NO_UPDATE BEFORE UPDATE ON AUDIT_LOG
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+CREATE TRIGGER AUDIT_LOG_NO_DELETE BEFORE DELETE ON AUDIT_LOG
+BEGIN
+	SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
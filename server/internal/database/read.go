@@ -4,21 +4,29 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/glethuillier/fvs/lib/pkg/proofs"
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
 	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/glethuillier/fvs/server/internal/proofs"
 )
 
 // IsTreeAlreadyPresent checks whether a root hash has already been
-// saved in the database (meaning that the client already sent the
-// files to the server). If a root hash is already present, the function
-// returns the receipt ID corresponding to the root hash.
-func (db *Database) IsTreeAlreadyPresent(rootHash string) (bool, string, error) {
+// saved in the database by a client (meaning that the client already
+// sent the files to the server). If a root hash is already present, the
+// function returns the receipt ID corresponding to the root hash.
+func (db *Database) IsTreeAlreadyPresent(clientId, rootHash string) (bool, string, error) {
+	defer metrics.ObserveQuery("is_tree_already_present", time.Now())
+
 	var receiptID string
 
-	query := `SELECT receipt_id FROM RECEIPTS WHERE root_hash = ?`
+	query := `SELECT receipt_id FROM RECEIPTS WHERE client_id = ? AND root_hash = ?`
 
-	err := db.QueryRow(query, rootHash).Scan(&receiptID)
+	err := db.QueryRow(query, clientId, rootHash).Scan(&receiptID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, "", nil
@@ -29,12 +37,15 @@ func (db *Database) IsTreeAlreadyPresent(rootHash string) (bool, string, error)
 	return true, receiptID, nil
 }
 
-// GetRootHash returns the root hash corresponding to a receipt ID
-func (db *Database) GetRootHash(receiptId string) (string, error) {
+// GetRootHash returns the root hash corresponding to a receipt ID of
+// a client (the receipts of the other clients are not found)
+func (db *Database) GetRootHash(clientId, receiptId string) (string, error) {
+	defer metrics.ObserveQuery("get_root_hash", time.Now())
+
 	// get root hash
 	var rootHash string
-	query := "SELECT root_hash FROM RECEIPTS WHERE receipt_id = ?"
-	err := db.QueryRow(query, receiptId).Scan(&rootHash)
+	query := "SELECT root_hash FROM RECEIPTS WHERE client_id = ? AND receipt_id = ?"
+	err := db.QueryRow(query, clientId, receiptId).Scan(&rootHash)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", fmt.Errorf("receipt_id %s not found", receiptId)
@@ -45,18 +56,132 @@ func (db *Database) GetRootHash(receiptId string) (string, error) {
 	return rootHash, nil
 }
 
-// GetTree returns a Merkle tree corresponding to a root hash
-func (db *Database) GetTree(rootHash string) (*common.Tree, error) {
+// GetStoredBytes returns the total size of the files of the receipts
+// of a client
+func (db *Database) GetStoredBytes(clientId string) (int64, error) {
+	defer metrics.ObserveQuery("get_stored_bytes", time.Now())
+
+	var size int64
+	query := `
+    SELECT
+        COALESCE(SUM(f.size), 0)
+    FROM
+        FILES f
+    JOIN
+        RECEIPTS rh ON f.root_hash_id = rh.root_hash_id
+    WHERE
+        rh.client_id = ?;`
+
+	err := db.QueryRow(query, clientId).Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("error executing query: %v", err)
+	}
+
+	return size, nil
+}
+
+// GetTree returns the Merkle tree corresponding to a receipt ID (the
+// whole tree is loaded: to prove a single file, see GetFileProof)
+func (db *Database) GetTree(receiptId string) (*common.Tree, error) {
+	defer metrics.ObserveQuery("get_tree", time.Now())
+
 	tree := common.Tree{
 		FilenameToHash: make(map[string]string),
-		Nodes:          make(map[string]common.Node),
+		FilenameToSize: make(map[string]int64),
+	}
+
+	files, err := db.GetFiles(receiptId)
+	if err != nil {
+		return nil, err
 	}
 
-	// get filenames and their hashes
+	for _, f := range files {
+		tree.FilenameToHash[f.Filename] = f.Hash
+		tree.FilenameToSize[f.Filename] = f.Size
+	}
+
+	// get the nodes, level by level
 	query := `
     SELECT
-        f.filename,
-        f.self_hash
+        n.level,
+        n.position,
+        n.hash
+    FROM
+        TREE_NODES n
+    JOIN
+        RECEIPTS rh
+    ON
+        n.root_hash_id = rh.root_hash_id
+    WHERE
+        rh.receipt_id = ?
+    ORDER BY
+        n.level,
+        n.position;`
+
+	rows, err := db.Query(query, receiptId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			level, position int
+			h               string
+		)
+		if err := rows.Scan(&level, &position, &h); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		if level == len(tree.Levels) {
+			tree.Levels = append(tree.Levels, nil)
+		}
+
+		if level != len(tree.Levels)-1 || position != len(tree.Levels[level]) {
+			return nil, fmt.Errorf("node (%d, %d) of the tree is missing", level, position)
+		}
+
+		tree.Levels[level] = append(tree.Levels[level], h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	if len(tree.Levels) == 0 {
+		return nil, fmt.Errorf("tree of receipt_id %s not found", receiptId)
+	}
+
+	for l, level := range tree.Levels[:len(tree.Levels)-1] {
+		if len(level) != 2*len(tree.Levels[l+1]) {
+			return nil, fmt.Errorf("level %d of the tree is incomplete", l)
+		}
+	}
+
+	tree.RootHash = tree.Levels[len(tree.Levels)-1][0]
+	tree.Nodes = proofs.NodesFromLevels(tree.Levels)
+
+	return &tree, nil
+}
+
+// GetFileProof returns the hash of a file of a receipt and the proof
+// that the file belongs to the tree; only the sibling of each level is
+// read, so that the cost grows with the height of the tree
+func (db *Database) GetFileProof(receiptId, filename string) (string, []libproofs.ProofPart, error) {
+	defer metrics.ObserveQuery("get_file_proof", time.Now())
+
+	var (
+		rootHashID int64
+		selfHash   string
+		leafIndex  sql.NullInt64
+		height     int
+	)
+
+	query := `
+    SELECT
+        f.root_hash_id,
+        f.self_hash,
+        f.leaf_index
     FROM
         FILES f
     JOIN
@@ -64,82 +189,489 @@ func (db *Database) GetTree(rootHash string) (*common.Tree, error) {
     ON
         f.root_hash_id = rh.root_hash_id
     WHERE
-        rh.root_hash = ?;`
+        rh.receipt_id = ? AND f.filename = ?;`
 
-	stmt, err := db.Prepare(query)
+	err := db.QueryRow(query, receiptId, filename).Scan(&rootHashID, &selfHash, &leafIndex)
 	if err != nil {
-		return nil, fmt.Errorf("error preparing query: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, ErrFileNotFound
+		}
+		return "", nil, fmt.Errorf("error executing query: %v", err)
+	}
+
+	if !leafIndex.Valid {
+		return "", nil, fmt.Errorf("tree of receipt_id %s is not indexed", receiptId)
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query(rootHash)
+	query = "SELECT COALESCE(MAX(level), 0) FROM TREE_NODES WHERE root_hash_id = ?"
+	if err = db.QueryRow(query, rootHashID).Scan(&height); err != nil {
+		return "", nil, fmt.Errorf("error executing query: %v", err)
+	}
+
+	// one sibling per level below the root
+	var (
+		conditions []string
+		args       = []any{rootHashID}
+	)
+	for l, i := 0, leafIndex.Int64; l < height; l, i = l+1, i/2 {
+		conditions = append(conditions, "(level = ? AND position = ?)")
+		args = append(args, l, i^1)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, fmt.Errorf("tree of receipt_id %s not found", receiptId)
+	}
+
+	query = fmt.Sprintf(
+		"SELECT level, hash FROM TREE_NODES WHERE root_hash_id = ? AND (%s)",
+		strings.Join(conditions, " OR "),
+	)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return "", nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	siblings := make([]string, height)
+	for rows.Next() {
+		var (
+			level int
+			h     string
+		)
+		if err := rows.Scan(&level, &h); err != nil {
+			return "", nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		siblings[level] = h
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	proof := make([]libproofs.ProofPart, height)
+	for l, i := 0, leafIndex.Int64; l < height; l, i = l+1, i/2 {
+		if siblings[l] == "" {
+			return "", nil, fmt.Errorf("node (%d, %d) of the tree is missing", l, i^1)
+		}
+
+		// a node at an even position is a left child
+		siblingType := libproofs.RightSibling
+		if i%2 == 1 {
+			siblingType = libproofs.LeftSibling
+		}
+
+		proof[l] = libproofs.ProofPart{
+			SiblingType: siblingType,
+			SiblingHash: siblings[l],
+		}
+	}
+
+	return selfHash, proof, nil
+}
+
+// ListUnindexedReceipts returns the receipts whose tree is only stored
+// in TREES (i.e., saved by a previous version of the server); the
+// receipts whose tree is recorded as corrupted are left out, as their
+// tree cannot be indexed
+func (db *Database) ListUnindexedReceipts() ([]common.Receipt, error) {
+	defer metrics.ObserveQuery("list_unindexed_receipts", time.Now())
+
+	query := receiptsQuery + `
+    WHERE
+        NOT EXISTS (
+            SELECT 1 FROM TREE_NODES n WHERE n.root_hash_id = rh.root_hash_id
+        )
+        AND NOT EXISTS (
+            SELECT 1 FROM CORRUPTED_FILES c WHERE c.receipt_id = rh.receipt_id AND c.filename = ''
+        )
+    GROUP BY
+        rh.root_hash_id
+    ORDER BY
+        rh.root_hash_id;`
+
+	return db.queryReceipts(query)
+}
+
+// ListAnonymousReceipts returns the receipts of anonymous clients (e.g.,
+// uploaded before the tokens were required), from the oldest to the most
+// recent
+func (db *Database) ListAnonymousReceipts() ([]common.Receipt, error) {
+	defer metrics.ObserveQuery("list_anonymous_receipts", time.Now())
+
+	query := receiptsQuery + `
+    WHERE
+        rh.client_id = ''
+    GROUP BY
+        rh.root_hash_id
+    ORDER BY
+        rh.created_at, rh.root_hash_id;`
+
+	return db.queryReceipts(query)
+}
+
+// receiptsQuery selects the receipts alongside the number of files
+// and the total size of each batch
+const receiptsQuery = `
+    SELECT
+        rh.receipt_id,
+        rh.root_hash,
+        rh.client_id,
+        rh.created_at,
+        rh.expires_at,
+        rh.legal_hold_until,
+        COUNT(f.file_id),
+        COALESCE(SUM(f.size), 0)
+    FROM
+        RECEIPTS rh
+    LEFT JOIN
+        FILES f ON rh.root_hash_id = f.root_hash_id`
+
+// ListReceipts returns all the receipts, from the oldest to the most
+// recent, alongside the number of files and the total size of each batch
+func (db *Database) ListReceipts() ([]common.Receipt, error) {
+	defer metrics.ObserveQuery("list_receipts", time.Now())
+
+	query := receiptsQuery + `
+    GROUP BY
+        rh.root_hash_id
+    ORDER BY
+        rh.created_at, rh.root_hash_id;`
+
+	return db.queryReceipts(query)
+}
+
+// ReceiptFilter restricts the receipts returned by SearchReceipts
+type ReceiptFilter struct {
+	// prefix of the receipt ID or of the root hash
+	Search   string
+	ClientId string
+	Offset   int
+
+	// maximum number of receipts returned (0: no limit)
+	Limit int
+}
+
+// SearchReceipts returns the receipts matching a filter, from the oldest
+// to the most recent, alongside the total number of matching receipts
+func (db *Database) SearchReceipts(filter ReceiptFilter) ([]common.Receipt, int, error) {
+	defer metrics.ObserveQuery("search_receipts", time.Now())
+
+	var (
+		conditions []string
+		args       []any
+	)
+
+	if filter.ClientId != "" {
+		conditions = append(conditions, "rh.client_id = ?")
+		args = append(args, filter.ClientId)
+	}
+
+	if filter.Search != "" {
+		// (prefixes compared as is: unlike LIKE, substr is case
+		// sensitive with every driver and needs no escaping)
+		n := utf8.RuneCountInString(filter.Search)
+		conditions = append(conditions, "(substr(rh.receipt_id, 1, ?) = ? OR substr(rh.root_hash, 1, ?) = ?)")
+		args = append(args, n, filter.Search, n, filter.Search)
+	}
+
+	var where string
+	if len(conditions) > 0 {
+		where = `
+    WHERE
+        ` + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM RECEIPTS rh`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error executing query: %v", err)
+	}
+
+	// (both SQLite and PostgreSQL need a limit before an offset)
+	limit := int64(filter.Limit)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	query := receiptsQuery + where + `
+    GROUP BY
+        rh.root_hash_id
+    ORDER BY
+        rh.created_at, rh.root_hash_id
+    LIMIT ? OFFSET ?;`
+
+	receipts, err := db.queryReceipts(query, append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return receipts, total, nil
+}
+
+// queryReceipts returns the receipts selected by a query based on
+// receiptsQuery
+func (db *Database) queryReceipts(query string, args ...any) ([]common.Receipt, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error executing query: %v", err)
 	}
 	defer rows.Close()
 
+	var receipts []common.Receipt
 	for rows.Next() {
-		var filename, selfHash string
-		if err := rows.Scan(&filename, &selfHash); err != nil {
+		receipt, err := scanReceipt(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
 
-		tree.FilenameToHash[filename] = selfHash
+		receipts = append(receipts, *receipt)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating rows: %v", err)
 	}
 
-	// get tree
-	query = `
+	return receipts, nil
+}
+
+// GetReceipt returns the receipt corresponding to a receipt ID
+func (db *Database) GetReceipt(receiptId string) (*common.Receipt, error) {
+	defer metrics.ObserveQuery("get_receipt", time.Now())
+
+	query := receiptsQuery + `
+    WHERE
+        rh.receipt_id = ?
+    GROUP BY
+        rh.root_hash_id;`
+
+	receipt, err := scanReceipt(db.QueryRow(query, receiptId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrReceiptNotFound, receiptId)
+		}
+		return nil, err
+	}
+
+	return receipt, nil
+}
+
+// IsReceiptPresent checks whether a receipt ID has already been saved
+// in the database (by any client)
+func (db *Database) IsReceiptPresent(receiptId string) (bool, error) {
+	defer metrics.ObserveQuery("is_receipt_present", time.Now())
+
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM RECEIPTS WHERE receipt_id = ?", receiptId).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error executing query: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// GetHoldEvents returns the audit trail of the legal holds
+// of a receipt
+func (db *Database) GetHoldEvents(receiptId string) ([]common.HoldEvent, error) {
+	defer metrics.ObserveQuery("get_hold_events", time.Now())
+
+	query := `
     SELECT
-        p.self_hash,
-        p.parent_hash,
-        p.sibling_hash,
-        p.sibling_type
+        receipt_id,
+        action,
+        held_until,
+        reason,
+        actor,
+        created_at
     FROM
+        HOLDS_AUDIT
+    WHERE
+        receipt_id = ?
+    ORDER BY
+        event_id;`
+
+	rows, err := db.Query(query, receiptId)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	var events []common.HoldEvent
+	for rows.Next() {
+		var (
+			event     common.HoldEvent
+			heldUntil sql.NullInt64
+			createdAt int64
+		)
+
+		if err := rows.Scan(
+			&event.ReceiptId,
+			&event.Action,
+			&heldUntil,
+			&event.Reason,
+			&event.Actor,
+			&createdAt,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		event.HeldUntil = fromNullUnix(heldUntil)
+		event.CreatedAt = time.Unix(createdAt, 0).UTC()
+
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return events, nil
+}
+
+// GetFiles returns the files corresponding to a receipt ID
+func (db *Database) GetFiles(receiptId string) ([]common.FileInfo, error) {
+	defer metrics.ObserveQuery("get_files", time.Now())
+
+	query := `
+    SELECT
+        f.filename,
+        f.self_hash,
+        f.size
+    FROM
+        FILES f
+    JOIN
         RECEIPTS rh
-    LEFT JOIN
-        FILES f ON rh.root_hash_id = f.root_hash_id
-    LEFT JOIN
-        TREES p ON rh.root_hash_id = p.root_hash_id
+    ON
+        f.root_hash_id = rh.root_hash_id
     WHERE
-        rh.root_hash = ?;`
+        rh.receipt_id = ?
+    ORDER BY
+        f.filename;`
 
-	stmt, err = db.Prepare(query)
+	rows, err := db.Query(query, receiptId)
 	if err != nil {
-		return nil, fmt.Errorf("error preparing query: %v", err)
+		return nil, fmt.Errorf("error executing query: %v", err)
+	}
+	defer rows.Close()
+
+	var files []common.FileInfo
+	for rows.Next() {
+		var f common.FileInfo
+		if err := rows.Scan(&f.Filename, &f.Hash, &f.Size); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+
+		files = append(files, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
 	}
-	defer stmt.Close()
 
-	rows, err = stmt.Query(rootHash)
+	return files, nil
+}
+
+// ListCorruptions returns the corruptions detected by the scrubber
+// that have not been resolved yet
+func (db *Database) ListCorruptions() ([]common.Corruption, error) {
+	defer metrics.ObserveQuery("list_corruptions", time.Now())
+
+	query := `
+    SELECT
+        receipt_id,
+        filename,
+        reason,
+        first_detected_at,
+        last_detected_at
+    FROM
+        CORRUPTED_FILES
+    ORDER BY
+        first_detected_at, corruption_id;`
+
+	rows, err := db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("error executing query: %v", err)
 	}
 	defer rows.Close()
 
+	var corruptions []common.Corruption
 	for rows.Next() {
-		var pathSelfHash, parentHash, siblingHash, siblingType sql.NullString
+		var (
+			c                               common.Corruption
+			firstDetectedAt, lastDetectedAt int64
+		)
+
 		if err := rows.Scan(
-			&pathSelfHash,
-			&parentHash,
-			&siblingHash,
-			&siblingType,
+			&c.ReceiptId,
+			&c.Filename,
+			&c.Reason,
+			&firstDetectedAt,
+			&lastDetectedAt,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
 
-		tree.Nodes[pathSelfHash.String] = common.Node{
-			Parent:      parentHash.String,
-			Sibling:     siblingHash.String,
-			SiblingType: proofs.GetSiblingType(siblingType.String),
-		}
+		c.FirstDetectedAt = time.Unix(firstDetectedAt, 0).UTC()
+		c.LastDetectedAt = time.Unix(lastDetectedAt, 0).UTC()
+
+		corruptions = append(corruptions, c)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating rows: %v", err)
 	}
 
-	return &tree, nil
+	return corruptions, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReceipt(row scanner) (*common.Receipt, error) {
+	var (
+		receipt        common.Receipt
+		createdAt      int64
+		expiresAt      sql.NullInt64
+		legalHoldUntil sql.NullInt64
+	)
+
+	if err := row.Scan(
+		&receipt.ReceiptId,
+		&receipt.RootHash,
+		&receipt.ClientId,
+		&createdAt,
+		&expiresAt,
+		&legalHoldUntil,
+		&receipt.FilesCount,
+		&receipt.Size,
+	); err != nil {
+		return nil, err
+	}
+
+	receipt.CreatedAt = time.Unix(createdAt, 0).UTC()
+	receipt.ExpiresAt = fromNullUnix(expiresAt)
+	receipt.LegalHoldUntil = fromNullUnix(legalHoldUntil)
+
+	return &receipt, nil
+}
+
+// fromNullUnix converts a nullable Unix timestamp into a time
+func fromNullUnix(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+
+	t := time.Unix(v.Int64, 0).UTC()
+	return &t
+}
+
+// toNullUnix converts a time into a nullable Unix timestamp
+func toNullUnix(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
 }
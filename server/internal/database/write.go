@@ -4,143 +4,406 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
-	"github.com/glethuillier/fvs/lib/pkg/proofs"
 	"github.com/glethuillier/fvs/server/internal/common"
 	"github.com/glethuillier/fvs/server/internal/logger"
-	"github.com/google/uuid"
+	"github.com/glethuillier/fvs/server/internal/metrics"
 	"go.uber.org/zap"
 )
 
-// SaveTree saves a receipt ID and the corresponding Merkle tree in the database
-func (db *Database) SaveTree(receiptId uuid.UUID, tree *common.Tree) error {
-	var err error
-	if err = db.addRootHash(receiptId, tree.RootHash); err != nil {
+// SaveTree saves a receipt and the corresponding Merkle tree in the database
+func (db *Database) SaveTree(receipt *common.Receipt, tree *common.Tree) error {
+	defer metrics.ObserveQuery("save_tree", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rootHashID, err := addRootHash(tx, receipt, tree.RootHash)
+	if err != nil {
 		return err
 	}
 
-	for k, v := range tree.FilenameToHash {
-		if err = db.addFile(tree.RootHash, k, v); err != nil {
-			return err
-		}
+	leaves := leafIndexes(tree.Levels)
+
+	statement, err := tx.Prepare(`
+	INSERT INTO FILES (root_hash_id, filename, self_hash, size, leaf_index)
+	VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
+	defer statement.Close()
 
-	for k, v := range tree.Nodes {
-		if err = db.addSubtree(
-			tree.RootHash,
-			k,
-			v.Parent,
-			v.Sibling,
-			v.SiblingType,
-		); err != nil {
-			return err
+	for filename, selfHash := range tree.FilenameToHash {
+		leafIndex, ok := leaves[selfHash]
+		if !ok {
+			return fmt.Errorf("file %s is not a leaf of the tree", filename)
 		}
+
+		_, err = statement.Exec(
+			rootHashID,
+			filename,
+			selfHash,
+			tree.FilenameToSize[filename],
+			leafIndex,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	if err = addLevels(tx, rootHashID, tree.Levels); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// addRootHash saves a root hash corresponding to a given receipt ID
-// in the database
-func (db *Database) addRootHash(receiptId uuid.UUID, rootHash string) error {
+// addRootHash saves a root hash corresponding to a given receipt
+// in the database and returns the ID of the row
+func addRootHash(tx *Tx, receipt *common.Receipt, rootHash string) (int64, error) {
 	query := `
-	INSERT INTO RECEIPTS (receipt_id, root_hash)
-	VALUES (?, ?)
+	INSERT INTO RECEIPTS (receipt_id, root_hash, client_id, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING root_hash_id
 	`
 
-	statement, err := db.Prepare(query)
+	var rootHashID int64
+	err := tx.QueryRow(
+		query,
+		receipt.ReceiptId,
+		rootHash,
+		receipt.ClientId,
+		receipt.CreatedAt.Unix(),
+		toNullUnix(receipt.ExpiresAt),
+	).Scan(&rootHashID)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return 0, fmt.Errorf("failed to execute statement: %w", err)
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(
-		receiptId.String(),
-		rootHash,
+	logger.Logger.Debug(
+		"added root hash to the database",
+		zap.String("root_hash", rootHash),
+		zap.String("client_id", receipt.ClientId),
+	)
+
+	return rootHashID, nil
+}
+
+// addLevels saves the nodes of a Merkle tree, level by level
+func addLevels(tx *Tx, rootHashID int64, levels [][]string) error {
+	statement, err := tx.Prepare(
+		"INSERT INTO TREE_NODES (root_hash_id, level, position, hash) VALUES (?, ?, ?, ?)",
 	)
 	if err != nil {
-		return fmt.Errorf("failed to execute statement: %w", err)
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+
+	for l, level := range levels {
+		for i, h := range level {
+			if _, err = statement.Exec(rootHashID, l, i, h); err != nil {
+				return fmt.Errorf("failed to execute statement: %w", err)
+			}
+		}
 	}
 
 	logger.Logger.Debug(
-		"added root hash to the database",
-		zap.String("root_hash", rootHash),
+		"added tree to the database",
+		zap.Int64("root_hash_id", rootHashID),
+		zap.Int("levels", len(levels)),
 	)
 
 	return nil
 }
 
-// addFile saves a filename and the corresponding file hash
-// in the database
-func (db *Database) addFile(rootHash, filename, selfHash string) error {
-	// get the root hash ID corresponding to the root hash
-	var rootHashID int
-	query := "SELECT root_hash_id FROM RECEIPTS WHERE root_hash = ?"
-	err := db.QueryRow(query, rootHash).Scan(&rootHashID)
+// leafIndexes returns the position of each leaf of a tree (identical
+// files share the first position of their hash, which leads to the
+// root like the others)
+func leafIndexes(levels [][]string) map[string]int {
+	indexes := make(map[string]int)
+	if len(levels) == 0 {
+		return indexes
+	}
+
+	for i, h := range levels[0] {
+		if _, ok := indexes[h]; !ok {
+			indexes[h] = i
+		}
+	}
+
+	return indexes
+}
+
+// IndexTree saves the levels of a tree that was stored as (self, parent,
+// sibling) rows in TREES by a previous version of the server, and
+// deletes these rows
+func (db *Database) IndexTree(receiptId string, levels [][]string) error {
+	defer metrics.ObserveQuery("index_tree", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rootHashID int64
+	query := "SELECT root_hash_id FROM RECEIPTS WHERE receipt_id = ?"
+	err = tx.QueryRow(query, receiptId).Scan(&rootHashID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("root_hash %s not found", rootHash)
+			return fmt.Errorf("receipt_id %s not found", receiptId)
 		}
 		return err
 	}
 
-	// insert the filename and its hash
-	insertQuery := "INSERT INTO FILES (root_hash_id, filename, self_hash) VALUES (?, ?, ?)"
-	_, err = db.Exec(insertQuery, rootHashID, filename, selfHash)
-	if err != nil {
+	if err = addLevels(tx, rootHashID, levels); err != nil {
 		return err
 	}
 
+	statement, err := tx.Prepare(
+		"UPDATE FILES SET leaf_index = ? WHERE root_hash_id = ? AND self_hash = ?",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer statement.Close()
+
+	for h, i := range leafIndexes(levels) {
+		if _, err = statement.Exec(i, rootHashID, h); err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM TREES WHERE root_hash_id = ?", rootHashID)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return nil
 }
 
-// addSubtree adds a subtree (self, sibling, parent) into the database
-func (db *Database) addSubtree(
-	rootHash, self, parent, sibling string,
-	siblingType proofs.SiblingType,
-) error {
-	// get the root hash ID corresponding to the root hash
-	var rootHashID int
-	query := "SELECT root_hash_id FROM RECEIPTS WHERE root_hash = ?"
-	err := db.QueryRow(query, rootHash).Scan(&rootHashID)
+// DeleteReceipt deletes a receipt, its files and its Merkle tree
+// from the database, unless the receipt is under legal hold
+func (db *Database) DeleteReceipt(receiptId string, now time.Time) error {
+	defer metrics.ObserveQuery("delete_receipt", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		rootHashID     int
+		legalHoldUntil sql.NullInt64
+	)
+	query := "SELECT root_hash_id, legal_hold_until FROM RECEIPTS WHERE receipt_id = ?"
+	err = tx.QueryRow(query, receiptId).Scan(&rootHashID, &legalHoldUntil)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("root_hash %s not found", rootHash)
+			return fmt.Errorf("%w: %s", ErrReceiptNotFound, receiptId)
 		}
 		return err
 	}
 
-	// insert the subtree
-	query = `
-		INSERT INTO TREES (root_hash_id, self_hash, parent_hash, sibling_hash, sibling_type) 
-		VALUES (?, ?, ?, ?, ?)
-		`
+	if legalHoldUntil.Valid && legalHoldUntil.Int64 > now.Unix() {
+		return ErrReceiptOnHold
+	}
 
-	statement, err := db.Prepare(query)
+	for _, query := range []string{
+		"DELETE FROM TREES WHERE root_hash_id = ?",
+		"DELETE FROM TREE_NODES WHERE root_hash_id = ?",
+		"DELETE FROM FILES WHERE root_hash_id = ?",
+		"DELETE FROM RECEIPTS WHERE root_hash_id = ?",
+	} {
+		if _, err = tx.Exec(query, rootHashID); err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM CORRUPTED_FILES WHERE receipt_id = ?", receiptId)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
+		return fmt.Errorf("failed to execute statement: %w", err)
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(
-		rootHashID,
-		self,
-		parent,
-		sibling,
-		siblingType.String(),
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.Logger.Debug(
+		"deleted receipt from the database",
+		zap.String("receipt_id", receiptId),
+	)
+
+	return nil
+}
+
+// AssignReceipt assigns a receipt of an anonymous client to a client
+func (db *Database) AssignReceipt(receiptId, clientId string) error {
+	defer metrics.ObserveQuery("assign_receipt", time.Now())
+
+	result, err := db.Exec(
+		"UPDATE RECEIPTS SET client_id = ? WHERE receipt_id = ? AND client_id = ''",
+		clientId,
+		receiptId,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to execute statement: %w", err)
 	}
 
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("receipt_id %s not found or already assigned to a client", receiptId)
+	}
+
 	logger.Logger.Debug(
-		"added path to the database",
-		zap.String("root hash", rootHash),
-		zap.String("self", self),
-		zap.String("parent", parent),
-		zap.String("sibling", sibling),
-		zap.String("siblingType", siblingType.String()),
+		"assigned receipt to a client",
+		zap.String("receipt_id", receiptId),
+		zap.String("client_id", clientId),
+	)
+
+	return nil
+}
+
+// SetLegalHold sets (or, if heldUntil is nil, releases) the legal hold
+// of a receipt and records the change in the audit trail
+func (db *Database) SetLegalHold(event common.HoldEvent) error {
+	defer metrics.ObserveQuery("set_legal_hold", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE RECEIPTS SET legal_hold_until = ? WHERE receipt_id = ?",
+		toNullUnix(event.HeldUntil),
+		event.ReceiptId,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %s", ErrReceiptNotFound, event.ReceiptId)
+	}
+
+	query := `
+		INSERT INTO HOLDS_AUDIT (receipt_id, action, held_until, reason, actor, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`
+
+	_, err = tx.Exec(
+		query,
+		event.ReceiptId,
+		string(event.Action),
+		toNullUnix(event.HeldUntil),
+		event.Reason,
+		event.Actor,
+		event.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.Logger.Info(
+		"legal hold changed",
+		zap.String("receipt_id", event.ReceiptId),
+		zap.String("action", string(event.Action)),
+		zap.String("actor", event.Actor),
+	)
+
+	return nil
+}
+
+// SaveCorruptions replaces the corruptions recorded for a receipt with
+// the ones detected by the last scrub (keeping the date at which each
+// corruption was first detected)
+func (db *Database) SaveCorruptions(receiptId string, corruptions []common.Corruption) error {
+	defer metrics.ObserveQuery("save_corruptions", time.Now())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	firstDetectedAt := make(map[string]int64)
+
+	rows, err := tx.Query(
+		"SELECT filename, first_detected_at FROM CORRUPTED_FILES WHERE receipt_id = ?",
+		receiptId,
+	)
+	if err != nil {
+		return fmt.Errorf("error executing query: %v", err)
+	}
+
+	for rows.Next() {
+		var (
+			filename string
+			t        int64
+		)
+		if err := rows.Scan(&filename, &t); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning row: %v", err)
+		}
+		firstDetectedAt[filename] = t
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM CORRUPTED_FILES WHERE receipt_id = ?", receiptId)
+	if err != nil {
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+
+	query := `
+		INSERT INTO CORRUPTED_FILES (receipt_id, filename, reason, first_detected_at, last_detected_at)
+		VALUES (?, ?, ?, ?, ?)
+		`
+
+	for _, c := range corruptions {
+		first, ok := firstDetectedAt[c.Filename]
+		if !ok {
+			first = c.LastDetectedAt.Unix()
+		}
+
+		_, err = tx.Exec(query, receiptId, c.Filename, c.Reason, first, c.LastDetectedAt.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to execute statement: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	return nil
 }
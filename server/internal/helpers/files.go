@@ -1,21 +1,56 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
 
+	"github.com/glethuillier/fvs/server/internal/common"
 	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/glethuillier/fvs/server/internal/proofs"
 	"go.uber.org/zap"
 )
 
-// filesDir is the directory where files uploaded by the client
-// are stored and retrieved
-const filesDir = "downloads"
+// filesDirs are the directories where files uploaded by the client
+// are stored (one replica per directory) and retrieved; the first
+// directory is the primary replica
+var filesDirs = []string{"downloads"}
 
-func Init() error {
-	return ensureDirectory(filesDir)
+// writeOnce forbids overwriting files that have already been stored
+var writeOnce bool
+
+var (
+	// ErrFileExists is returned when, in write-once mode, a file would
+	// overwrite an existing one
+	ErrFileExists = errors.New("file already exists (write-once mode)")
+
+	// ErrFileMissing is returned when no replica of a file can be read
+	ErrFileMissing = errors.New("file not found")
+
+	// ErrFileCorrupted is returned when no replica of a file matches
+	// its expected hash
+	ErrFileCorrupted = errors.New("no healthy replica of the file")
+)
+
+// Init sets the storage directories (at least one) and the write-once mode
+func Init(dirs []string, isWriteOnce bool) error {
+	if len(dirs) == 0 {
+		return fmt.Errorf("at least one storage directory is required")
+	}
+
+	for _, dir := range dirs {
+		if err := ensureDirectory(dir); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	filesDirs = dirs
+	writeOnce = isWriteOnce
+
+	return nil
 }
 
 // ensureDirectory ensures that the directory exists and is writable
@@ -48,29 +83,242 @@ func ensureDirectory(dir string) error {
 	return nil
 }
 
-func SaveFile(id string, filename string, content []byte) {
+// StorageId returns the ID under which the files of a batch of a client
+// are stored: each client (tenant) has its own directory, while the files
+// of anonymous clients are stored at the top level
+func StorageId(clientId, rootHash string) string {
+	if clientId == "" {
+		return rootHash
+	}
+
+	return filepath.Join("tenants", clientId, rootHash)
+}
+
+// SaveFile stores a file in every replica
+func SaveFile(id string, filename string, content []byte) error {
+	for _, filesDir := range filesDirs {
+		if err := saveReplica(filesDir, id, filename, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func saveReplica(filesDir, id, filename string, content []byte) error {
 	dir := filepath.Join(filesDir, id)
 	err := ensureDirectory(dir)
 	if err != nil {
-		logger.Logger.Fatal("directory cannot be accessed", zap.Error(err))
-		return
+		return fmt.Errorf("directory cannot be accessed: %w", err)
 	}
 
 	f := filepath.Join(dir, filename)
 	logger.Logger.Debug("saving file", zap.String("filepath", f))
 
-	err = os.WriteFile(f, content, 0644)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if writeOnce {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+
+	file, err := os.OpenFile(f, flags, 0644)
 	if err != nil {
-		logger.Logger.Fatal("cannot write file", zap.Error(err))
-		return
+		if errors.Is(err, os.ErrExist) {
+			return ErrFileExists
+		}
+		return fmt.Errorf("cannot write file: %w", err)
+	}
+
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write file: %w", err)
+	}
+
+	return file.Close()
+}
+
+// GetFile returns the contents of a file, reading the replicas in order
+// until one matches the expected hash; damaged replicas are repaired from
+// the healthy one. If no replica is healthy, the contents of the primary
+// replica (if readable) are returned alongside ErrFileCorrupted.
+func GetFile(id string, filename string, expectedHash string) ([]byte, error) {
+	var (
+		damaged  []string
+		fallback []byte
+	)
+
+	for _, filesDir := range filesDirs {
+		data, healthy := readReplica(filesDir, id, filename, expectedHash)
+		if !healthy {
+			if fallback == nil {
+				fallback = data
+			}
+			damaged = append(damaged, filesDir)
+			continue
+		}
+
+		repairReplicas(damaged, id, filename, data)
+		return data, nil
+	}
+
+	if fallback == nil {
+		return nil, ErrFileMissing
+	}
+
+	return fallback, ErrFileCorrupted
+}
+
+// CheckFile verifies every replica of a file against its expected hash
+// and repairs the damaged ones; it returns the number of repaired replicas
+func CheckFile(id string, filename string, expectedHash string) (int, error) {
+	var (
+		damaged  []string
+		healthy  []byte
+		readable bool
+	)
+
+	for _, filesDir := range filesDirs {
+		data, ok := readReplica(filesDir, id, filename, expectedHash)
+		if !ok {
+			readable = readable || data != nil
+			damaged = append(damaged, filesDir)
+			continue
+		}
+
+		readable = true
+		healthy = data
+	}
+
+	if healthy == nil {
+		if readable {
+			return 0, ErrFileCorrupted
+		}
+		return 0, ErrFileMissing
 	}
+
+	return repairReplicas(damaged, id, filename, healthy), nil
 }
 
-func GetFile(id string, filename string) ([]byte, error) {
+// readReplica reads a replica of a file and returns whether it matches
+// the expected hash (contents are nil if the replica cannot be read)
+func readReplica(filesDir, id, filename, expectedHash string) ([]byte, bool) {
 	data, err := os.ReadFile(filepath.Join(filesDir, id, filename))
 	if err != nil {
-		return nil, err
+		return nil, false
+	}
+
+	h, err := proofs.HashFile(&common.File{Contents: data})
+	if err != nil {
+		return data, false
+	}
+
+	return data, h == expectedHash
+}
+
+// repairReplicas rewrites the damaged replicas of a file with healthy
+// contents and returns the number of replicas that have been repaired
+func repairReplicas(filesDirs []string, id, filename string, contents []byte) int {
+	var repaired int
+
+	for _, filesDir := range filesDirs {
+		if err := repairReplica(filesDir, id, filename, contents); err != nil {
+			logger.Logger.Error(
+				"replica cannot be repaired",
+				zap.String("directory", filesDir),
+				zap.String("id", id),
+				zap.String("filename", filename),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		logger.Logger.Warn(
+			"replica repaired",
+			zap.String("directory", filesDir),
+			zap.String("id", id),
+			zap.String("filename", filename),
+		)
+
+		metrics.ReplicasRepaired.Inc()
+		repaired++
+	}
+
+	return repaired
+}
+
+// repairReplica atomically replaces a replica with healthy contents
+// (this is allowed in write-once mode, as the contents are restored
+// to what was originally written)
+func repairReplica(filesDir, id, filename string, contents []byte) error {
+	dir := filepath.Join(filesDir, id)
+	if err := ensureDirectory(dir); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".repair-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
 	}
 
-	return data, nil
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, filename))
+}
+
+// DeleteFiles deletes all the files stored for a given ID
+// from every replica
+func DeleteFiles(id string) error {
+	for _, filesDir := range filesDirs {
+		if err := os.RemoveAll(filepath.Join(filesDir, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MoveFiles moves the files stored for an ID to another ID, in every
+// replica (the replicas without the files are skipped); the files are
+// not moved if the other ID already has files
+func MoveFiles(from, to string) error {
+	for _, filesDir := range filesDirs {
+		if _, err := os.Stat(filepath.Join(filesDir, to)); err == nil {
+			return fmt.Errorf("%s: files already stored for %s", filesDir, to)
+		}
+	}
+
+	var moved []string
+	for _, filesDir := range filesDirs {
+		src, dst := filepath.Join(filesDir, from), filepath.Join(filesDir, to)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+		if err == nil {
+			err = os.Rename(src, dst)
+		}
+		if err != nil {
+			// the replicas already moved are moved back
+			for _, dir := range moved {
+				os.Rename(filepath.Join(dir, to), filepath.Join(dir, from))
+			}
+			return fmt.Errorf("%s: %w", filesDir, err)
+		}
+
+		moved = append(moved, filesDir)
+	}
+
+	return nil
 }
@@ -0,0 +1,112 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicas(t *testing.T) {
+	logger.Init("")
+
+	var (
+		id       = "root"
+		filename = "file.txt"
+		contents = []byte("You actually read it!")
+	)
+
+	expectedHash, err := proofs.HashFile(&common.File{Contents: contents})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		damage           func(dirs []string)
+		expectedContents []byte
+		expectedError    error
+	}{
+		{
+			name:             "Positive test - healthy replicas",
+			damage:           func(dirs []string) {},
+			expectedContents: contents,
+		},
+		{
+			name: "Positive test - corrupted primary replica",
+			damage: func(dirs []string) {
+				os.WriteFile(filepath.Join(dirs[0], id, filename), []byte("corrupted"), 0644)
+			},
+			expectedContents: contents,
+		},
+		{
+			name: "Positive test - missing replicas",
+			damage: func(dirs []string) {
+				os.Remove(filepath.Join(dirs[0], id, filename))
+				os.RemoveAll(filepath.Join(dirs[2], id))
+			},
+			expectedContents: contents,
+		},
+		{
+			name: "Negative test - no healthy replica",
+			damage: func(dirs []string) {
+				for _, dir := range dirs {
+					os.WriteFile(filepath.Join(dir, id, filename), []byte("corrupted"), 0644)
+				}
+			},
+			expectedContents: []byte("corrupted"),
+			expectedError:    ErrFileCorrupted,
+		},
+		{
+			name: "Negative test - no replica",
+			damage: func(dirs []string) {
+				for _, dir := range dirs {
+					os.RemoveAll(filepath.Join(dir, id))
+				}
+			},
+			expectedError: ErrFileMissing,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			base := t.TempDir()
+			dirs := []string{
+				filepath.Join(base, "a"),
+				filepath.Join(base, "b"),
+				filepath.Join(base, "c"),
+			}
+
+			assert.NoError(t, Init(dirs, false))
+			assert.NoError(t, SaveFile(id, filename, contents))
+
+			tc.damage(dirs)
+
+			data, err := GetFile(id, filename, expectedHash)
+			assert.ErrorIs(t, err, tc.expectedError)
+			assert.Equal(t, tc.expectedContents, data)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			// the replicas not read by GetFile are repaired by CheckFile
+			_, err = CheckFile(id, filename, expectedHash)
+			assert.NoError(t, err)
+
+			repaired, err := CheckFile(id, filename, expectedHash)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, repaired)
+		})
+	}
+}
+
+func TestWriteOnce(t *testing.T) {
+	logger.Init("")
+
+	assert.NoError(t, Init([]string{t.TempDir()}, true))
+	assert.NoError(t, SaveFile("root", "file.txt", []byte("a")))
+	assert.ErrorIs(t, SaveFile("root", "file.txt", []byte("b")), ErrFileExists)
+}
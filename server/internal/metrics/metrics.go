@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mps_server"
+
+var (
+	// uploads
+
+	Uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Number of batches of files received, by result.",
+	}, []string{"result"})
+
+	UploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes of the batches of files accepted.",
+	})
+
+	UploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time between the preflight and the acknowledgment of a batch.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	// downloads
+
+	Downloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Number of download requests, by result.",
+	}, []string{"result"})
+
+	DownloadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Number of bytes of the files sent to the clients.",
+	})
+
+	DownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Time to process a download request.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// proofs
+
+	MerkleBuildDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "merkle_build_duration_seconds",
+		Help:      "Time to build the Merkle tree of a batch.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	ProofGenerationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proof_generation_duration_seconds",
+		Help:      "Time to load a Merkle tree and generate the proof of a file.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// cache
+
+	CacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Number of lookups served by the cache, by kind of entry.",
+	}, []string{"kind"})
+
+	CacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Number of lookups not served by the cache, by kind of entry.",
+	}, []string{"kind"})
+
+	CacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Number of entries evicted from the cache to stay within its size.",
+	})
+
+	CacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size_bytes",
+		Help:      "Estimated memory used by the entries of the cache.",
+	})
+
+	// connections
+
+	ActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_connections",
+		Help:      "Number of clients connected to the server.",
+	})
+
+	RejectedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_connections_total",
+		Help:      "Number of connections rejected because the client is not authenticated.",
+	})
+
+	FlowControlPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flow_control_pauses_total",
+		Help:      "Number of times a client was asked to pause because its requests queue was saturated.",
+	})
+
+	InvalidMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_messages_total",
+		Help:      "Number of invalid messages received from the clients, by reason.",
+	}, []string{"reason"})
+
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Number of requests refused because the client exceeded a quota, by limit.",
+	}, []string{"limit"})
+
+	ActiveUploadSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_upload_sessions",
+		Help:      "Number of batches of files being received.",
+	})
+
+	// storage
+
+	ReplicasRepaired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replicas_repaired_total",
+		Help:      "Number of damaged replicas repaired.",
+	})
+
+	CorruptedFiles = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "corrupted_files",
+		Help:      "Number of corruptions detected by the last scrub.",
+	})
+
+	ExpiredReceipts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_receipts_total",
+		Help:      "Number of receipts deleted by the retention job, by reason.",
+	}, []string{"reason"})
+
+	// database
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time to run database operations, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"operation"})
+)
+
+// ObserveQuery records the duration of a database operation started at
+// a given time (to be deferred)
+func ObserveQuery(operation string, start time.Time) {
+	QueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the HTTP handler exposing the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
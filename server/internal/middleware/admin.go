@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ListReceipts returns the receipts matching a filter, from the oldest
+// to the most recent, and the total number of matching receipts
+func (s *Service) ListReceipts(filter database.ReceiptFilter) ([]common.Receipt, int, error) {
+	return s.db.SearchReceipts(filter)
+}
+
+// GetFiles returns the files of a receipt
+func (s *Service) GetFiles(receiptId string) ([]common.FileInfo, error) {
+	if _, err := s.db.GetReceipt(receiptId); err != nil {
+		return nil, err
+	}
+
+	return s.db.GetFiles(receiptId)
+}
+
+// GetProof returns the root hash of a receipt and the proof
+// corresponding to one of its files
+func (s *Service) GetProof(receiptId, filename string) (string, []libproofs.ProofPart, error) {
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, proof, err := s.getFileProof(receiptId, filename)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return receipt.RootHash, proof, nil
+}
+
+// DeleteReceipt deletes a receipt and its files (unless the
+// receipt is protected by a legal hold or the WORM mode)
+func (s *Service) DeleteReceipt(receiptId, actor string) error {
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.deleteReceipt(*receipt); err != nil {
+		return fmt.Errorf("receipt cannot be deleted: %w", err)
+	}
+
+	logger.Logger.Info(
+		"receipt deleted",
+		zap.String("receipt_id", receiptId),
+		zap.String("actor", actor),
+	)
+
+	s.audit(common.AuditEvent{
+		Action:    common.AuditReceiptDeleted,
+		Actor:     actor,
+		ClientId:  receipt.ClientId,
+		ReceiptId: receiptId,
+		Details:   map[string]string{"root_hash": receipt.RootHash},
+	})
+
+	return nil
+}
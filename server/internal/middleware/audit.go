@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// actors of the operations not requested by an administrator
+const (
+	clientActor    = "client"
+	retentionActor = "retention"
+)
+
+// recordAudit appends an event to the audit log; the operation has
+// already been performed, so a failure is only logged
+func recordAudit(db *database.Database, event common.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	if err := db.AppendAuditEvent(&event); err != nil {
+		logger.Logger.Error(
+			"the event cannot be recorded in the audit log",
+			zap.String("action", string(event.Action)),
+			zap.String("actor", event.Actor),
+			zap.String("receipt_id", event.ReceiptId),
+			zap.Error(err),
+		)
+	}
+}
+
+func (s *Service) audit(event common.AuditEvent) {
+	recordAudit(s.db, event)
+}
+
+// ListAuditEvents returns the events of the audit log matching a filter
+func (s *Service) ListAuditEvents(filter database.AuditFilter) ([]common.AuditEvent, error) {
+	events, err := s.db.ListAuditEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if events == nil {
+		events = []common.AuditEvent{}
+	}
+
+	return events, nil
+}
+
+// VerifyAuditLog checks that the hash chain of the audit log has not
+// been tampered with
+func (s *Service) VerifyAuditLog() (*common.AuditVerification, error) {
+	return s.db.VerifyAuditLog()
+}
@@ -0,0 +1,400 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// version of the format of the receipt bundles
+const bundleVersion = 1
+
+// entries of a bundle: the manifest, its signature (if the bundle is
+// signed) and the files of the receipt
+const (
+	bundleManifest    = "manifest.json"
+	bundleSignature   = "manifest.sig"
+	bundleFilesPrefix = "files/"
+)
+
+var (
+	// ErrInvalidBundle is returned when a bundle cannot be imported
+	// because it is malformed or inconsistent
+	ErrInvalidBundle = errors.New("invalid bundle")
+
+	// ErrInvalidSignature is returned when the signature of a bundle
+	// does not match the signing key of the server
+	ErrInvalidSignature = errors.New("invalid bundle signature")
+)
+
+// BundleManifest describes a receipt exported by a server: the receipt,
+// its files (FILES rows) and its Merkle tree (TREE_NODES rows, level
+// by level)
+type BundleManifest struct {
+	Version       int               `json:"version"`
+	HashAlgorithm string            `json:"hash_algorithm"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	Receipt       common.Receipt    `json:"receipt"`
+	Files         []common.FileInfo `json:"files"`
+	Levels        [][]string        `json:"levels"`
+}
+
+// ExportReceipt writes a receipt as a self-describing bundle (a gzipped
+// tar archive); the files are read from a healthy replica, and the
+// manifest is signed if a signing key is set
+func (s *Service) ExportReceipt(receiptId string, w io.Writer) (*BundleManifest, error) {
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := s.db.GetTree(receiptId)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.db.GetFiles(receiptId)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BundleManifest{
+		Version:       bundleVersion,
+		HashAlgorithm: "sha512",
+		ExportedAt:    time.Now().UTC(),
+		Receipt:       *receipt,
+		Files:         files,
+		Levels:        tree.Levels,
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	if err = writeBundleEntry(archive, bundleManifest, data); err != nil {
+		return nil, err
+	}
+
+	if len(s.bundleKey) > 0 {
+		signature := []byte(hex.EncodeToString(signManifest(s.bundleKey, data)))
+		if err = writeBundleEntry(archive, bundleSignature, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	storageId := helpers.StorageId(receipt.ClientId, receipt.RootHash)
+	for _, f := range files {
+		contents, err := helpers.GetFile(storageId, f.Filename, f.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("file %s cannot be exported: %w", f.Filename, err)
+		}
+
+		if err = writeBundleEntry(archive, bundleFilesPrefix+f.Filename, contents); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func writeBundleEntry(archive *tar.Writer, name string, data []byte) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = archive.Write(data)
+	return err
+}
+
+// signManifest returns the HMAC-SHA256 of a manifest
+func signManifest(key, manifest []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(manifest)
+	return mac.Sum(nil)
+}
+
+// ImportReceipt imports a bundle exported by ExportReceipt: the Merkle
+// tree is recomputed from the files of the bundle and must match the
+// root hash and the tree of the manifest before anything is stored.
+// The receipt keeps its ID, client, timestamps and legal hold.
+func (s *Service) ImportReceipt(r io.Reader, actor string) (*common.Receipt, error) {
+	manifestData, signature, contents, err := readBundle(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.bundleKey) > 0 {
+		digest, err := hex.DecodeString(string(signature))
+		if err != nil || !hmac.Equal(digest, signManifest(s.bundleKey, manifestData)) {
+			return nil, ErrInvalidSignature
+		}
+	} else if signature != nil {
+		logger.Logger.Warn("no signing key: the signature of the bundle is not verified")
+	}
+
+	var manifest BundleManifest
+	if err = json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBundle, bundleManifest, err)
+	}
+
+	files, err := checkBundle(&manifest, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := manifest.Receipt
+
+	// the tree is rebuilt from the files, as when they are uploaded
+	tree, err := proofs.BuildMerkleTree(files)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.RootHash != receipt.RootHash {
+		return nil, fmt.Errorf(
+			"%w: the files lead to the root hash %s instead of %s",
+			ErrInvalidBundle, tree.RootHash, receipt.RootHash,
+		)
+	}
+
+	for _, f := range manifest.Files {
+		if tree.FilenameToHash[f.Filename] != f.Hash || tree.FilenameToSize[f.Filename] != f.Size {
+			return nil, fmt.Errorf("%w: file %s does not match the manifest", ErrInvalidBundle, f.Filename)
+		}
+	}
+
+	if !slices.EqualFunc(tree.Levels, manifest.Levels, slices.Equal[[]string]) {
+		return nil, fmt.Errorf("%w: the tree does not match the manifest", ErrInvalidBundle)
+	}
+
+	present, err := s.db.IsReceiptPresent(receipt.ReceiptId)
+	if err != nil {
+		return nil, err
+	}
+	if present {
+		return nil, fmt.Errorf("receipt_id %s already exists", receipt.ReceiptId)
+	}
+
+	present, knownReceiptId, err := s.db.IsTreeAlreadyPresent(receipt.ClientId, receipt.RootHash)
+	if present {
+		return nil, fmt.Errorf("these files have already been stored; receipt ID: %s", knownReceiptId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	storageId := helpers.StorageId(receipt.ClientId, receipt.RootHash)
+	for _, f := range files {
+		if err = helpers.SaveFile(storageId, f.Filename, f.Contents); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = s.db.SaveTree(&common.Receipt{
+			ReceiptId: receipt.ReceiptId,
+			ClientId:  receipt.ClientId,
+			CreatedAt: receipt.CreatedAt,
+			ExpiresAt: receipt.ExpiresAt,
+		}, tree)
+	}
+
+	if err != nil {
+		if err := helpers.DeleteFiles(storageId); err != nil {
+			logger.Logger.Error(
+				"the files of the bundle cannot be deleted",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.Error(err),
+			)
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if receipt.LegalHoldUntil != nil && receipt.LegalHoldUntil.After(now) {
+		err = s.db.SetLegalHold(common.HoldEvent{
+			ReceiptId: receipt.ReceiptId,
+			Action:    common.HoldSet,
+			HeldUntil: receipt.LegalHoldUntil,
+			Reason:    "imported from a bundle",
+			Actor:     actor,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("the legal hold cannot be restored: %w", err)
+		}
+	}
+
+	logger.Logger.Info(
+		"receipt imported",
+		zap.String("receipt_id", receipt.ReceiptId),
+		zap.String("client_id", receipt.ClientId),
+		zap.Int("files", len(files)),
+		zap.String("actor", actor),
+	)
+
+	s.audit(common.AuditEvent{
+		Action:    common.AuditReceiptImported,
+		Actor:     actor,
+		ClientId:  receipt.ClientId,
+		ReceiptId: receipt.ReceiptId,
+		Details: map[string]string{
+			"root_hash": receipt.RootHash,
+			"files":     strconv.Itoa(len(files)),
+		},
+	})
+
+	return s.db.GetReceipt(receipt.ReceiptId)
+}
+
+// readBundle returns the manifest, the signature (nil if the bundle is
+// not signed) and the files of a bundle, by filename
+func readBundle(r io.Reader) ([]byte, []byte, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer gz.Close()
+
+	var (
+		archive   = tar.NewReader(gz)
+		manifest  []byte
+		signature []byte
+		contents  = make(map[string][]byte)
+	)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, header.Name)
+		}
+
+		var b bytes.Buffer
+		if _, err = io.Copy(&b, archive); err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		filename, isFile := strings.CutPrefix(header.Name, bundleFilesPrefix)
+
+		switch {
+		case header.Name == bundleManifest && manifest == nil:
+			manifest = b.Bytes()
+		case header.Name == bundleSignature && signature == nil:
+			signature = b.Bytes()
+		case isFile && contents[filename] == nil:
+			contents[filename] = b.Bytes()
+		default:
+			return nil, nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, header.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s is missing", ErrInvalidBundle, bundleManifest)
+	}
+
+	return manifest, signature, contents, nil
+}
+
+// checkBundle checks that a manifest can be imported and that the
+// bundle holds exactly its files, and returns these files
+func checkBundle(manifest *BundleManifest, contents map[string][]byte) ([]*common.File, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidBundle, fmt.Sprintf(format, args...))
+	}
+
+	if manifest.Version != bundleVersion {
+		return nil, invalid("unsupported version %d", manifest.Version)
+	}
+
+	if manifest.HashAlgorithm != "sha512" {
+		return nil, invalid("unsupported hash algorithm %q", manifest.HashAlgorithm)
+	}
+
+	receipt := manifest.Receipt
+
+	if _, err := uuid.Parse(receipt.ReceiptId); err != nil {
+		return nil, invalid("invalid receipt ID %q", receipt.ReceiptId)
+	}
+
+	if receipt.ClientId != "" && !common.ClientIdPattern.MatchString(receipt.ClientId) {
+		return nil, invalid("invalid client ID %q", receipt.ClientId)
+	}
+
+	if receipt.CreatedAt.IsZero() {
+		return nil, invalid("the creation date of the receipt is missing")
+	}
+
+	if len(manifest.Files) == 0 {
+		return nil, invalid("no file")
+	}
+
+	if len(manifest.Files) != len(contents) {
+		return nil, invalid("%d files listed, %d files found", len(manifest.Files), len(contents))
+	}
+
+	var files []*common.File
+	for _, f := range manifest.Files {
+		// the files are stored under their name
+		if f.Filename == "" || f.Filename == "." || f.Filename == ".." ||
+			strings.ContainsAny(f.Filename, `/\`) {
+			return nil, invalid("invalid filename %q", f.Filename)
+		}
+
+		data, ok := contents[f.Filename]
+		if !ok {
+			return nil, invalid("file %s is missing", f.Filename)
+		}
+		// (each file is consumed once, to detect duplicates)
+		delete(contents, f.Filename)
+
+		files = append(files, &common.File{
+			Filename: f.Filename,
+			Contents: data,
+		})
+	}
+
+	return files, nil
+}
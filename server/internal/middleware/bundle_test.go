@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestService returns a service backed by a new SQLite database and
+// a new storage directory (the storage is global: it is switched to the
+// directory of the last service created)
+func newTestService(t *testing.T, signingKey string) *Service {
+	dir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.Database.URL = filepath.Join(dir, "proofs.db")
+	cfg.Storage.Dirs = []string{filepath.Join(dir, "downloads")}
+	cfg.Bundles.SigningKey = signingKey
+
+	s, err := GetService(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// rewriteBundle rewrites a bundle, changing the contents of one of
+// its entries
+func rewriteBundle(t *testing.T, bundle []byte, name string, contents []byte) []byte {
+	manifest, signature, files, err := readBundle(bytes.NewReader(bundle))
+	assert.NoError(t, err)
+
+	entries := map[string][]byte{bundleManifest: manifest}
+	if signature != nil {
+		entries[bundleSignature] = signature
+	}
+	for filename, data := range files {
+		entries[bundleFilesPrefix+filename] = data
+	}
+	entries[name] = contents
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	archive := tar.NewWriter(gz)
+	for entry, data := range entries {
+		assert.NoError(t, writeBundleEntry(archive, entry, data))
+	}
+	assert.NoError(t, archive.Close())
+	assert.NoError(t, gz.Close())
+
+	return b.Bytes()
+}
+
+func TestExportImportReceipt(t *testing.T) {
+	logger.Init("")
+
+	files := []*common.File{
+		{Filename: "a.txt", Contents: []byte("a")},
+		{Filename: "b.txt", Contents: []byte("bb")},
+		{Filename: "c.txt", Contents: []byte("ccc")},
+	}
+
+	tree, err := proofs.BuildMerkleTree(files)
+	assert.NoError(t, err)
+
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	heldUntil := createdAt.Add(365 * 24 * time.Hour)
+	receipt := &common.Receipt{
+		ReceiptId: "0aa9a4bc-7554-4d6b-bebb-77b23dfc321b",
+		ClientId:  "alice",
+		CreatedAt: createdAt,
+	}
+
+	src := newTestService(t, "s3cr3t")
+	for _, f := range files {
+		assert.NoError(t, helpers.SaveFile(helpers.StorageId("alice", tree.RootHash), f.Filename, f.Contents))
+	}
+	assert.NoError(t, src.db.SaveTree(receipt, tree))
+	assert.NoError(t, src.db.SetLegalHold(common.HoldEvent{
+		ReceiptId: receipt.ReceiptId,
+		Action:    common.HoldSet,
+		HeldUntil: &heldUntil,
+		Reason:    "litigation",
+		Actor:     "test",
+		CreatedAt: createdAt,
+	}))
+
+	var bundle bytes.Buffer
+	manifest, err := src.ExportReceipt(receipt.ReceiptId, &bundle)
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Files, 3)
+
+	t.Run("Signed with another key", func(t *testing.T) {
+		dst := newTestService(t, "other")
+		_, err := dst.ImportReceipt(bytes.NewReader(bundle.Bytes()), "test")
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("Tampered file", func(t *testing.T) {
+		dst := newTestService(t, "")
+		tampered := rewriteBundle(t, bundle.Bytes(), bundleFilesPrefix+"b.txt", []byte("bB"))
+		_, err := dst.ImportReceipt(bytes.NewReader(tampered), "test")
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Unexpected file", func(t *testing.T) {
+		dst := newTestService(t, "")
+		tampered := rewriteBundle(t, bundle.Bytes(), bundleFilesPrefix+"d.txt", []byte("d"))
+		_, err := dst.ImportReceipt(bytes.NewReader(tampered), "test")
+		assert.ErrorIs(t, err, ErrInvalidBundle)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		dst := newTestService(t, "s3cr3t")
+
+		imported, err := dst.ImportReceipt(bytes.NewReader(bundle.Bytes()), "test")
+		assert.NoError(t, err)
+		assert.Equal(t, receipt.ReceiptId, imported.ReceiptId)
+		assert.Equal(t, tree.RootHash, imported.RootHash)
+		assert.Equal(t, "alice", imported.ClientId)
+		assert.Equal(t, createdAt, imported.CreatedAt)
+		assert.Equal(t, 3, imported.FilesCount)
+		assert.Equal(t, heldUntil, *imported.LegalHoldUntil)
+
+		_, proof, err := dst.GetProof(receipt.ReceiptId, "b.txt")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, proof)
+
+		contents, err := helpers.GetFile(helpers.StorageId("alice", tree.RootHash), "c.txt", tree.FilenameToHash["c.txt"])
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ccc"), contents)
+
+		events, err := dst.ListAuditEvents(database.AuditFilter{ReceiptId: receipt.ReceiptId})
+		assert.NoError(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, common.AuditReceiptImported, events[0].Action)
+			assert.Equal(t, "test", events[0].Actor)
+		}
+
+		// a receipt cannot be imported twice
+		_, err = dst.ImportReceipt(bytes.NewReader(bundle.Bytes()), "test")
+		assert.ErrorContains(t, err, "already exists")
+	})
+}
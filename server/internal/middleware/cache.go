@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"container/list"
+	"sync"
+
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+)
+
+const (
+	// estimated memory used by an entry, besides its contents
+	cacheEntryOverhead = 200
+
+	// estimated memory used by a node of a cached tree (hash, parent,
+	// sibling and map entries)
+	cachedNodeSize = 300
+
+	// a tree is only cached if it takes at most this fraction of the
+	// cache, so that a single receipt cannot evict all the others
+	cachedTreeMaxShare = 8
+)
+
+type cacheKind int
+
+const (
+	// root hash of a receipt, for a client (the name is the client ID)
+	cachedRootHash cacheKind = iota
+	// hash and proof of a file (the name is the filename)
+	cachedProof
+	// whole Merkle tree of a receipt (no name)
+	cachedTree
+)
+
+func (k cacheKind) String() string {
+	switch k {
+	case cachedRootHash:
+		return "root_hash"
+	case cachedProof:
+		return "proof"
+	case cachedTree:
+		return "tree"
+	default:
+		return "unknown"
+	}
+}
+
+type cacheKey struct {
+	kind      cacheKind
+	receiptId string
+	name      string
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value any
+	size  int64
+}
+
+type cachedFileProof struct {
+	fileHash string
+	proof    []libproofs.ProofPart
+}
+
+// lruCache keeps the most recently used receipts data in memory, within
+// a maximum (estimated) size; the least recently used entries are
+// evicted first
+type lruCache struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+
+	// front: most recently used entry
+	order   *list.List
+	entries map[cacheKey]*list.Element
+
+	// keys of the entries of each receipt (to invalidate them)
+	receipts map[string]map[cacheKey]struct{}
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+		receipts: make(map[string]map[cacheKey]struct{}),
+	}
+}
+
+// get returns the value of an entry and marks it as the most recently used
+func (c *lruCache) get(key cacheKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		metrics.CacheMisses.WithLabelValues(key.kind.String()).Inc()
+		return nil, false
+	}
+
+	metrics.CacheHits.WithLabelValues(key.kind.String()).Inc()
+	c.order.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).value, true
+}
+
+// add adds (or replaces) an entry, then evicts the least recently used
+// entries until the cache fits within its maximum size; entries larger
+// than the cache are not added
+func (c *lruCache) add(key cacheKey, value any, size int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if size > c.maxBytes {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.usedBytes += size
+
+	if c.receipts[key.receiptId] == nil {
+		c.receipts[key.receiptId] = make(map[cacheKey]struct{})
+	}
+	c.receipts[key.receiptId][key] = struct{}{}
+
+	for c.usedBytes > c.maxBytes {
+		c.remove(c.order.Back())
+		metrics.CacheEvictions.Inc()
+	}
+
+	metrics.CacheSize.Set(float64(c.usedBytes))
+}
+
+// contains returns whether entries of a given kind are cached for a receipt
+func (c *lruCache) contains(receiptId string, kind cacheKind) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.receipts[receiptId] {
+		if key.kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidate removes the entries of a receipt (of the given kinds, or
+// all of them)
+func (c *lruCache) invalidate(receiptId string, kinds ...cacheKind) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.receipts[receiptId] {
+		if len(kinds) > 0 && !containsKind(kinds, key.kind) {
+			continue
+		}
+
+		c.remove(c.entries[key])
+	}
+
+	metrics.CacheSize.Set(float64(c.usedBytes))
+}
+
+// remove removes an entry (the lock must be held)
+func (c *lruCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.usedBytes -= entry.size
+
+	keys := c.receipts[entry.key.receiptId]
+	delete(keys, entry.key)
+	if len(keys) == 0 {
+		delete(c.receipts, entry.key.receiptId)
+	}
+}
+
+func containsKind(kinds []cacheKind, kind cacheKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// getRootHash returns the root hash corresponding to a receipt of a client
+func (s *Service) getRootHash(clientId, receiptId string) (string, error) {
+	key := cacheKey{kind: cachedRootHash, receiptId: receiptId, name: clientId}
+	if v, ok := s.cache.get(key); ok {
+		return v.(string), nil
+	}
+
+	rootHash, err := s.db.GetRootHash(clientId, receiptId)
+	if err != nil {
+		return "", err
+	}
+
+	size := cacheEntryOverhead + len(receiptId) + len(clientId) + len(rootHash)
+	s.cache.add(key, rootHash, int64(size))
+
+	return rootHash, nil
+}
+
+// getFileProof returns the hash of a file of a receipt and its proof,
+// extracted from the cached tree of the receipt or read from the cache
+// or, failing that, from the database; the tree of a receipt whose files
+// are downloaded one after the other is loaded in the cache if it is
+// small enough
+func (s *Service) getFileProof(receiptId, filename string) (string, []libproofs.ProofPart, error) {
+	if v, ok := s.cache.get(cacheKey{kind: cachedTree, receiptId: receiptId}); ok {
+		tree := v.(*common.Tree)
+
+		fileHash, ok := tree.FilenameToHash[filename]
+		if !ok {
+			return "", nil, database.ErrFileNotFound
+		}
+
+		proof, err := proofs.GenerateTransferableProof(tree, filename)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return fileHash, proof, nil
+	}
+
+	key := cacheKey{kind: cachedProof, receiptId: receiptId, name: filename}
+	if v, ok := s.cache.get(key); ok {
+		p := v.(cachedFileProof)
+		return p.fileHash, p.proof, nil
+	}
+
+	// another file of the receipt has been requested recently
+	loadTree := s.cache.contains(receiptId, cachedProof)
+
+	fileHash, proof, err := s.db.GetFileProof(receiptId, filename)
+	if err != nil {
+		return "", nil, err
+	}
+
+	size := cacheEntryOverhead + len(filename) + len(fileHash)
+	for _, part := range proof {
+		size += len(part.SiblingHash) + 16
+	}
+	s.cache.add(key, cachedFileProof{fileHash: fileHash, proof: proof}, int64(size))
+
+	// the number of nodes of the tree is known from the height of the proof
+	treeSize := int64(cachedNodeSize) << (len(proof) + 1)
+	if loadTree && treeSize <= s.cache.maxBytes/cachedTreeMaxShare {
+		// (if the tree cannot be loaded, the proofs are read from the
+		// database as before)
+		if tree, err := s.db.GetTree(receiptId); err == nil {
+			// the proofs are now extracted from the tree
+			s.cache.invalidate(receiptId, cachedProof)
+			s.cache.add(cacheKey{kind: cachedTree, receiptId: receiptId}, tree, treeSize)
+		}
+	}
+
+	return fileHash, proof, nil
+}
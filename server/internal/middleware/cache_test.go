@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	key := func(kind cacheKind, receiptId, name string) cacheKey {
+		return cacheKey{kind: kind, receiptId: receiptId, name: name}
+	}
+
+	tests := []struct {
+		name         string
+		maxBytes     int64
+		run          func(c *lruCache)
+		expectedKeys []cacheKey
+	}{
+		{
+			name:     "least recently used entry evicted",
+			maxBytes: 300,
+			run: func(c *lruCache) {
+				c.add(key(cachedProof, "r1", "a"), "a", 100)
+				c.add(key(cachedProof, "r1", "b"), "b", 100)
+				c.add(key(cachedProof, "r2", "c"), "c", 100)
+				c.get(key(cachedProof, "r1", "a"))
+				c.add(key(cachedProof, "r2", "d"), "d", 100)
+			},
+			expectedKeys: []cacheKey{
+				key(cachedProof, "r1", "a"),
+				key(cachedProof, "r2", "c"),
+				key(cachedProof, "r2", "d"),
+			},
+		},
+		{
+			name:     "entry larger than the cache not added",
+			maxBytes: 300,
+			run: func(c *lruCache) {
+				c.add(key(cachedProof, "r1", "a"), "a", 100)
+				c.add(key(cachedTree, "r1", ""), "tree", 400)
+			},
+			expectedKeys: []cacheKey{
+				key(cachedProof, "r1", "a"),
+			},
+		},
+		{
+			name:     "entries of a deleted receipt invalidated",
+			maxBytes: 1000,
+			run: func(c *lruCache) {
+				c.add(key(cachedRootHash, "r1", "client-a"), "root", 100)
+				c.add(key(cachedProof, "r1", "a"), "a", 100)
+				c.add(key(cachedProof, "r2", "b"), "b", 100)
+				c.invalidate("r1")
+			},
+			expectedKeys: []cacheKey{
+				key(cachedProof, "r2", "b"),
+			},
+		},
+		{
+			name:     "entries of a kind invalidated",
+			maxBytes: 1000,
+			run: func(c *lruCache) {
+				c.add(key(cachedRootHash, "r1", "client-a"), "root", 100)
+				c.add(key(cachedProof, "r1", "a"), "a", 100)
+				c.invalidate("r1", cachedProof)
+			},
+			expectedKeys: []cacheKey{
+				key(cachedRootHash, "r1", "client-a"),
+			},
+		},
+		{
+			name:     "cache disabled",
+			maxBytes: 0,
+			run: func(c *lruCache) {
+				c.add(key(cachedProof, "r1", "a"), "a", 100)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newLRUCache(tc.maxBytes)
+			tc.run(c)
+
+			var (
+				keys []cacheKey
+				size int64
+			)
+			for k, e := range c.entries {
+				keys = append(keys, k)
+				size += e.Value.(*cacheEntry).size
+			}
+
+			assert.ElementsMatch(t, tc.expectedKeys, keys)
+			assert.Equal(t, size, c.usedBytes)
+			assert.LessOrEqual(t, c.usedBytes, tc.maxBytes)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+)
+
+// WormPolicy defines the write-once-read-many (WORM) mode: when enabled,
+// stored files cannot be overwritten and receipts cannot be deleted
+// until the end of the period (forever if the period is zero)
+type WormPolicy struct {
+	Enabled bool
+	Period  time.Duration
+}
+
+// isProtected returns whether a receipt is under legal hold or
+// protected by the WORM mode at a given time
+func (s *Service) isProtected(receipt common.Receipt, now time.Time) bool {
+	if receipt.LegalHoldUntil != nil && receipt.LegalHoldUntil.After(now) {
+		return true
+	}
+
+	if s.worm.Enabled {
+		return s.worm.Period == 0 || receipt.CreatedAt.Add(s.worm.Period).After(now)
+	}
+
+	return false
+}
+
+// SetLegalHold places a receipt under legal hold for a given duration;
+// an existing hold can be extended but not shortened
+func (s *Service) SetLegalHold(receiptId string, duration time.Duration, reason, actor string) error {
+	if duration <= 0 {
+		return fmt.Errorf("the duration of a legal hold must be positive")
+	}
+
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	heldUntil := now.Add(duration)
+
+	if receipt.LegalHoldUntil != nil && receipt.LegalHoldUntil.After(heldUntil) {
+		return fmt.Errorf(
+			"the receipt is already held until %s; a legal hold cannot be shortened",
+			receipt.LegalHoldUntil.Format(time.RFC3339),
+		)
+	}
+
+	err = s.db.SetLegalHold(common.HoldEvent{
+		ReceiptId: receiptId,
+		Action:    common.HoldSet,
+		HeldUntil: &heldUntil,
+		Reason:    reason,
+		Actor:     actor,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit(common.AuditEvent{
+		Action:    common.AuditHoldSet,
+		Actor:     actor,
+		ClientId:  receipt.ClientId,
+		ReceiptId: receiptId,
+		Details: map[string]string{
+			"held_until": heldUntil.Format(time.RFC3339),
+			"reason":     reason,
+		},
+	})
+
+	return nil
+}
+
+// ReleaseLegalHold releases the legal hold of a receipt (the receipt
+// remains protected if the WORM mode applies to it)
+func (s *Service) ReleaseLegalHold(receiptId, reason, actor string) error {
+	receipt, err := s.db.GetReceipt(receiptId)
+	if err != nil {
+		return err
+	}
+
+	if receipt.LegalHoldUntil == nil {
+		return fmt.Errorf("receipt_id %s is not under legal hold", receiptId)
+	}
+
+	err = s.db.SetLegalHold(common.HoldEvent{
+		ReceiptId: receiptId,
+		Action:    common.HoldRelease,
+		Reason:    reason,
+		Actor:     actor,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit(common.AuditEvent{
+		Action:    common.AuditHoldReleased,
+		Actor:     actor,
+		ClientId:  receipt.ClientId,
+		ReceiptId: receiptId,
+		Details:   map[string]string{"reason": reason},
+	})
+
+	return nil
+}
+
+// GetHoldEvents returns the audit trail of the legal holds of a receipt
+func (s *Service) GetHoldEvents(receiptId string) ([]common.HoldEvent, error) {
+	return s.db.GetHoldEvents(receiptId)
+}
+
+// GetReceipt returns the receipt corresponding to a receipt ID
+func (s *Service) GetReceipt(receiptId string) (*common.Receipt, error) {
+	return s.db.GetReceipt(receiptId)
+}
+
+// deleteReceipt deletes a receipt from the database, then its files,
+// unless the receipt is protected
+func (s *Service) deleteReceipt(receipt common.Receipt) error {
+	now := time.Now().UTC()
+
+	if s.isProtected(receipt, now) {
+		return database.ErrReceiptOnHold
+	}
+
+	if err := s.db.DeleteReceipt(receipt.ReceiptId, now); err != nil {
+		return err
+	}
+	s.cache.invalidate(receipt.ReceiptId)
+
+	return helpers.DeleteFiles(helpers.StorageId(receipt.ClientId, receipt.RootHash))
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsProtected(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name              string
+		worm              WormPolicy
+		receipt           common.Receipt
+		expectedProtected bool
+	}{
+		{
+			name:              "No hold",
+			receipt:           common.Receipt{CreatedAt: past},
+			expectedProtected: false,
+		},
+		{
+			name:              "Active legal hold",
+			receipt:           common.Receipt{CreatedAt: past, LegalHoldUntil: &future},
+			expectedProtected: true,
+		},
+		{
+			name:              "Lapsed legal hold",
+			receipt:           common.Receipt{CreatedAt: past, LegalHoldUntil: &past},
+			expectedProtected: false,
+		},
+		{
+			name:              "WORM mode without period",
+			worm:              WormPolicy{Enabled: true},
+			receipt:           common.Receipt{CreatedAt: now.Add(-1000 * 24 * time.Hour)},
+			expectedProtected: true,
+		},
+		{
+			name:              "WORM mode - within the period",
+			worm:              WormPolicy{Enabled: true, Period: 30 * 24 * time.Hour},
+			receipt:           common.Receipt{CreatedAt: now.Add(-10 * 24 * time.Hour)},
+			expectedProtected: true,
+		},
+		{
+			name:              "WORM mode - after the period",
+			worm:              WormPolicy{Enabled: true, Period: 30 * 24 * time.Hour},
+			receipt:           common.Receipt{CreatedAt: now.Add(-40 * 24 * time.Hour)},
+			expectedProtected: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Service{worm: tc.worm}
+			assert.Equal(t, tc.expectedProtected, s.isProtected(tc.receipt, now))
+		})
+	}
+}
@@ -2,136 +2,299 @@ package middleware
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
 	"github.com/glethuillier/fvs/server/internal/database"
 	"github.com/glethuillier/fvs/server/internal/helpers"
 	"github.com/glethuillier/fvs/server/internal/logger"
-	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	db *database.Database
+	mu                  sync.RWMutex
+	db                  *database.Database
+	retention           RetentionPolicy
+	worm                WormPolicy
+	scrubInterval       time.Duration
+	lastRetentionReport *RetentionReport
+	lastScrubReport     *ScrubReport
+	scrubbing           bool
+	connections         map[uuid.UUID]*connection
+	cache               *lruCache
+	quotas              QuotaPolicy
+	limiter             *rateLimiter
+	admissions          clientLocks
+
+	// key of the signatures of the receipt bundles (nil: unsigned)
+	bundleKey []byte
+
+	// key of the signatures of the proofs of the downloaded files (nil:
+	// unsigned)
+	proofKey ed25519.PrivateKey
+
+	// new uploads are refused while the server is shutting down
+	draining bool
 }
 
-func (s *Service) Run(ctx context.Context, requestsC, responsesC chan interface{}) {
-	receiver := receiver{
-		db:            s.db,
-		expectedFiles: make(map[string][]string),
-	}
+// Run processes the requests of a connection of an authenticated
+// client, one at a time, until the context is canceled (i.e., until
+// the connection is closed); the responses are sent to the responses
+// queue, which is drained by the writer of the connection
+func (s *Service) Run(
+	ctx context.Context,
+	remoteAddr, clientId string,
+	requests *common.RequestQueue,
+	responses chan<- common.Response,
+) {
+	receiver := newReceiver(s.db, clientId, s.retention)
 
-	filesC := make(chan *common.File)
+	conn := &connection{
+		id:          uuid.New(),
+		clientId:    clientId,
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now().UTC(),
+		receiver:    receiver,
+	}
+	s.addConnection(conn)
 
-	go receiver.receiveFiles(filesC, responsesC)
+	reply := func(response common.Response) {
+		select {
+		case responses <- response:
+		case <-ctx.Done():
+			// the connection is closed: the response is dropped
+		}
+	}
 
 	go func() {
+		defer s.deleteConnection(conn.id)
+
+		// the uploads in progress are abandoned with the connection
+		defer receiver.abandon()
+
 		for {
-			select {
-			case request := <-requestsC:
-				switch r := request.(type) {
-
-				case common.TransferRequest:
-					receiver.prepareToReceiveFiles(r.RootHash, r.Filenames)
-
-				case *common.File:
-					filesC <- r
-
-				case common.DownloadRequest:
-					rootHash, err := s.db.GetRootHash(r.RootHash)
-					if err != nil {
-						logger.Logger.Error(
-							"root hash cannot be retrieved from the database",
-							zap.String("receipt_id", r.RootHash),
-							zap.Error(err),
-						)
-
-						responsesC <- common.ErrorResponse{
-							MessageId: r.MessageId,
-							Error:     err,
-						}
-
-						break
-					}
-
-					// get the requested file
-					fileContents, err := helpers.GetFile(rootHash, r.Filename)
-					if err != nil {
-						logger.Logger.Error(
-							"file contents cannot be retrieved",
-							zap.String("receipt_id", r.Filename),
-							zap.Error(err),
-						)
-
-						responsesC <- common.ErrorResponse{
-							MessageId: r.MessageId,
-							Error:     fmt.Errorf("file not found"),
-						}
-
-						break
-					}
-
-					// get the tree
-					tree, err := s.db.GetTree(rootHash)
-					if err != nil {
-						logger.Logger.Error(
-							"the Merkle tree cannot be loaded",
-							zap.String("root_hash", rootHash),
-							zap.Error(err),
-						)
-
-						responsesC <- common.ErrorResponse{
-							MessageId: r.MessageId,
-							Error:     err,
-						}
-
-						break
-					}
-
-					// extract the relevant proof from the tree
-					proof, err := proofs.GenerateTransferableProof(tree, r.Filename)
-					if err != nil {
-						logger.Logger.Error(
-							"proof cannot be communicated to the client",
-							zap.Error(err),
-						)
-
-						responsesC <- common.ErrorResponse{
-							MessageId: r.MessageId,
-							Error:     err,
-						}
-
-						break
-					}
-
-					responsesC <- &common.File{
+			request, ok := requests.Pop(ctx)
+			if !ok {
+				return
+			}
+
+			switch r := request.(type) {
+
+			case common.TransferRequest:
+				if r.Error != nil {
+					reply(common.TransferAck{
 						MessageId: r.MessageId,
-						Filename:  r.Filename,
-						Contents:  fileContents,
-						Proof:     proof,
-					}
+						Error:     r.Error,
+					})
+					continue
 				}
 
-			case <-ctx.Done():
-				return
+				if s.Draining() {
+					reply(common.TransferAck{
+						MessageId: r.MessageId,
+						Error:     ErrShuttingDown,
+					})
+					continue
+				}
+
+				if err := s.admitUpload(clientId, r, receiver); err != nil {
+					logger.Logger.Warn(
+						"upload refused",
+						zap.String("client_id", clientId),
+						zap.String("root_hash", r.RootHash),
+						zap.Error(err),
+					)
+
+					reply(common.TransferAck{
+						MessageId: r.MessageId,
+						Error:     err,
+					})
+					continue
+				}
+
+			case *common.File:
+				receiver.receiveFile(r, reply)
+
+			case common.DownloadRequest:
+				if r.Error != nil {
+					reply(common.ErrorResponse{
+						MessageId: r.MessageId,
+						Error:     r.Error,
+					})
+					continue
+				}
+
+				if err := s.checkRate(clientId); err != nil {
+					reply(common.ErrorResponse{
+						MessageId: r.MessageId,
+						Error:     err,
+					})
+					continue
+				}
+
+				reply(s.processDownloadRequest(clientId, r))
 			}
 		}
 	}()
 }
 
-func GetService() (*Service, error) {
-	db, err := database.CreateDatabase("proofs.db")
+// processDownloadRequest returns the requested file along with its proof,
+// or an error response (a client can only download its own files)
+func (s *Service) processDownloadRequest(clientId string, r common.DownloadRequest) common.Response {
+	start := time.Now()
+	result := "error"
+	defer func() {
+		metrics.Downloads.WithLabelValues(result).Inc()
+		metrics.DownloadDuration.Observe(time.Since(start).Seconds())
+
+		s.audit(common.AuditEvent{
+			Action:    common.AuditDownload,
+			Actor:     clientActor,
+			ClientId:  clientId,
+			ReceiptId: r.RootHash,
+			Details: map[string]string{
+				"filename": r.Filename,
+				"result":   result,
+			},
+		})
+	}()
+
+	rootHash, err := s.getRootHash(clientId, r.RootHash)
+	if err != nil {
+		logger.Logger.Error(
+			"root hash cannot be retrieved from the database",
+			zap.String("client_id", clientId),
+			zap.String("receipt_id", r.RootHash),
+			zap.Error(err),
+		)
+
+		result = "not_found"
+		return common.ErrorResponse{
+			MessageId: r.MessageId,
+			Error:     err,
+		}
+	}
+
+	// extract the relevant proof from the tree
+	proofStart := time.Now()
+	fileHash, proof, err := s.getFileProof(r.RootHash, r.Filename)
+	if errors.Is(err, database.ErrFileNotFound) {
+		result = "not_found"
+		return common.ErrorResponse{
+			MessageId: r.MessageId,
+			Error:     err,
+		}
+	} else if err != nil {
+		logger.Logger.Error(
+			"proof cannot be communicated to the client",
+			zap.String("root_hash", rootHash),
+			zap.Error(err),
+		)
+
+		return common.ErrorResponse{
+			MessageId: r.MessageId,
+			Error:     err,
+		}
+	}
+	metrics.ProofGenerationDuration.Observe(time.Since(proofStart).Seconds())
+
+	// get the requested file (from a healthy replica, which
+	// is identified using the hash saved in the tree)
+	fileContents, err := helpers.GetFile(
+		helpers.StorageId(clientId, rootHash),
+		r.Filename,
+		fileHash,
+	)
+	if errors.Is(err, helpers.ErrFileCorrupted) {
+		// the file is sent anyway: the client verifies
+		// it and reports the corruption to the caller
+		logger.Logger.Error(
+			"no healthy replica of the file",
+			zap.String("root_hash", rootHash),
+			zap.String("filename", r.Filename),
+		)
+		result = "corrupted"
+	} else if err != nil {
+		logger.Logger.Error(
+			"file contents cannot be retrieved",
+			zap.String("filename", r.Filename),
+			zap.Error(err),
+		)
+
+		result = "not_found"
+		return common.ErrorResponse{
+			MessageId: r.MessageId,
+			Error:     fmt.Errorf("file not found"),
+		}
+	} else {
+		result = "ok"
+	}
+
+	metrics.DownloadedBytes.Add(float64(len(fileContents)))
+
+	signature, keyId := s.signProof(r.RootHash, r.Filename, fileHash, rootHash, proof)
+
+	return &common.File{
+		MessageId:      r.MessageId,
+		Filename:       r.Filename,
+		Contents:       fileContents,
+		Proof:          proof,
+		Signature:      signature,
+		SignatureKeyId: keyId,
+	}
+}
+
+// GetService opens the database and the storage, and applies the
+// policies of the configuration
+func GetService(cfg *config.Config) (*Service, error) {
+	db, err := database.CreateDatabase(cfg.Database.URL)
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 
-	err = helpers.Init()
+	if err = indexLegacyTrees(db); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	quotas, err := newQuotaPolicy(cfg.Quotas)
+	if err != nil {
+		return nil, fmt.Errorf("quotas error: %w", err)
+	}
+
+	worm := WormPolicy{
+		Enabled: cfg.Worm.Mode,
+		Period:  time.Duration(cfg.Worm.Period),
+	}
+
+	err = helpers.Init(cfg.Storage.Dirs, worm.Enabled)
 	if err != nil {
 		logger.Logger.Fatal("server cannot be initialized",
 			zap.Error(err),
 		)
 	}
 
-	return &Service{db: db}, nil
+	return &Service{
+		db: db,
+		retention: RetentionPolicy{
+			MaxAge:      time.Duration(cfg.Retention.MaxAge),
+			MaxReceipts: cfg.Retention.MaxReceipts,
+			ClientQuota: cfg.Retention.ClientQuota,
+			Interval:    time.Duration(cfg.Retention.Interval),
+		},
+		worm:          worm,
+		scrubInterval: time.Duration(cfg.Scrub.Interval),
+		connections:   make(map[uuid.UUID]*connection),
+		cache:         newLRUCache(cfg.Cache.MaxBytes),
+		quotas:        quotas,
+		limiter:       newRateLimiter(),
+		bundleKey:     []byte(cfg.Bundles.SigningKey),
+		proofKey:      newProofKey(cfg.Bundles.SigningKey),
+	}, nil
 }
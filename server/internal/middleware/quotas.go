@@ -0,0 +1,381 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+)
+
+// names of the limits, used in the configuration and in the quota
+// errors sent to the clients
+const (
+	quotaFilesPerBatch      = "files_per_batch"
+	quotaBatchBytes         = "batch_bytes"
+	quotaStoredBytes        = "stored_bytes"
+	quotaRequestsPerSecond  = "requests_per_second"
+	quotaConcurrentSessions = "concurrent_sessions"
+)
+
+// QuotaLimits are the limits applied to a client (a zero value
+// disables the corresponding limit)
+type QuotaLimits struct {
+	// maximum number of files of a batch
+	FilesPerBatch int64
+
+	// maximum size of a batch (in bytes)
+	BatchBytes int64
+
+	// maximum size of the files stored for the client (in bytes)
+	StoredBytes int64
+
+	// maximum number of preflights and download requests per second
+	// (with bursts of up to one second of requests)
+	RequestsPerSecond float64
+
+	// maximum number of batches received at the same time
+	ConcurrentSessions int64
+}
+
+// QuotaPolicy defines the limits of each authenticated client: the
+// default limits, which can be overridden per client
+type QuotaPolicy struct {
+	Default QuotaLimits
+	Clients map[string]QuotaLimits
+}
+
+// newQuotaPolicy returns the policy with the default limits of the
+// configuration, and the limits of specific clients read from the quotas
+// file (one client per line: `client-id limit=value...`; the limits not
+// set are the default ones)
+func newQuotaPolicy(cfg config.QuotasConfig) (QuotaPolicy, error) {
+	policy := QuotaPolicy{
+		Default: QuotaLimits{
+			FilesPerBatch:      cfg.FilesPerBatch,
+			BatchBytes:         cfg.BatchBytes,
+			StoredBytes:        cfg.StoredBytes,
+			RequestsPerSecond:  cfg.RequestsPerSecond,
+			ConcurrentSessions: cfg.ConcurrentSessions,
+		},
+		Clients: make(map[string]QuotaLimits),
+	}
+
+	if cfg.File != "" {
+		if err := policy.loadClients(cfg.File); err != nil {
+			return policy, fmt.Errorf("invalid quotas file %s: %w", cfg.File, err)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *QuotaPolicy) loadClients(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		clientId := fields[0]
+		if _, ok := p.Clients[clientId]; ok {
+			return fmt.Errorf("line %d: client %s already defined", line, clientId)
+		}
+
+		limits := p.Default
+		for _, field := range fields[1:] {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected `limit=value`", line)
+			}
+
+			if err := limits.set(name, value); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		p.Clients[clientId] = limits
+	}
+
+	return scanner.Err()
+}
+
+// set sets a limit from its name and its value
+func (l *QuotaLimits) set(name, value string) error {
+	var err error
+
+	switch name {
+	case quotaFilesPerBatch:
+		l.FilesPerBatch, err = strconv.ParseInt(value, 10, 64)
+	case quotaBatchBytes:
+		l.BatchBytes, err = strconv.ParseInt(value, 10, 64)
+	case quotaStoredBytes:
+		l.StoredBytes, err = strconv.ParseInt(value, 10, 64)
+	case quotaRequestsPerSecond:
+		l.RequestsPerSecond, err = strconv.ParseFloat(value, 64)
+		if err == nil && (math.IsNaN(l.RequestsPerSecond) || math.IsInf(l.RequestsPerSecond, 0)) {
+			err = fmt.Errorf("not a finite number")
+		}
+	case quotaConcurrentSessions:
+		l.ConcurrentSessions, err = strconv.ParseInt(value, 10, 64)
+	default:
+		return fmt.Errorf("unknown limit %q", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	if l.FilesPerBatch < 0 || l.BatchBytes < 0 || l.StoredBytes < 0 ||
+		l.RequestsPerSecond < 0 || l.ConcurrentSessions < 0 {
+		return fmt.Errorf("invalid %s: cannot be negative", name)
+	}
+
+	return nil
+}
+
+// limits returns the limits applied to a client
+func (p QuotaPolicy) limits(clientId string) QuotaLimits {
+	if limits, ok := p.Clients[clientId]; ok {
+		return limits
+	}
+
+	return p.Default
+}
+
+// rateLimiter limits the rate of the requests of each client with a
+// token bucket, shared by the connections of the client
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow consumes a token of the bucket of a client, which is refilled at
+// the given rate and holds up to one second of requests (at least one);
+// if the bucket is empty, it returns the delay before the next token
+func (l *rateLimiter) allow(clientId string, rate float64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := math.Max(1, rate)
+
+	bucket, ok := l.buckets[clientId]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, updatedAt: now}
+		l.buckets[clientId] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+	bucket.updatedAt = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// clientLocks serializes the admission of the uploads of each client, so
+// that the quotas of a client are checked and its batch is reserved at
+// once
+type clientLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks the admissions of a client and returns the function that
+// unlocks them
+func (l *clientLocks) lock(clientId string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+
+	lock, ok := l.locks[clientId]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[clientId] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// quotaExceeded returns the error sent to a client that exceeds a limit
+func quotaExceeded(limit string, max, requested int64) error {
+	metrics.QuotaRejections.WithLabelValues(limit).Inc()
+
+	return &common.QuotaError{
+		Limit:     limit,
+		Max:       max,
+		Requested: requested,
+	}
+}
+
+// checkRate consumes a request of the rate limit of a client
+func (s *Service) checkRate(clientId string) error {
+	limits := s.quotas.limits(clientId)
+	if limits.RequestsPerSecond == 0 {
+		return nil
+	}
+
+	ok, wait := s.limiter.allow(clientId, limits.RequestsPerSecond, time.Now())
+	if ok {
+		return nil
+	}
+
+	metrics.QuotaRejections.WithLabelValues(quotaRequestsPerSecond).Inc()
+
+	return &common.QuotaError{
+		Limit:      quotaRequestsPerSecond,
+		Max:        int64(math.Ceil(limits.RequestsPerSecond)),
+		RetryAfter: wait,
+	}
+}
+
+// admitUpload checks the batch announced by the preflight of a client
+// and, if it can be received, reserves it on the receiver of the
+// connection; the admissions of a client are serialized, so that two
+// connections cannot both fit in the same remaining quota
+func (s *Service) admitUpload(clientId string, r common.TransferRequest, receiver *receiver) error {
+	unlock := s.admissions.lock(clientId)
+	defer unlock()
+
+	if err := s.checkUpload(clientId, r, receiver); err != nil {
+		return err
+	}
+
+	batchBytes, _ := declaredBytes(r)
+	receiver.prepareToReceiveFiles(r.RootHash, r.Filenames, batchBytes)
+
+	return nil
+}
+
+// checkUpload returns an error (a *common.QuotaError if a quota is
+// exceeded) if the batch announced by the preflight of a client cannot
+// be received; the sizes of the files must be declared if the sizes are
+// limited
+func (s *Service) checkUpload(clientId string, r common.TransferRequest, receiver *receiver) error {
+	if err := s.checkRate(clientId); err != nil {
+		return err
+	}
+
+	limits := s.quotas.limits(clientId)
+
+	files := int64(len(r.Filenames))
+	if limits.FilesPerBatch > 0 && files > limits.FilesPerBatch {
+		return quotaExceeded(quotaFilesPerBatch, limits.FilesPerBatch, files)
+	}
+
+	batchBytes, err := declaredBytes(r)
+	if err != nil {
+		return err
+	}
+
+	if batchBytes < 0 && (limits.BatchBytes > 0 || limits.StoredBytes > 0) {
+		return fmt.Errorf("the preflight must declare the sizes of the files")
+	}
+
+	if limits.BatchBytes > 0 && batchBytes > limits.BatchBytes {
+		return quotaExceeded(quotaBatchBytes, limits.BatchBytes, batchBytes)
+	}
+
+	if limits.ConcurrentSessions == 0 && limits.StoredBytes == 0 {
+		return nil
+	}
+
+	sessions, reservedBytes := s.clientSessions(clientId, receiver, r.RootHash)
+
+	if limits.ConcurrentSessions > 0 && sessions >= limits.ConcurrentSessions {
+		return quotaExceeded(quotaConcurrentSessions, limits.ConcurrentSessions, sessions+1)
+	}
+
+	if limits.StoredBytes > 0 {
+		storedBytes, err := s.db.GetStoredBytes(clientId)
+		if err != nil {
+			return err
+		}
+
+		total := storedBytes + reservedBytes + batchBytes
+		if total > limits.StoredBytes {
+			return quotaExceeded(quotaStoredBytes, limits.StoredBytes, total)
+		}
+	}
+
+	return nil
+}
+
+// declaredBytes returns the size of the batch announced by a preflight
+// (-1 if the sizes of the files are not declared)
+func declaredBytes(r common.TransferRequest) (int64, error) {
+	if len(r.Sizes) == 0 {
+		return -1, nil
+	}
+
+	if len(r.Sizes) != len(r.Filenames) {
+		return 0, fmt.Errorf("the preflight declares %d sizes for %d files", len(r.Sizes), len(r.Filenames))
+	}
+
+	var total int64
+	for _, size := range r.Sizes {
+		if size < 0 || size > math.MaxInt64-total {
+			return 0, fmt.Errorf("invalid file size in the preflight")
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+// clientSessions returns the number of batches of a client being
+// received or processed and their declared size, except the batch with
+// the given root hash on the receiver of the preflight (the batch is
+// replaced by the new preflight, on this connection only)
+func (s *Service) clientSessions(clientId string, replacing *receiver, rootHash string) (int64, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions, bytes int64
+	for _, c := range s.connections {
+		if c.clientId != clientId {
+			continue
+		}
+
+		except := ""
+		if c.receiver == replacing {
+			except = rootHash
+		}
+
+		n, b := c.receiver.reserved(except)
+		sessions += n
+		bytes += b
+	}
+
+	return sessions, bytes
+}
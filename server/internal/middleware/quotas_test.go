@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter()
+
+	// bursts of up to one second of requests
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.allow("a", 2, now)
+		assert.True(t, ok)
+	}
+
+	ok, wait := limiter.allow("a", 2, now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// the other clients have their own bucket
+	ok, _ = limiter.allow("b", 2, now)
+	assert.True(t, ok)
+
+	// the bucket is refilled over time
+	ok, _ = limiter.allow("a", 2, now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+}
+
+func TestCheckUpload(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        QuotaLimits
+		request       common.TransferRequest
+		expectedLimit string
+		expectedError bool
+	}{
+		{
+			name:    "No limits",
+			request: common.TransferRequest{Filenames: []string{"a", "b"}},
+		},
+		{
+			name:          "Too many files",
+			limits:        QuotaLimits{FilesPerBatch: 1},
+			request:       common.TransferRequest{Filenames: []string{"a", "b"}},
+			expectedLimit: quotaFilesPerBatch,
+		},
+		{
+			name:          "Batch too large",
+			limits:        QuotaLimits{BatchBytes: 100},
+			request:       common.TransferRequest{Filenames: []string{"a", "b"}, Sizes: []int64{60, 60}},
+			expectedLimit: quotaBatchBytes,
+		},
+		{
+			name:    "Batch within limits",
+			limits:  QuotaLimits{FilesPerBatch: 2, BatchBytes: 120},
+			request: common.TransferRequest{Filenames: []string{"a", "b"}, Sizes: []int64{60, 60}},
+		},
+		{
+			name:          "Sizes not declared",
+			limits:        QuotaLimits{BatchBytes: 100},
+			request:       common.TransferRequest{Filenames: []string{"a", "b"}},
+			expectedError: true,
+		},
+		{
+			name:          "Sizes inconsistent with the files",
+			request:       common.TransferRequest{Filenames: []string{"a", "b"}, Sizes: []int64{60}},
+			expectedError: true,
+		},
+		{
+			name:          "Too many sessions",
+			limits:        QuotaLimits{ConcurrentSessions: 1},
+			request:       common.TransferRequest{RootHash: "new", Filenames: []string{"a"}},
+			expectedLimit: quotaConcurrentSessions,
+		},
+		{
+			name:    "Session replaced by a new preflight",
+			limits:  QuotaLimits{ConcurrentSessions: 1},
+			request: common.TransferRequest{RootHash: "pending", Filenames: []string{"a"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Service{
+				connections: make(map[uuid.UUID]*connection),
+				quotas:      QuotaPolicy{Default: tc.limits},
+				limiter:     newRateLimiter(),
+			}
+
+			// a batch of the client is being received
+			receiver := newReceiver(nil, "alice", RetentionPolicy{})
+			receiver.prepareToReceiveFiles("pending", []string{"x"}, 10)
+			s.connections[uuid.New()] = &connection{clientId: "alice", receiver: receiver}
+
+			err := s.checkUpload("alice", tc.request, receiver)
+
+			switch {
+			case tc.expectedLimit != "":
+				quotaErr, ok := err.(*common.QuotaError)
+				assert.True(t, ok)
+				if ok {
+					assert.Equal(t, tc.expectedLimit, quotaErr.Limit)
+				}
+			case tc.expectedError:
+				assert.Error(t, err)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAdmitUpload(t *testing.T) {
+	s := &Service{
+		connections: make(map[uuid.UUID]*connection),
+		quotas:      QuotaPolicy{Default: QuotaLimits{ConcurrentSessions: 1}},
+		limiter:     newRateLimiter(),
+	}
+
+	// connections of the same client announce batches at the same time:
+	// only one of them fits in the quota
+	const n = 10
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		admitted int
+	)
+
+	receivers := make([]*receiver, n)
+	for i := range receivers {
+		receivers[i] = newReceiver(nil, "alice", RetentionPolicy{})
+		s.connections[uuid.New()] = &connection{clientId: "alice", receiver: receivers[i]}
+	}
+
+	for i, r := range receivers {
+		wg.Add(1)
+		go func(i int, r *receiver) {
+			defer wg.Done()
+
+			err := s.admitUpload("alice", common.TransferRequest{
+				RootHash:  fmt.Sprintf("batch-%d", i),
+				Filenames: []string{"a"},
+			}, r)
+			if err == nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}(i, r)
+	}
+
+	wg.Wait()
+	assert.Equal(t, 1, admitted)
+}
+
+func TestAdmitSameBatchOnSeveralConnections(t *testing.T) {
+	s := &Service{
+		connections: make(map[uuid.UUID]*connection),
+		quotas:      QuotaPolicy{Default: QuotaLimits{ConcurrentSessions: 1}},
+		limiter:     newRateLimiter(),
+	}
+
+	first := newReceiver(nil, "alice", RetentionPolicy{})
+	second := newReceiver(nil, "alice", RetentionPolicy{})
+	s.connections[uuid.New()] = &connection{clientId: "alice", receiver: first}
+	s.connections[uuid.New()] = &connection{clientId: "alice", receiver: second}
+
+	request := common.TransferRequest{RootHash: "same", Filenames: []string{"a"}}
+	assert.NoError(t, s.admitUpload("alice", request, first))
+
+	// the batch replaces the batch of its own connection only
+	assert.NoError(t, s.admitUpload("alice", request, first))
+
+	err := s.admitUpload("alice", request, second)
+	quotaErr, ok := err.(*common.QuotaError)
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, quotaConcurrentSessions, quotaErr.Limit)
+	}
+}
+
+func TestReservedWhileProcessing(t *testing.T) {
+	receiver := newReceiver(nil, "alice", RetentionPolicy{})
+	receiver.prepareToReceiveFiles("pending", []string{"x"}, 10)
+
+	// a complete batch is being stored
+	receiver.processing = 1
+	receiver.processingBytes = 50
+
+	sessions, bytes := receiver.reserved("")
+	assert.Equal(t, int64(2), sessions)
+	assert.Equal(t, int64(60), bytes)
+
+	sessions, bytes = receiver.reserved("pending")
+	assert.Equal(t, int64(1), sessions)
+	assert.Equal(t, int64(50), bytes)
+}
@@ -2,12 +2,15 @@ package middleware
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/glethuillier/fvs/server/internal/common"
 	"github.com/glethuillier/fvs/server/internal/database"
 	"github.com/glethuillier/fvs/server/internal/helpers"
 	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
 	"github.com/glethuillier/fvs/server/internal/proofs"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -22,66 +25,255 @@ const (
 	OTHER_ERROR
 )
 
+// uploadResults labels the outcomes of the uploads in the metrics
+var uploadResults = map[responseType]string{
+	ROOTS_MATCH:    "accepted",
+	ROOTS_MISMATCH: "mismatch",
+	NOT_UNIQUE:     "duplicate",
+	OTHER_ERROR:    "error",
+}
+
 type receiver struct {
 	sync.RWMutex
 	db            *database.Database
+	clientId      string
+	retention     RetentionPolicy
 	expectedFiles map[string][]string
+	receivedFiles map[string][]*common.File
+	startedAt     map[string]time.Time
+
+	// size of each batch declared by its preflight (-1 if unknown),
+	// and size of the files received so far
+	declaredBytes map[string]int64
+	receivedBytes map[string]int64
+
+	// filenames of each batch not received yet
+	pendingFiles map[string]map[string]struct{}
+
+	// number of complete batches being processed, and their size
+	processing      int
+	processingBytes int64
+}
+
+func newReceiver(db *database.Database, clientId string, retention RetentionPolicy) *receiver {
+	return &receiver{
+		db:            db,
+		clientId:      clientId,
+		retention:     retention,
+		expectedFiles: make(map[string][]string),
+		receivedFiles: make(map[string][]*common.File),
+		startedAt:     make(map[string]time.Time),
+		declaredBytes: make(map[string]int64),
+		receivedBytes: make(map[string]int64),
+		pendingFiles:  make(map[string]map[string]struct{}),
+	}
 }
 
-func (r *receiver) prepareToReceiveFiles(requestId string, filenames []string) {
+// prepareToReceiveFiles starts a batch announced by a preflight, along
+// with its declared size (-1 if unknown); a batch already in progress
+// is restarted
+func (r *receiver) prepareToReceiveFiles(requestId string, filenames []string, declaredBytes int64) {
 	r.Lock()
 	defer r.Unlock()
 
+	if _, ok := r.expectedFiles[requestId]; !ok {
+		metrics.ActiveUploadSessions.Inc()
+	}
+
+	pending := make(map[string]struct{}, len(filenames))
+	for _, filename := range filenames {
+		pending[filename] = struct{}{}
+	}
+
 	r.expectedFiles[requestId] = filenames
+	r.receivedFiles[requestId] = nil
+	r.startedAt[requestId] = time.Now().UTC()
+	r.declaredBytes[requestId] = declaredBytes
+	r.receivedBytes[requestId] = 0
+	r.pendingFiles[requestId] = pending
 }
 
-func (r *receiver) receiveFiles(
-	filesC chan *common.File,
-	responsesC chan interface{},
-) bool {
-	filesToProcess := make(map[string][]*common.File)
-
-	for {
-		select {
-		case file := <-filesC:
-			r.Lock()
-			_, ok := filesToProcess[file.RootHash]
-			if !ok {
-				filesToProcess[file.RootHash] = []*common.File{}
-			}
+// receiveFile adds a file to the batch it belongs to and, once all the
+// files of the batch have been received, processes the batch
+func (r *receiver) receiveFile(file *common.File, reply func(common.Response)) {
+	r.Lock()
 
-			filesToProcess[file.RootHash] = append(filesToProcess[file.RootHash], file)
-			r.Unlock()
+	// an invalid file fails its batch
+	if file.Error != nil {
+		r.reject(file, file.Error, reply)
+		return
+	}
 
-			r.RLock()
-			if len(filesToProcess[file.RootHash]) == len(r.expectedFiles[file.RootHash]) {
-				r.processFiles(
-					file.MessageId,
-					file.RootHash,
-					filesToProcess[file.RootHash],
-					responsesC,
-				)
+	pending, ok := r.pendingFiles[file.RootHash]
+	if !ok {
+		// no preflight (e.g., refused upload): the file is discarded
+		r.Unlock()
+		logger.Logger.Debug(
+			"unexpected file discarded",
+			zap.String("root_hash", file.RootHash),
+			zap.String("filename", file.Filename),
+		)
+		return
+	}
 
-				// discard files in memory
-				filesToProcess[file.RootHash] = nil
-			}
-			r.RUnlock()
+	if _, ok := pending[file.Filename]; !ok {
+		r.reject(file, fmt.Errorf("file %q not expected (not in the preflight or already received)", file.Filename), reply)
+		return
+	}
+
+	r.receivedBytes[file.RootHash] += int64(len(file.Contents))
+	if declared := r.declaredBytes[file.RootHash]; declared >= 0 && r.receivedBytes[file.RootHash] > declared {
+		// the client cannot send more than it declared (its quotas
+		// are checked against the declared size)
+		r.reject(file, fmt.Errorf("the files exceed the sizes declared in the preflight"), reply)
+		return
+	}
+
+	delete(pending, file.Filename)
+	r.receivedFiles[file.RootHash] = append(r.receivedFiles[file.RootHash], file)
+
+	files := r.receivedFiles[file.RootHash]
+	startedAt := r.startedAt[file.RootHash]
+	batchBytes := max(r.declaredBytes[file.RootHash], r.receivedBytes[file.RootHash])
+	complete := len(pending) == 0
+	if complete {
+		metrics.ActiveUploadSessions.Dec()
+
+		// discard files in memory (the batch stays reserved until
+		// it is stored)
+		r.discard(file.RootHash)
+		r.processing++
+		r.processingBytes += batchBytes
+	}
+	r.Unlock()
+
+	if complete {
+		r.processFiles(
+			file.MessageId,
+			file.RootHash,
+			files,
+			reply,
+		)
+
+		r.Lock()
+		r.processing--
+		r.processingBytes -= batchBytes
+		r.Unlock()
+
+		metrics.UploadDuration.Observe(time.Since(startedAt).Seconds())
+	}
+}
+
+// reject drops the batch of a file (if any) and answers the batch with
+// an error; the lock must be held, and is released
+func (r *receiver) reject(file *common.File, err error, reply func(common.Response)) {
+	if _, ok := r.expectedFiles[file.RootHash]; ok {
+		metrics.ActiveUploadSessions.Dec()
+		r.discard(file.RootHash)
+	}
+	r.Unlock()
+
+	logger.Logger.Warn(
+		"batch rejected",
+		zap.String("client_id", r.clientId),
+		zap.String("root_hash", file.RootHash),
+		zap.Error(err),
+	)
+
+	reply(common.TransferAck{
+		MessageId: file.MessageId,
+		Error:     err,
+	})
+}
+
+// discard forgets a batch (the lock must be held)
+func (r *receiver) discard(rootHash string) {
+	delete(r.receivedFiles, rootHash)
+	delete(r.expectedFiles, rootHash)
+	delete(r.startedAt, rootHash)
+	delete(r.declaredBytes, rootHash)
+	delete(r.receivedBytes, rootHash)
+	delete(r.pendingFiles, rootHash)
+}
+
+// reserved returns the number of batches being received (except the
+// batch with the given root hash) or processed, and their size (as
+// declared or, if unknown, as received so far)
+func (r *receiver) reserved(exceptRootHash string) (int64, int64) {
+	r.RLock()
+	defer r.RUnlock()
+
+	sessions, bytes := int64(r.processing), r.processingBytes
+	for rootHash := range r.expectedFiles {
+		if rootHash == exceptRootHash {
+			continue
+		}
+
+		sessions++
+		bytes += max(r.declaredBytes[rootHash], r.receivedBytes[rootHash])
+	}
+
+	return sessions, bytes
+}
+
+// busy returns whether batches are being received or processed
+func (r *receiver) busy() bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.expectedFiles) > 0 || r.processing > 0
+}
+
+// abandon discards the uploads in progress
+func (r *receiver) abandon() {
+	r.Lock()
+	defer r.Unlock()
+
+	metrics.ActiveUploadSessions.Sub(float64(len(r.expectedFiles)))
+
+	r.expectedFiles = make(map[string][]string)
+	r.receivedFiles = make(map[string][]*common.File)
+	r.startedAt = make(map[string]time.Time)
+	r.declaredBytes = make(map[string]int64)
+	r.receivedBytes = make(map[string]int64)
+	r.pendingFiles = make(map[string]map[string]struct{})
+}
+
+// sessions returns the uploads in progress
+func (r *receiver) sessions() []UploadSession {
+	r.RLock()
+	defer r.RUnlock()
+
+	sessions := []UploadSession{}
+	for rootHash, filenames := range r.expectedFiles {
+		session := UploadSession{
+			RootHash:      rootHash,
+			ExpectedFiles: len(filenames),
+			ReceivedFiles: len(r.receivedFiles[rootHash]),
+			ReceivedBytes: r.receivedBytes[rootHash],
+			StartedAt:     r.startedAt[rootHash],
 		}
+
+		sessions = append(sessions, session)
 	}
+
+	return sessions
 }
 
 func (r *receiver) processFiles(
 	messageId uuid.UUID,
 	expectedRootHash string,
 	files []*common.File,
-	responsesC chan interface{},
+	reply func(common.Response),
 ) {
 	var (
 		responseType   responseType
 		knownReceiptId string
 	)
 
+	buildStart := time.Now()
 	tree, err := proofs.BuildMerkleTree(files)
+	metrics.MerkleBuildDuration.Observe(time.Since(buildStart).Seconds())
 	if err != nil {
 		responseType = OTHER_ERROR
 
@@ -90,7 +282,7 @@ func (r *receiver) processFiles(
 			zap.Error(err),
 		)
 	} else {
-		treeAlreadyPresent, receiptId, err := r.db.IsTreeAlreadyPresent(tree.RootHash)
+		treeAlreadyPresent, receiptId, err := r.db.IsTreeAlreadyPresent(r.clientId, tree.RootHash)
 		if treeAlreadyPresent {
 			responseType = NOT_UNIQUE
 			knownReceiptId = receiptId
@@ -112,54 +304,122 @@ func (r *receiver) processFiles(
 		}
 	}
 
+	metrics.Uploads.WithLabelValues(uploadResults[responseType]).Inc()
+
+	switch responseType {
+	case NOT_UNIQUE:
+		recordAudit(r.db, common.AuditEvent{
+			Action:    common.AuditUploadRejected,
+			Actor:     clientActor,
+			ClientId:  r.clientId,
+			ReceiptId: knownReceiptId,
+			Details: map[string]string{
+				"reason":    uploadResults[responseType],
+				"root_hash": expectedRootHash,
+			},
+		})
+	case ROOTS_MISMATCH:
+		recordAudit(r.db, common.AuditEvent{
+			Action:   common.AuditUploadRejected,
+			Actor:    clientActor,
+			ClientId: r.clientId,
+			Details: map[string]string{
+				"reason":           uploadResults[responseType],
+				"root_hash":        expectedRootHash,
+				"server_root_hash": tree.RootHash,
+			},
+		})
+	}
+
 	switch responseType {
 	case ROOTS_MATCH:
+		storageId := helpers.StorageId(r.clientId, tree.RootHash)
 		for _, f := range files {
-			helpers.SaveFile(f.RootHash, f.Filename, f.Contents)
+			if err = helpers.SaveFile(storageId, f.Filename, f.Contents); err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			logger.Logger.Error(
+				"the files cannot be saved",
+				zap.String("root_hash", expectedRootHash),
+				zap.Error(err),
+			)
+			r.discardFiles(tree.RootHash)
+
+			reply(common.TransferAck{
+				MessageId: messageId,
+				Error:     fmt.Errorf("the server cannot store the files"),
+			})
+
+			break
 		}
 
 		receiptId := uuid.New()
+		createdAt := time.Now().UTC()
 
-		err = r.db.SaveTree(receiptId, tree)
+		err = r.db.SaveTree(&common.Receipt{
+			ReceiptId: receiptId.String(),
+			ClientId:  r.clientId,
+			CreatedAt: createdAt,
+			ExpiresAt: r.retention.expiresAt(createdAt),
+		}, tree)
 		if err != nil {
 			logger.Logger.Error(
 				"the tree cannot be saved in database",
 				zap.String("receipt_id", receiptId.String()),
 				zap.Error(err),
 			)
+			r.discardFiles(tree.RootHash)
 
-			responsesC <- common.TransferAck{
+			reply(common.TransferAck{
 				MessageId: messageId,
 				Error:     err,
-			}
+			})
 		} else {
-			responsesC <- common.TransferAck{
+			for _, f := range files {
+				metrics.UploadedBytes.Add(float64(len(f.Contents)))
+			}
+
+			recordAudit(r.db, common.AuditEvent{
+				Action:    common.AuditUploadAccepted,
+				Actor:     clientActor,
+				ClientId:  r.clientId,
+				ReceiptId: receiptId.String(),
+				Details: map[string]string{
+					"root_hash": tree.RootHash,
+					"files":     strconv.Itoa(len(files)),
+				},
+			})
+
+			reply(common.TransferAck{
 				MessageId: messageId,
 				ReceiptId: receiptId.String(),
-			}
+			})
 		}
 
 	case NOT_UNIQUE:
-		responsesC <- common.TransferAck{
+		reply(common.TransferAck{
 			MessageId: messageId,
 			Error: fmt.Errorf(
 				"these files has already been processed by the server; receipt ID: %s",
 				knownReceiptId,
 			),
-		}
+		})
 
 	case OTHER_ERROR:
-		responsesC <- common.TransferAck{
+		reply(common.TransferAck{
 			MessageId: messageId,
 			// send a generic error message to the client so that we
 			// do not leak detail about the internal implementation
 			Error: fmt.Errorf(
 				"the server cannot process the files",
 			),
-		}
+		})
 
 	case ROOTS_MISMATCH:
-		responsesC <- common.TransferAck{
+		reply(common.TransferAck{
 			MessageId: messageId,
 			Error: fmt.Errorf(
 				// send a generic error message to client (i.e., do not
@@ -173,6 +433,26 @@ func (r *receiver) processFiles(
 				expectedRootHash,
 				tree.RootHash,
 			),
-		}
+		})
+	}
+}
+
+// discardFiles deletes the files of a batch that cannot be stored, so
+// that the batch can be uploaded again (in write-once mode, the files
+// left would be refused); the files are kept if they belong to a
+// receipt (e.g., the same batch stored through another connection)
+func (r *receiver) discardFiles(rootHash string) {
+	present, _, err := r.db.IsTreeAlreadyPresent(r.clientId, rootHash)
+	if err != nil || present {
+		return
+	}
+
+	if err := helpers.DeleteFiles(helpers.StorageId(r.clientId, rootHash)); err != nil {
+		logger.Logger.Error(
+			"the files of the batch cannot be deleted",
+			zap.String("client_id", r.clientId),
+			zap.String("root_hash", rootHash),
+			zap.Error(err),
+		)
 	}
 }
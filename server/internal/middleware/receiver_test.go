@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessFilesRetry(t *testing.T) {
+	logger.Init("")
+
+	dir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.Database.URL = filepath.Join(dir, "proofs.db")
+	cfg.Storage.Dirs = []string{filepath.Join(dir, "downloads")}
+	cfg.Worm.Mode = true
+
+	s, err := GetService(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	receiver := newReceiver(s.db, "alice", s.retention)
+
+	files := []*common.File{
+		{Filename: "a.txt", Contents: []byte("a")},
+		{Filename: "b.txt", Contents: []byte("bb")},
+	}
+
+	tree, err := proofs.BuildMerkleTree(files)
+	assert.NoError(t, err)
+
+	upload := func() common.TransferAck {
+		var ack common.TransferAck
+		receiver.processFiles(uuid.New(), tree.RootHash, files, func(r common.Response) {
+			ack = r.(common.TransferAck)
+		})
+		return ack
+	}
+
+	// the tree cannot be saved: the files already written are deleted
+	_, err = s.db.Exec("ALTER TABLE TREE_NODES RENAME TO TREE_NODES_OFF")
+	assert.NoError(t, err)
+
+	ack := upload()
+	assert.Error(t, ack.Error)
+
+	_, err = s.db.Exec("ALTER TABLE TREE_NODES_OFF RENAME TO TREE_NODES")
+	assert.NoError(t, err)
+
+	// ... so that the batch can be uploaded again, despite the
+	// write-once mode
+	ack = upload()
+	assert.NoError(t, ack.Error)
+	assert.NotEmpty(t, ack.ReceiptId)
+
+	// the files of a stored batch are kept
+	assert.ErrorContains(t, upload().Error, "already been processed")
+
+	contents, err := helpers.GetFile(helpers.StorageId("alice", tree.RootHash), "b.txt", tree.FilenameToHash["b.txt"])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bb"), contents)
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy defines how long and how many batches of files
+// the server keeps (a zero value disables the corresponding limit)
+type RetentionPolicy struct {
+	// maximum age of a receipt
+	MaxAge time.Duration
+
+	// maximum number of receipts kept by the server
+	MaxReceipts int
+
+	// maximum number of bytes stored per client
+	ClientQuota int64
+
+	// how often the policy is enforced
+	Interval time.Duration
+}
+
+type expirationReason string
+
+const (
+	EXPIRED        expirationReason = "expired"
+	MAX_RECEIPTS   expirationReason = "max_receipts"
+	QUOTA_EXCEEDED expirationReason = "quota_exceeded"
+)
+
+type ExpiredReceipt struct {
+	Receipt common.Receipt   `json:"receipt"`
+	Reason  expirationReason `json:"reason"`
+}
+
+// RetentionReport summarizes a run of the retention job
+type RetentionReport struct {
+	RunAt      time.Time        `json:"run_at"`
+	Expired    []ExpiredReceipt `json:"expired"`
+	FreedBytes int64            `json:"freed_bytes"`
+
+	// receipts that should have been expired but are under legal hold
+	Held []ExpiredReceipt `json:"held"`
+}
+
+// expiresAt returns the expiry date of a receipt created at a given
+// time (nil if receipts do not expire)
+func (p RetentionPolicy) expiresAt(createdAt time.Time) *time.Time {
+	if p.MaxAge == 0 {
+		return nil
+	}
+
+	t := createdAt.Add(p.MaxAge)
+	return &t
+}
+
+func (p RetentionPolicy) isEnabled() bool {
+	return p.MaxAge > 0 || p.MaxReceipts > 0 || p.ClientQuota > 0
+}
+
+// selectExpiredReceipts returns the receipts that have to be deleted
+// to comply with the policy; receipts are expected to be sorted from
+// the oldest to the most recent
+func (p RetentionPolicy) selectExpiredReceipts(
+	receipts []common.Receipt,
+	now time.Time,
+) []ExpiredReceipt {
+	var (
+		expired   []ExpiredReceipt
+		remaining []common.Receipt
+	)
+
+	// expiry dates and maximum age
+	for _, r := range receipts {
+		if (r.ExpiresAt != nil && !r.ExpiresAt.After(now)) ||
+			(p.MaxAge > 0 && !r.CreatedAt.Add(p.MaxAge).After(now)) {
+			expired = append(expired, ExpiredReceipt{Receipt: r, Reason: EXPIRED})
+			continue
+		}
+
+		remaining = append(remaining, r)
+	}
+
+	// maximum number of receipts (the oldest ones are deleted first)
+	if p.MaxReceipts > 0 && len(remaining) > p.MaxReceipts {
+		excess := len(remaining) - p.MaxReceipts
+		for _, r := range remaining[:excess] {
+			expired = append(expired, ExpiredReceipt{Receipt: r, Reason: MAX_RECEIPTS})
+		}
+		remaining = remaining[excess:]
+	}
+
+	// quota per client (the oldest receipts of the client are deleted
+	// first, until the client is under its quota)
+	if p.ClientQuota > 0 {
+		usage := make(map[string]int64)
+		for _, r := range remaining {
+			usage[r.ClientId] += r.Size
+		}
+
+		for _, r := range remaining {
+			if usage[r.ClientId] <= p.ClientQuota {
+				continue
+			}
+
+			usage[r.ClientId] -= r.Size
+			expired = append(expired, ExpiredReceipt{Receipt: r, Reason: QUOTA_EXCEEDED})
+		}
+	}
+
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[i].Receipt.CreatedAt.Before(expired[j].Receipt.CreatedAt)
+	})
+
+	return expired
+}
+
+// RunRetention periodically enforces the retention policy
+// until the context is canceled
+func (s *Service) RunRetention(ctx context.Context) {
+	if !s.retention.isEnabled() {
+		logger.Logger.Info("no retention policy configured")
+		return
+	}
+
+	logger.Logger.Info(
+		"retention policy enabled",
+		zap.Duration("max_age", s.retention.MaxAge),
+		zap.Int("max_receipts", s.retention.MaxReceipts),
+		zap.Int64("client_quota", s.retention.ClientQuota),
+		zap.Duration("interval", s.retention.Interval),
+	)
+
+	ticker := time.NewTicker(s.retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.EnforceRetention(); err != nil {
+			logger.Logger.Error(
+				"retention policy cannot be enforced",
+				zap.Error(err),
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// EnforceRetention deletes the receipts (and their files) that do not
+// comply with the retention policy and reports what was expired
+func (s *Service) EnforceRetention() (*RetentionReport, error) {
+	receipts, err := s.db.ListReceipts()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{RunAt: time.Now().UTC()}
+
+	for _, e := range s.retention.selectExpiredReceipts(receipts, report.RunAt) {
+		if err := s.deleteReceipt(e.Receipt); err != nil {
+			if errors.Is(err, database.ErrReceiptOnHold) {
+				logger.Logger.Info(
+					"receipt under legal hold not expired",
+					zap.String("receipt_id", e.Receipt.ReceiptId),
+					zap.String("reason", string(e.Reason)),
+				)
+				report.Held = append(report.Held, e)
+				continue
+			}
+
+			logger.Logger.Error(
+				"receipt cannot be expired",
+				zap.String("receipt_id", e.Receipt.ReceiptId),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		logger.Logger.Info(
+			"receipt expired",
+			zap.String("receipt_id", e.Receipt.ReceiptId),
+			zap.String("client_id", e.Receipt.ClientId),
+			zap.String("reason", string(e.Reason)),
+			zap.Int64("size", e.Receipt.Size),
+		)
+
+		metrics.ExpiredReceipts.WithLabelValues(string(e.Reason)).Inc()
+
+		s.audit(common.AuditEvent{
+			Action:    common.AuditReceiptExpired,
+			Actor:     retentionActor,
+			ClientId:  e.Receipt.ClientId,
+			ReceiptId: e.Receipt.ReceiptId,
+			Details: map[string]string{
+				"reason":    string(e.Reason),
+				"root_hash": e.Receipt.RootHash,
+			},
+		})
+
+		report.Expired = append(report.Expired, e)
+		report.FreedBytes += e.Receipt.Size
+	}
+
+	logger.Logger.Info(
+		"retention policy enforced",
+		zap.Int("expired_receipts", len(report.Expired)),
+		zap.Int("held_receipts", len(report.Held)),
+		zap.Int64("freed_bytes", report.FreedBytes),
+	)
+
+	s.mu.Lock()
+	s.lastRetentionReport = report
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// LastRetentionReport returns the report of the last run of
+// the retention job (nil if the job has not run yet)
+func (s *Service) LastRetentionReport() *RetentionReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastRetentionReport
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectExpiredReceipts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+
+	receipts := []common.Receipt{
+		{ReceiptId: "r1", ClientId: "a", CreatedAt: now.Add(-100 * 24 * time.Hour), Size: 10},
+		{ReceiptId: "r2", ClientId: "a", CreatedAt: now.Add(-50 * 24 * time.Hour), Size: 20},
+		{ReceiptId: "r3", ClientId: "b", CreatedAt: now.Add(-40 * 24 * time.Hour), Size: 30, ExpiresAt: &past},
+		{ReceiptId: "r4", ClientId: "b", CreatedAt: now.Add(-30 * 24 * time.Hour), Size: 40},
+		{ReceiptId: "r5", ClientId: "a", CreatedAt: now.Add(-1 * 24 * time.Hour), Size: 50},
+	}
+
+	tests := []struct {
+		name             string
+		policy           RetentionPolicy
+		expectedReceipts []string
+		expectedReasons  []expirationReason
+	}{
+		{
+			name:             "No limits - only explicit expiry dates",
+			policy:           RetentionPolicy{},
+			expectedReceipts: []string{"r3"},
+			expectedReasons:  []expirationReason{EXPIRED},
+		},
+		{
+			name:             "Maximum age",
+			policy:           RetentionPolicy{MaxAge: 90 * 24 * time.Hour},
+			expectedReceipts: []string{"r1", "r3"},
+			expectedReasons:  []expirationReason{EXPIRED, EXPIRED},
+		},
+		{
+			name:             "Maximum number of receipts",
+			policy:           RetentionPolicy{MaxReceipts: 2},
+			expectedReceipts: []string{"r1", "r2", "r3"},
+			expectedReasons:  []expirationReason{MAX_RECEIPTS, MAX_RECEIPTS, EXPIRED},
+		},
+		{
+			name:             "Quota per client",
+			policy:           RetentionPolicy{ClientQuota: 60},
+			expectedReceipts: []string{"r1", "r2", "r3"},
+			expectedReasons:  []expirationReason{QUOTA_EXCEEDED, QUOTA_EXCEEDED, EXPIRED},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expired := tc.policy.selectExpiredReceipts(receipts, now)
+
+			var (
+				ids     []string
+				reasons []expirationReason
+			)
+			for _, e := range expired {
+				ids = append(ids, e.Receipt.ReceiptId)
+				reasons = append(reasons, e.Reason)
+			}
+
+			assert.Equal(t, tc.expectedReceipts, ids)
+			assert.Equal(t, tc.expectedReasons, reasons)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"go.uber.org/zap"
+)
+
+const (
+	FILE_MISSING      = "file_missing"
+	HASH_MISMATCH     = "hash_mismatch"
+	TREE_INCONSISTENT = "tree_inconsistent"
+
+	// the tree of a receipt saved by a previous version of the server
+	// cannot be indexed (see indexLegacyTrees)
+	TREE_UNINDEXABLE = "tree_unindexable"
+)
+
+// ErrScrubInProgress is returned when a scrub is requested while
+// another one is running
+var ErrScrubInProgress = errors.New("a scrub is already in progress")
+
+// ScrubReport summarizes a run of the scrubber
+type ScrubReport struct {
+	StartedAt        time.Time           `json:"started_at"`
+	FinishedAt       time.Time           `json:"finished_at"`
+	ReceiptsScanned  int                 `json:"receipts_scanned"`
+	FilesScanned     int                 `json:"files_scanned"`
+	ReplicasRepaired int                 `json:"replicas_repaired"`
+	Corruptions      []common.Corruption `json:"corruptions"`
+}
+
+// RunScrubber periodically re-verifies the stored files against
+// their Merkle trees until the context is canceled
+func (s *Service) RunScrubber(ctx context.Context) {
+	if s.scrubInterval == 0 {
+		logger.Logger.Info("scrubber disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.scrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Scrub(ctx); err != nil {
+				logger.Logger.Error("scrub failed", zap.Error(err))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Scrub walks all the receipts, re-hashes each stored file, compares it
+// to the hash saved in the database, and recomputes the Merkle trees
+func (s *Service) Scrub(ctx context.Context) (*ScrubReport, error) {
+	s.mu.Lock()
+	if s.scrubbing {
+		s.mu.Unlock()
+		return nil, ErrScrubInProgress
+	}
+	s.scrubbing = true
+	s.mu.Unlock()
+
+	return s.scrub(ctx)
+}
+
+// scrub runs a scrub; the caller has set the scrubbing flag, which is
+// cleared once the scrub is over
+func (s *Service) scrub(ctx context.Context) (*ScrubReport, error) {
+	defer func() {
+		s.mu.Lock()
+		s.scrubbing = false
+		s.mu.Unlock()
+	}()
+
+	receipts, err := s.db.ListReceipts()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ScrubReport{StartedAt: time.Now().UTC()}
+
+	logger.Logger.Info("scrub started", zap.Int("receipts", len(receipts)))
+
+	for _, receipt := range receipts {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		corruptions, filesScanned, repaired, err := s.scrubReceipt(receipt)
+		if err != nil {
+			logger.Logger.Error(
+				"receipt cannot be scrubbed",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if err := s.db.SaveCorruptions(receipt.ReceiptId, corruptions); err != nil {
+			logger.Logger.Error(
+				"corruptions cannot be recorded",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.Error(err),
+			)
+		}
+
+		report.ReceiptsScanned++
+		report.FilesScanned += filesScanned
+		report.ReplicasRepaired += repaired
+		report.Corruptions = append(report.Corruptions, corruptions...)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	metrics.CorruptedFiles.Set(float64(len(report.Corruptions)))
+
+	logger.Logger.Info(
+		"scrub finished",
+		zap.Int("receipts_scanned", report.ReceiptsScanned),
+		zap.Int("files_scanned", report.FilesScanned),
+		zap.Int("replicas_repaired", report.ReplicasRepaired),
+		zap.Int("corruptions", len(report.Corruptions)),
+		zap.Duration("duration", report.FinishedAt.Sub(report.StartedAt)),
+	)
+
+	s.mu.Lock()
+	s.lastScrubReport = report
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// scrubReceipt verifies the files and the tree of a receipt, repairs
+// the damaged replicas and returns the corruptions that could not be
+// repaired, the number of scanned files and the number of repaired replicas
+func (s *Service) scrubReceipt(receipt common.Receipt) ([]common.Corruption, int, int, error) {
+	tree, err := s.db.GetTree(receipt.ReceiptId)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	tree.RootHash = receipt.RootHash
+
+	var corruptions []common.Corruption
+
+	corrupted := func(filename, reason string) {
+		logger.Logger.Warn(
+			"corruption detected",
+			zap.String("receipt_id", receipt.ReceiptId),
+			zap.String("root_hash", receipt.RootHash),
+			zap.String("filename", filename),
+			zap.String("reason", reason),
+		)
+
+		corruptions = append(corruptions, common.Corruption{
+			ReceiptId:      receipt.ReceiptId,
+			Filename:       filename,
+			Reason:         reason,
+			LastDetectedAt: time.Now().UTC(),
+		})
+	}
+
+	var (
+		storageId = helpers.StorageId(receipt.ClientId, receipt.RootHash)
+		repaired  int
+	)
+
+	for filename, expectedHash := range tree.FilenameToHash {
+		n, err := helpers.CheckFile(storageId, filename, expectedHash)
+		switch {
+		case errors.Is(err, helpers.ErrFileMissing):
+			corrupted(filename, FILE_MISSING)
+		case err != nil:
+			corrupted(filename, HASH_MISMATCH)
+		}
+
+		repaired += n
+	}
+
+	if err := proofs.VerifyTree(tree); err != nil {
+		logger.Logger.Debug("tree verification failed", zap.Error(err))
+		corrupted("", TREE_INCONSISTENT)
+	}
+
+	return corruptions, len(tree.FilenameToHash), repaired, nil
+}
+
+// StartScrub runs a scrub in the background
+func (s *Service) StartScrub(ctx context.Context) error {
+	s.mu.Lock()
+	if s.scrubbing {
+		s.mu.Unlock()
+		return ErrScrubInProgress
+	}
+	s.scrubbing = true
+	s.mu.Unlock()
+
+	go func() {
+		if _, err := s.scrub(ctx); err != nil {
+			logger.Logger.Error("scrub failed", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// IsScrubbing returns whether a scrub is in progress
+func (s *Service) IsScrubbing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.scrubbing
+}
+
+// LastScrubReport returns the report of the last scrub
+// (nil if no scrub has run yet)
+func (s *Service) LastScrubReport() *ScrubReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastScrubReport
+}
+
+// ListCorruptions returns the unresolved corruptions recorded
+// by the scrubber
+func (s *Service) ListCorruptions() ([]common.Corruption, error) {
+	return s.db.ListCorruptions()
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"sort"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/metrics"
+	"github.com/google/uuid"
+)
+
+// Connection describes a client connected to the server
+type Connection struct {
+	Id          uuid.UUID       `json:"id"`
+	ClientId    string          `json:"client_id"`
+	RemoteAddr  string          `json:"remote_addr"`
+	ConnectedAt time.Time       `json:"connected_at"`
+	Uploads     []UploadSession `json:"uploads"`
+}
+
+// UploadSession describes a batch of files being received
+type UploadSession struct {
+	RootHash      string    `json:"root_hash"`
+	ExpectedFiles int       `json:"expected_files"`
+	ReceivedFiles int       `json:"received_files"`
+	ReceivedBytes int64     `json:"received_bytes"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+type connection struct {
+	id          uuid.UUID
+	clientId    string
+	remoteAddr  string
+	connectedAt time.Time
+	receiver    *receiver
+}
+
+func (s *Service) addConnection(c *connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connections[c.id] = c
+	metrics.ActiveConnections.Inc()
+}
+
+func (s *Service) deleteConnection(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.connections, id)
+	metrics.ActiveConnections.Dec()
+}
+
+// Connections returns the active connections and their upload sessions
+func (s *Service) Connections() []Connection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	connections := make([]Connection, 0, len(s.connections))
+	for _, c := range s.connections {
+		connections = append(connections, Connection{
+			Id:          c.id,
+			ClientId:    c.clientId,
+			RemoteAddr:  c.remoteAddr,
+			ConnectedAt: c.connectedAt,
+			Uploads:     c.receiver.sessions(),
+		})
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt.Before(connections[j].ConnectedAt)
+	})
+
+	return connections
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ErrShuttingDown is returned to the requests refused while the server
+// is shutting down
+var ErrShuttingDown = errors.New("the server is shutting down")
+
+// Draining returns whether the server is shutting down
+func (s *Service) Draining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.draining
+}
+
+// Shutdown stops accepting new uploads, then waits until the uploads in
+// progress have been received and processed, or until the context is
+// canceled: the batches still incomplete are then abandoned (their
+// clients have to upload them again)
+func (s *Service) Shutdown(ctx context.Context) {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if !s.uploadsInProgress() {
+			logger.Logger.Info("no upload in progress")
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, c := range s.Connections() {
+				for _, u := range c.Uploads {
+					logger.Logger.Warn(
+						"upload abandoned",
+						zap.String("client_id", c.ClientId),
+						zap.String("root_hash", u.RootHash),
+						zap.Int("expected_files", u.ExpectedFiles),
+						zap.Int("received_files", u.ReceivedFiles),
+					)
+				}
+			}
+			return
+		}
+	}
+}
+
+// uploadsInProgress returns whether batches are being received or processed
+func (s *Service) uploadsInProgress() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.connections {
+		if c.receiver.busy() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close closes the database (once the connections are closed)
+func (s *Service) Close() error {
+	return s.db.Close()
+}
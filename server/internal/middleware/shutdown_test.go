@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	logger.Init("")
+
+	// newShuttingDownService returns a service with a connection whose
+	// receiver is receiving a batch
+	newShuttingDownService := func() (*Service, *receiver) {
+		r := newReceiver(nil, "alice", RetentionPolicy{})
+		r.prepareToReceiveFiles("batch", []string{"a"}, 1)
+
+		s := &Service{connections: make(map[uuid.UUID]*connection)}
+		s.connections[uuid.New()] = &connection{id: uuid.New(), clientId: "alice", receiver: r}
+
+		return s, r
+	}
+
+	t.Run("No upload in progress", func(t *testing.T) {
+		s := &Service{connections: make(map[uuid.UUID]*connection)}
+		assert.False(t, s.Draining())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		s.Shutdown(ctx)
+		assert.True(t, s.Draining())
+		assert.NoError(t, ctx.Err())
+	})
+
+	t.Run("Upload finished", func(t *testing.T) {
+		s, r := newShuttingDownService()
+
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			r.abandon()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		s.Shutdown(ctx)
+		assert.True(t, s.Draining())
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.NoError(t, ctx.Err())
+	})
+
+	t.Run("Upload abandoned at the deadline", func(t *testing.T) {
+		s, r := newShuttingDownService()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		s.Shutdown(ctx)
+		assert.True(t, s.Draining())
+		assert.Error(t, ctx.Err())
+		assert.True(t, r.busy())
+	})
+}
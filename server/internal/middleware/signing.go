@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
+)
+
+// proofKeyContext separates the key signing the proofs from the HMAC
+// key of the receipt bundles: both derive from the bundle signing key
+const proofKeyContext = "fvs proof signing key v1\x00"
+
+// newProofKey derives the Ed25519 key signing the proofs sent along
+// with the downloaded files from the bundle signing key (nil: the
+// proofs are not signed)
+func newProofKey(signingKey string) ed25519.PrivateKey {
+	if signingKey == "" {
+		return nil
+	}
+
+	seed := sha256.Sum256([]byte(proofKeyContext + signingKey))
+	return ed25519.NewKeyFromSeed(seed[:])
+}
+
+// ProofPublicKey returns the public key verifying the signatures of the
+// proofs (nil if the proofs are not signed)
+func (s *Service) ProofPublicKey() ed25519.PublicKey {
+	if s.proofKey == nil {
+		return nil
+	}
+
+	return s.proofKey.Public().(ed25519.PublicKey)
+}
+
+// signProof returns the signature of the proof of a file, over the
+// payload of its proof bundle, and the ID of the key (nil and empty if
+// the proofs are not signed)
+func (s *Service) signProof(
+	receiptId, filename, fileHash, rootHash string,
+	proof []libproofs.ProofPart,
+) ([]byte, string) {
+	if s.proofKey == nil {
+		return nil, ""
+	}
+
+	bundle := &libproofs.Bundle{
+		Version:       libproofs.BundleVersion,
+		HashAlgorithm: "sha512",
+		TreeVersion:   libproofs.TreeVersion,
+		ReceiptId:     receiptId,
+		Filename:      filename,
+		LeafHash:      fileHash,
+		Siblings:      libproofs.SiblingsFromProof(proof),
+		RootHash:      rootHash,
+	}
+
+	return ed25519.Sign(s.proofKey, libproofs.SignedPayload(bundle)),
+		libproofs.KeyId(s.ProofPublicKey())
+}
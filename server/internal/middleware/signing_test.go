@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	libproofs "github.com/glethuillier/fvs/lib/pkg/proofs"
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignProof(t *testing.T) {
+	logger.Init("")
+
+	files := []*common.File{
+		{Filename: "a.txt", Contents: []byte("a")},
+		{Filename: "b.txt", Contents: []byte("bb")},
+		{Filename: "c.txt", Contents: []byte("ccc")},
+	}
+
+	tree, err := proofs.BuildMerkleTree(files)
+	assert.NoError(t, err)
+
+	receipt := &common.Receipt{
+		ReceiptId: "5f0e0d8e-3c1f-4a1c-9b51-2f1b1f6f7a10",
+		ClientId:  "alice",
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// download returns the file sent to the client, and the bundle the
+	// client builds from it
+	download := func(s *Service) (*common.File, *libproofs.Bundle) {
+		for _, f := range files {
+			assert.NoError(t, helpers.SaveFile(helpers.StorageId("alice", tree.RootHash), f.Filename, f.Contents))
+		}
+		assert.NoError(t, s.db.SaveTree(receipt, tree))
+
+		response := s.processDownloadRequest("alice", common.DownloadRequest{
+			MessageId: uuid.New(),
+			RootHash:  receipt.ReceiptId,
+			Filename:  "b.txt",
+		})
+		file, ok := response.(*common.File)
+		assert.True(t, ok)
+
+		digest := sha512.Sum512(file.Contents)
+		return file, &libproofs.Bundle{
+			Version:       libproofs.BundleVersion,
+			HashAlgorithm: "sha512",
+			TreeVersion:   libproofs.TreeVersion,
+			ReceiptId:     receipt.ReceiptId,
+			Filename:      file.Filename,
+			LeafHash:      hex.EncodeToString(digest[:]),
+			Siblings:      libproofs.SiblingsFromProof(file.Proof),
+			RootHash:      tree.RootHash,
+		}
+	}
+
+	t.Run("Signed", func(t *testing.T) {
+		s := newTestService(t, "s3cr3t")
+		file, bundle := download(s)
+
+		key := s.ProofPublicKey()
+		assert.Equal(t, libproofs.KeyId(key), file.SignatureKeyId)
+
+		bundle.Signature = &libproofs.Signature{
+			Algorithm: libproofs.SignatureAlgorithm,
+			KeyId:     file.SignatureKeyId,
+			Value:     base64.StdEncoding.EncodeToString(file.Signature),
+		}
+		assert.NoError(t, libproofs.VerifySignature(bundle, key))
+
+		// the key is stable across restarts, and differs between
+		// signing keys
+		assert.Equal(t, key, newTestService(t, "s3cr3t").ProofPublicKey())
+		assert.ErrorIs(t, libproofs.VerifySignature(bundle, newTestService(t, "other").ProofPublicKey()), libproofs.ErrInvalidProof)
+
+		bundle.Filename = "c.txt"
+		assert.ErrorIs(t, libproofs.VerifySignature(bundle, key), libproofs.ErrInvalidProof)
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		s := newTestService(t, "")
+		file, _ := download(s)
+
+		assert.Nil(t, s.ProofPublicKey())
+		assert.Nil(t, file.Signature)
+		assert.Empty(t, file.SignatureKeyId)
+	})
+}
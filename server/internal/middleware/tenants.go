@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// AssignLegacyReceipts assigns receipts of anonymous clients (e.g.,
+// uploaded before the tokens were required) to a client, so that the
+// client can access them with its token: their files are moved to the
+// directory of the client. Only the given receipts are assigned, or all
+// of them if none is given; the receipts assigned are returned.
+func (s *Service) AssignLegacyReceipts(clientId string, receiptIds []string, actor string) ([]common.Receipt, error) {
+	if !common.ClientIdPattern.MatchString(clientId) {
+		return nil, fmt.Errorf("invalid client ID %q", clientId)
+	}
+
+	receipts, err := s.db.ListAnonymousReceipts()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(receiptIds) > 0 {
+		var selected []common.Receipt
+		for _, receiptId := range receiptIds {
+			i := slices.IndexFunc(receipts, func(r common.Receipt) bool { return r.ReceiptId == receiptId })
+			if i < 0 {
+				return nil, fmt.Errorf("receipt_id %s not found or already assigned to a client", receiptId)
+			}
+			selected = append(selected, receipts[i])
+		}
+		receipts = selected
+	}
+
+	var assigned []common.Receipt
+	for _, receipt := range receipts {
+		if err := s.assignReceipt(receipt, clientId, actor); err != nil {
+			return assigned, fmt.Errorf("receipt %s cannot be assigned: %w", receipt.ReceiptId, err)
+		}
+
+		receipt.ClientId = clientId
+		assigned = append(assigned, receipt)
+	}
+
+	return assigned, nil
+}
+
+// assignReceipt moves the files of a receipt of an anonymous client to
+// the directory of a client, then assigns the receipt to the client (the
+// files are moved back if the receipt cannot be assigned)
+func (s *Service) assignReceipt(receipt common.Receipt, clientId, actor string) error {
+	from := helpers.StorageId("", receipt.RootHash)
+	to := helpers.StorageId(clientId, receipt.RootHash)
+
+	if err := helpers.MoveFiles(from, to); err != nil {
+		return err
+	}
+
+	if err := s.db.AssignReceipt(receipt.ReceiptId, clientId); err != nil {
+		if moveErr := helpers.MoveFiles(to, from); moveErr != nil {
+			logger.Logger.Error(
+				"the files of a receipt cannot be moved back",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.String("storage_id", to),
+				zap.Error(moveErr),
+			)
+		}
+		return err
+	}
+	s.cache.invalidate(receipt.ReceiptId)
+
+	logger.Logger.Info(
+		"receipt assigned",
+		zap.String("receipt_id", receipt.ReceiptId),
+		zap.String("client_id", clientId),
+		zap.String("actor", actor),
+	)
+
+	s.audit(common.AuditEvent{
+		Action:    common.AuditReceiptAssigned,
+		Actor:     actor,
+		ClientId:  clientId,
+		ReceiptId: receipt.ReceiptId,
+		Details: map[string]string{
+			"root_hash": receipt.RootHash,
+		},
+	})
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/helpers"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignLegacyReceipts(t *testing.T) {
+	logger.Init("")
+
+	s := newTestService(t, "")
+
+	// two batches uploaded by anonymous clients, and one by alice
+	trees := make(map[string]*common.Tree)
+	for receiptId, clientId := range map[string]string{
+		"11111111-1111-4111-8111-111111111111": "",
+		"22222222-2222-4222-8222-222222222222": "",
+		"33333333-3333-4333-8333-333333333333": "alice",
+	} {
+		files := []*common.File{
+			{Filename: "a.txt", Contents: []byte(receiptId)},
+			{Filename: "b.txt", Contents: []byte("b")},
+		}
+
+		tree, err := proofs.BuildMerkleTree(files)
+		assert.NoError(t, err)
+		trees[receiptId] = tree
+
+		for _, f := range files {
+			assert.NoError(t, helpers.SaveFile(helpers.StorageId(clientId, tree.RootHash), f.Filename, f.Contents))
+		}
+		assert.NoError(t, s.db.SaveTree(&common.Receipt{
+			ReceiptId: receiptId,
+			ClientId:  clientId,
+			CreatedAt: time.Now().UTC(),
+		}, tree))
+	}
+
+	// assertAssigned checks that alice can access a receipt and its files
+	assertAssigned := func(receiptId string) {
+		tree := trees[receiptId]
+
+		rootHash, err := s.getRootHash("alice", receiptId)
+		assert.NoError(t, err)
+		assert.Equal(t, tree.RootHash, rootHash)
+
+		_, err = s.getRootHash("", receiptId)
+		assert.Error(t, err)
+
+		contents, err := helpers.GetFile(helpers.StorageId("alice", tree.RootHash), "a.txt", tree.FilenameToHash["a.txt"])
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(receiptId), contents)
+
+		_, err = helpers.GetFile(helpers.StorageId("", tree.RootHash), "a.txt", tree.FilenameToHash["a.txt"])
+		assert.ErrorIs(t, err, helpers.ErrFileMissing)
+	}
+
+	_, err := s.AssignLegacyReceipts("../alice", nil, "test")
+	assert.Error(t, err)
+
+	_, err = s.AssignLegacyReceipts("bob", []string{"33333333-3333-4333-8333-333333333333"}, "test")
+	assert.Error(t, err)
+
+	// the anonymous receipt is still accessible before its assignment
+	_, err = s.getRootHash("", "11111111-1111-4111-8111-111111111111")
+	assert.NoError(t, err)
+
+	assigned, err := s.AssignLegacyReceipts("alice", []string{"11111111-1111-4111-8111-111111111111"}, "test")
+	assert.NoError(t, err)
+	assert.Len(t, assigned, 1)
+	assert.Equal(t, "alice", assigned[0].ClientId)
+	assertAssigned("11111111-1111-4111-8111-111111111111")
+
+	assigned, err = s.AssignLegacyReceipts("alice", nil, "test")
+	assert.NoError(t, err)
+	assert.Len(t, assigned, 1)
+	assert.Equal(t, "22222222-2222-4222-8222-222222222222", assigned[0].ReceiptId)
+	assertAssigned("22222222-2222-4222-8222-222222222222")
+
+	assigned, err = s.AssignLegacyReceipts("alice", nil, "test")
+	assert.NoError(t, err)
+	assert.Empty(t, assigned)
+
+	events, err := s.ListAuditEvents(database.AuditFilter{Action: common.AuditReceiptAssigned})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+}
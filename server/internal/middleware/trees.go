@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/database"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"go.uber.org/zap"
+)
+
+// indexLegacyTrees stores level by level the trees saved by the previous
+// versions of the server: as a tree is deterministic, its levels are
+// recomputed from the hashes of its files and checked against the root
+// hash of the receipt. The trees that cannot be recomputed are recorded
+// as corrupted (see TREE_UNINDEXABLE), so that they are skipped on the
+// next runs; only the errors of the database are returned.
+func indexLegacyTrees(db *database.Database) error {
+	receipts, err := db.ListUnindexedReceipts()
+	if err != nil {
+		return err
+	}
+
+	var indexed, unindexable int
+	for _, receipt := range receipts {
+		files, err := db.GetFiles(receipt.ReceiptId)
+		if err != nil {
+			return err
+		}
+
+		var leaves []string
+		for _, f := range files {
+			leaves = append(leaves, f.Hash)
+		}
+
+		levels, err := proofs.BuildLevels(leaves)
+		if err != nil {
+			logger.Logger.Error(
+				"the tree cannot be indexed",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.Error(err),
+			)
+		} else if root := levels[len(levels)-1][0]; root != receipt.RootHash {
+			logger.Logger.Error(
+				"the tree cannot be indexed: the files do not lead to the root hash",
+				zap.String("receipt_id", receipt.ReceiptId),
+				zap.String("root_hash", receipt.RootHash),
+				zap.String("computed_root_hash", root),
+			)
+			err = errMismatchingRoot
+		}
+
+		if err != nil {
+			// the receipt is kept as is (its files cannot be downloaded)
+			unindexable++
+			if err = markUnindexable(db, receipt.ReceiptId); err != nil {
+				return fmt.Errorf("receipt %s: %w", receipt.ReceiptId, err)
+			}
+			continue
+		}
+
+		if err = db.IndexTree(receipt.ReceiptId, levels); err != nil {
+			return fmt.Errorf("receipt %s: %w", receipt.ReceiptId, err)
+		}
+		indexed++
+	}
+
+	if len(receipts) > 0 {
+		logger.Logger.Info(
+			"legacy trees indexed",
+			zap.Int("receipts", indexed),
+			zap.Int("unindexable", unindexable),
+		)
+	}
+
+	return nil
+}
+
+// errMismatchingRoot is reported when the files of a legacy tree do not
+// lead to the root hash of its receipt
+var errMismatchingRoot = errors.New("the files do not lead to the root hash")
+
+// markUnindexable records that the tree of a receipt cannot be indexed
+func markUnindexable(db *database.Database, receiptId string) error {
+	return db.SaveCorruptions(receiptId, []common.Corruption{{
+		ReceiptId:      receiptId,
+		Reason:         TREE_UNINDEXABLE,
+		LastDetectedAt: time.Now().UTC(),
+	}})
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/proofs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexLegacyTrees(t *testing.T) {
+	logger.Init("")
+
+	s := newTestService(t, "")
+
+	for _, receiptId := range []string{"receipt-ok", "receipt-mismatch", "receipt-empty"} {
+		tree, err := proofs.BuildMerkleTree([]*common.File{
+			{Filename: "a.txt", Contents: []byte(receiptId)},
+			{Filename: "b.txt", Contents: []byte("b")},
+		})
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.db.SaveTree(&common.Receipt{
+			ReceiptId: receiptId,
+			ClientId:  "alice",
+			CreatedAt: time.Now().UTC(),
+		}, tree))
+	}
+
+	// trees saved by a previous version of the server, one of which no
+	// longer matches its root hash, and another without any file
+	for _, query := range []string{
+		"DELETE FROM TREE_NODES",
+		"UPDATE FILES SET leaf_index = NULL",
+		"UPDATE FILES SET self_hash = 'ff' WHERE filename = 'a.txt' AND root_hash_id = (SELECT root_hash_id FROM RECEIPTS WHERE receipt_id = 'receipt-mismatch')",
+		"DELETE FROM FILES WHERE root_hash_id = (SELECT root_hash_id FROM RECEIPTS WHERE receipt_id = 'receipt-empty')",
+	} {
+		_, err := s.db.Exec(query)
+		assert.NoError(t, err)
+	}
+
+	// the trees that cannot be indexed are recorded, and skipped on the
+	// next runs
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, indexLegacyTrees(s.db))
+
+		receipts, err := s.db.ListUnindexedReceipts()
+		assert.NoError(t, err)
+		assert.Empty(t, receipts)
+
+		corruptions, err := s.ListCorruptions()
+		assert.NoError(t, err)
+		assert.Len(t, corruptions, 2)
+		for _, c := range corruptions {
+			assert.Contains(t, []string{"receipt-mismatch", "receipt-empty"}, c.ReceiptId)
+			assert.Empty(t, c.Filename)
+			assert.Equal(t, TREE_UNINDEXABLE, c.Reason)
+		}
+	}
+
+	_, proof, err := s.GetProof("receipt-ok", "a.txt")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, proof)
+}
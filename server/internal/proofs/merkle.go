@@ -14,7 +14,7 @@ import (
 func BuildMerkleTree(files []*common.File) (*common.Tree, error) {
 	tree := common.Tree{
 		FilenameToHash: make(map[string]string),
-		Nodes:          make(map[string]common.Node),
+		FilenameToSize: make(map[string]int64),
 	}
 
 	// NOTE: should be configurable
@@ -28,66 +28,93 @@ func BuildMerkleTree(files []*common.File) (*common.Tree, error) {
 			return nil, err
 		}
 		tree.FilenameToHash[f.Filename] = h
+		tree.FilenameToSize[f.Filename] = int64(len(f.Contents))
 		leaves = append(leaves, h)
 	}
 
+	levels, err := BuildLevels(leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Levels = levels
+	tree.Nodes = NodesFromLevels(levels)
+	tree.RootHash = levels[len(levels)-1][0]
+
+	return &tree, nil
+}
+
+// BuildLevels computes the levels of the Merkle tree whose leaves are
+// given: the first level contains the sorted leaves, padded to a power
+// of 2, and the last one the root hash
+func BuildLevels(leaves []string) ([][]string, error) {
+	// NOTE: should be configurable (see BuildMerkleTree)
+	hashAlgorithm := sha512.New()
+
 	// leaves nodes must be sorted to make the tree deterministic
-	sort.Slice(leaves, func(i, j int) bool {
-		return leaves[i] < leaves[j]
-	})
+	level := append([]string(nil), leaves...)
+	sort.Strings(level)
 
 	// ensure that the number of leaves is a power of 2
 	for {
-		log2 := math.Log2(float64(len(leaves)))
-		if len(leaves) > 1 && log2 == float64(int(log2)) {
+		log2 := math.Log2(float64(len(level)))
+		if len(level) > 1 && log2 == float64(int(log2)) {
 			break
 		}
 
-		leaves = append(leaves, emptyHash(hashAlgorithm))
+		level = append(level, emptyHash(hashAlgorithm))
 	}
 
-	level := leaves
+	levels := [][]string{level}
 
 	// compute root hash
-	for {
-		var nextLevel []string
+	for len(level) > 1 {
+		// NOTE: this approach is valid only because the tree
+		// will be a perfect binary tree (as a consequence, the
+		// number of nodes is necessary even for each level,
+		// except for the last one, naturally).
+		nextLevel := make([]string, 0, len(level)/2)
 		for i := 0; i < len(level); i += 2 {
-			// NOTE: this approach is valid only because the tree
-			// will be a perfect binary tree (as a consequence, the
-			// number of nodes is necessary even for each level,
-			// except for the last one, naturally).
-
-			left := level[i]
-			right := level[i+1]
-
-			h, err := hashConcat(hashAlgorithm, left, right)
+			h, err := hashConcat(hashAlgorithm, level[i], level[i+1])
 			if err != nil {
 				return nil, err
 			}
 
-			tree.Nodes[right] = common.Node{
-				Parent:      h,
+			nextLevel = append(nextLevel, h)
+		}
+
+		level = nextLevel
+		levels = append(levels, level)
+	}
+
+	return levels, nil
+}
+
+// NodesFromLevels returns the parent and the sibling of each node of
+// a tree given level by level
+func NodesFromLevels(levels [][]string) map[string]common.Node {
+	nodes := make(map[string]common.Node)
+
+	for l, level := range levels[:len(levels)-1] {
+		for i := 0; i < len(level); i += 2 {
+			left, right := level[i], level[i+1]
+			parent := levels[l+1][i/2]
+
+			nodes[right] = common.Node{
+				Parent:      parent,
 				SiblingType: proofs.LeftSibling,
 				Sibling:     left,
 			}
 
-			tree.Nodes[left] = common.Node{
-				Parent:      h,
+			nodes[left] = common.Node{
+				Parent:      parent,
 				SiblingType: proofs.RightSibling,
 				Sibling:     right,
 			}
-
-			nextLevel = append(nextLevel, tree.Nodes[left].Parent)
-		}
-
-		level = nextLevel
-		if len(level) == 1 {
-			tree.Nodes[level[0]] = common.Node{}
-			break
 		}
 	}
 
-	tree.RootHash = level[0]
+	nodes[levels[len(levels)-1][0]] = common.Node{}
 
-	return &tree, nil
+	return nodes
 }
@@ -0,0 +1,85 @@
+package proofs
+
+import (
+	"crypto/sha512"
+	"fmt"
+
+	"github.com/glethuillier/fvs/lib/pkg/proofs"
+	"github.com/glethuillier/fvs/server/internal/common"
+)
+
+// HashFile returns the hash of a file, as stored in the Merkle tree
+func HashFile(file *common.File) (string, error) {
+	// NOTE: should be configurable (see BuildMerkleTree)
+	return hashLeaf(sha512.New(), file)
+}
+
+// VerifyTree recomputes every parent of a Merkle tree from its children
+// and ensures that each file leads to the root hash
+func VerifyTree(tree *common.Tree) error {
+	hashAlgorithm := sha512.New()
+
+	for self, node := range tree.Nodes {
+		var (
+			parent string
+			err    error
+		)
+
+		switch node.SiblingType {
+		case proofs.NoSibling:
+			if self != tree.RootHash {
+				return fmt.Errorf("node %s has no sibling but is not the root", self)
+			}
+			continue
+
+		case proofs.LeftSibling:
+			parent, err = hashConcat(hashAlgorithm, node.Sibling, self)
+
+		case proofs.RightSibling:
+			parent, err = hashConcat(hashAlgorithm, self, node.Sibling)
+
+		default:
+			return fmt.Errorf("node %s has an unknown sibling type", self)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if parent != node.Parent {
+			return fmt.Errorf(
+				"node %s: (expected) parent %s != %s (actual)",
+				self,
+				node.Parent,
+				parent,
+			)
+		}
+	}
+
+	// every file must lead to the root
+	for filename, current := range tree.FilenameToHash {
+		for depth := 0; ; depth++ {
+			node, ok := tree.Nodes[current]
+			if !ok {
+				return fmt.Errorf("file %s: node %s not found in tree", filename, current)
+			}
+
+			if node.SiblingType == proofs.NoSibling {
+				break
+			}
+
+			// a path cannot be longer than the number of nodes
+			if depth > len(tree.Nodes) {
+				return fmt.Errorf("file %s: cycle detected in tree", filename)
+			}
+
+			current = node.Parent
+		}
+
+		if current != tree.RootHash {
+			return fmt.Errorf("file %s does not lead to the root hash", filename)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package proofs
+
+import (
+	"testing"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyTree(t *testing.T) {
+	files := []*common.File{
+		{Filename: "a.txt", Contents: []byte("a")},
+		{Filename: "b.txt", Contents: []byte("b")},
+		{Filename: "c.txt", Contents: []byte("c")},
+		{Filename: "d.txt", Contents: []byte("d")},
+		{Filename: "e.txt", Contents: []byte("e")},
+	}
+
+	tests := []struct {
+		name          string
+		tamper        func(tree *common.Tree)
+		expectedError bool
+	}{
+		{
+			name:   "Positive test - untouched tree",
+			tamper: func(tree *common.Tree) {},
+		},
+		{
+			name: "Negative test - wrong parent",
+			tamper: func(tree *common.Tree) {
+				h := tree.FilenameToHash["a.txt"]
+				node := tree.Nodes[h]
+				node.Parent = tree.FilenameToHash["b.txt"]
+				tree.Nodes[h] = node
+			},
+			expectedError: true,
+		},
+		{
+			name: "Negative test - wrong root hash",
+			tamper: func(tree *common.Tree) {
+				tree.RootHash = tree.FilenameToHash["c.txt"]
+			},
+			expectedError: true,
+		},
+		{
+			name: "Negative test - wrong file hash",
+			tamper: func(tree *common.Tree) {
+				tree.FilenameToHash["d.txt"] = "00"
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := BuildMerkleTree(files)
+			assert.NoError(t, err)
+
+			tc.tamper(tree)
+
+			err = VerifyTree(tree)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
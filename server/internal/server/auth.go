@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated client")
+
+// authenticator identifies the clients connecting to the server, either
+// with a bearer token or with a verified TLS client certificate
+type authenticator struct {
+	// client ID by SHA-256 digest of the token (digests have a fixed
+	// length, which allows comparing them in constant time)
+	tokens map[[sha256.Size]byte]string
+
+	// accept the clients that present no credentials (their identity
+	// is empty)
+	allowAnonymous bool
+}
+
+// newAuthenticator reads the client credentials of the configuration
+// (`client-id:token` pairs, and a file of one pair per line); unless
+// anonymous clients are allowed, at least one token is required
+func newAuthenticator(cfg config.AuthConfig) (*authenticator, error) {
+	a := &authenticator{
+		tokens:         make(map[[sha256.Size]byte]string),
+		allowAnonymous: cfg.AllowAnonymous,
+	}
+
+	for _, pair := range cfg.ClientTokens {
+		if err := a.addToken(pair); err != nil {
+			return nil, fmt.Errorf("invalid client token: %w", err)
+		}
+	}
+
+	if cfg.ClientTokensFile != "" {
+		if err := a.loadTokens(cfg.ClientTokensFile); err != nil {
+			return nil, fmt.Errorf("invalid client tokens file %s: %w", cfg.ClientTokensFile, err)
+		}
+	}
+
+	if len(a.tokens) == 0 && !a.allowAnonymous {
+		return nil, fmt.Errorf(
+			"no client credentials configured (set auth.client_tokens, " +
+				"auth.client_tokens_file or auth.allow_anonymous)",
+		)
+	}
+
+	return a, nil
+}
+
+func (a *authenticator) addToken(pair string) error {
+	clientId, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+	if !ok || clientId == "" || token == "" {
+		return fmt.Errorf("expected `client-id:token`")
+	}
+
+	if !common.ClientIdPattern.MatchString(clientId) {
+		return fmt.Errorf("invalid client ID %q", clientId)
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	if _, ok := a.tokens[digest]; ok {
+		return fmt.Errorf("token of %s already assigned to another client", clientId)
+	}
+
+	a.tokens[digest] = clientId
+	return nil
+}
+
+func (a *authenticator) loadTokens(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		pair := strings.TrimSpace(scanner.Text())
+		if pair == "" || strings.HasPrefix(pair, "#") {
+			continue
+		}
+
+		if err := a.addToken(pair); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// authenticate returns the identity of the client sending a request:
+// the client ID associated with its bearer token or, failing that, the
+// common name of its verified TLS client certificate
+func (a *authenticator) authenticate(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return "", ErrUnauthenticated
+		}
+
+		digest := sha256.Sum256([]byte(token))
+
+		// compare with every known token so that the time taken does not
+		// depend on which token (if any) matches
+		var clientId string
+		for known, id := range a.tokens {
+			if subtle.ConstantTimeCompare(digest[:], known[:]) == 1 {
+				clientId = id
+			}
+		}
+
+		if clientId == "" {
+			return "", ErrUnauthenticated
+		}
+
+		return clientId, nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; common.ClientIdPattern.MatchString(cn) {
+			return cn, nil
+		}
+	}
+
+	if a.allowAnonymous {
+		return "", nil
+	}
+
+	return "", ErrUnauthenticated
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowAnonymous   bool
+		authorization    string
+		expectedClientId string
+		expectedErr      error
+	}{
+		{
+			name:             "Known token",
+			authorization:    "Bearer token-a",
+			expectedClientId: "client-a",
+		},
+		{
+			name:          "Unknown token",
+			authorization: "Bearer token-c",
+			expectedErr:   ErrUnauthenticated,
+		},
+		{
+			name:          "Not a bearer token",
+			authorization: "Basic token-a",
+			expectedErr:   ErrUnauthenticated,
+		},
+		{
+			name:        "No credentials",
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			name:             "No credentials - anonymous clients allowed",
+			allowAnonymous:   true,
+			expectedClientId: "",
+		},
+		{
+			name:           "Unknown token - anonymous clients allowed",
+			allowAnonymous: true,
+			authorization:  "Bearer token-c",
+			expectedErr:    ErrUnauthenticated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &authenticator{
+				tokens:         make(map[[sha256.Size]byte]string),
+				allowAnonymous: tc.allowAnonymous,
+			}
+			assert.NoError(t, a.addToken("client-a:token-a"))
+			assert.NoError(t, a.addToken(" client-b:token-b "))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			clientId, err := a.authenticate(r)
+			assert.Equal(t, tc.expectedErr, err)
+			assert.Equal(t, tc.expectedClientId, clientId)
+		})
+	}
+}
+
+func TestAddToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		pair        string
+		expectedErr bool
+	}{
+		{name: "Valid pair", pair: "client-a@example.com:token"},
+		{name: "Missing token", pair: "client-a:", expectedErr: true},
+		{name: "Missing separator", pair: "client-a", expectedErr: true},
+		{name: "Path in the client ID", pair: "../client-a:token", expectedErr: true},
+		{name: "Slash in the client ID", pair: "tenants/client-a:token", expectedErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &authenticator{tokens: make(map[[sha256.Size]byte]string)}
+			err := a.addToken(tc.pair)
+			assert.Equal(t, tc.expectedErr, err != nil)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"fmt"
+
 	"github.com/glethuillier/fvs/lib/pkg/messages"
 	"github.com/glethuillier/fvs/server/internal/common"
 	"github.com/glethuillier/fvs/server/internal/logger"
@@ -9,21 +11,20 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-// processIncomingMessage Protobuf deserializes the messages coming
-// from the client
-func processIncomingMessage(
-	msg []byte,
-	requestsC chan interface{},
-) error {
+// processIncomingMessage Protobuf deserializes and validates the messages
+// coming from the client: the requests whose contents are invalid are
+// returned with their error (so that they are answered), and the messages
+// that cannot be attributed to a request return ErrMalformedMessage
+func processIncomingMessage(msg []byte, limits messageLimits) (common.Request, error) {
 	var wrapperMsg messages.WrapperMessage
 	err := proto.Unmarshal(msg, &wrapperMsg)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
 	}
 
 	requestId, err := uuid.Parse(wrapperMsg.MessageId)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: invalid message ID", ErrMalformedMessage)
 	}
 
 	switch wrapperMsg.Type {
@@ -32,7 +33,7 @@ func processIncomingMessage(
 		var preflight messages.TransferPreflight
 		err = proto.Unmarshal(wrapperMsg.Payload, &preflight)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
 		}
 
 		logger.Logger.Debug(
@@ -40,18 +41,20 @@ func processIncomingMessage(
 			zap.Any("filenames", preflight.Filenames),
 		)
 
-		requestsC <- common.TransferRequest{
+		return common.TransferRequest{
 			MessageId: requestId,
 			RootHash:  wrapperMsg.RootHash,
 			Filenames: preflight.Filenames,
-		}
+			Sizes:     preflight.Sizes,
+			Error:     validatePreflight(wrapperMsg.RootHash, &preflight, limits),
+		}, nil
 
 	// receive file
 	case messages.MessageType_TRANSFER_FILE:
 		var receivedFile messages.TransferFile
 		err = proto.Unmarshal(wrapperMsg.Payload, &receivedFile)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
 		}
 
 		logger.Logger.Debug(
@@ -59,19 +62,20 @@ func processIncomingMessage(
 			zap.String("filename", receivedFile.Filename),
 		)
 
-		requestsC <- &common.File{
+		return &common.File{
 			MessageId: requestId,
 			RootHash:  wrapperMsg.RootHash,
 			Filename:  receivedFile.Filename,
 			Contents:  receivedFile.Contents,
-		}
+			Error:     validateFile(wrapperMsg.RootHash, &receivedFile),
+		}, nil
 
 	// send file
 	case messages.MessageType_DOWNLOAD_REQUEST:
 		var request messages.DownloadRequest
 		err = proto.Unmarshal(wrapperMsg.Payload, &request)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
 		}
 
 		logger.Logger.Debug(
@@ -80,17 +84,13 @@ func processIncomingMessage(
 			zap.String("root_hash", request.RootHash),
 		)
 
-		requestsC <- common.DownloadRequest{
+		return common.DownloadRequest{
 			MessageId: requestId,
 			RootHash:  request.RootHash,
 			Filename:  request.Filename,
-		}
-
-	default:
-		logger.Logger.Error(
-			"message from client cannot be deserialized (invalid type)",
-		)
+			Error:     validateDownloadRequest(&request),
+		}, nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("%w: unexpected message type %s", ErrMalformedMessage, wrapperMsg.Type)
 }
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/glethuillier/fvs/lib/pkg/messages"
 	"github.com/glethuillier/fvs/lib/pkg/proofs"
 	"github.com/glethuillier/fvs/server/internal/common"
@@ -11,7 +14,7 @@ import (
 
 // prepareOutgoingMessage Protobuf serializes the messages to be
 // sent to the client
-func prepareOutgoingMessage(response interface{}) ([]byte, error) {
+func prepareOutgoingMessage(response common.Response) ([]byte, error) {
 	var ack []byte
 	var err error
 
@@ -32,6 +35,7 @@ func prepareOutgoingMessage(response interface{}) ([]byte, error) {
 				StringOrArray: &messages.TransferAck_Error{
 					Error: r.Error.Error(),
 				},
+				QuotaError: encodeQuotaError(r.Error),
 			})
 			if err != nil {
 				return nil, err
@@ -55,9 +59,11 @@ func prepareOutgoingMessage(response interface{}) ([]byte, error) {
 	// send file
 	case *common.File:
 		response, err := proto.Marshal(&messages.TransferFile{
-			Filename: r.Filename,
-			Contents: r.Contents,
-			Proof:    encodeProof(r.Proof),
+			Filename:       r.Filename,
+			Contents:       r.Contents,
+			Proof:          encodeProof(r.Proof),
+			Signature:      r.Signature,
+			SignatureKeyId: r.SignatureKeyId,
 		})
 		if err != nil {
 			return nil, err
@@ -77,11 +83,26 @@ func prepareOutgoingMessage(response interface{}) ([]byte, error) {
 
 		return data, nil
 
+	// flow control
+	case common.FlowControl:
+		response, err := proto.Marshal(&messages.FlowControl{
+			Paused: r.Paused,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return proto.Marshal(&messages.WrapperMessage{
+			Type:    messages.MessageType_FLOW_CONTROL,
+			Payload: response,
+		})
+
 	// error
 	case common.ErrorResponse:
 		serverErr := r.Error.Error()
 		response, err := proto.Marshal(&messages.TransferFile{
-			Error: &serverErr,
+			Error:      &serverErr,
+			QuotaError: encodeQuotaError(r.Error),
 		})
 		if err != nil {
 			return nil, err
@@ -102,7 +123,23 @@ func prepareOutgoingMessage(response interface{}) ([]byte, error) {
 		return data, nil
 	}
 
-	return nil, nil
+	return nil, fmt.Errorf("unknown response type %T", response)
+}
+
+// encodeQuotaError Protobuf serializes the details of an exceeded quota
+// (nil if the error is not a quota error)
+func encodeQuotaError(err error) *messages.QuotaError {
+	var quotaErr *common.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return nil
+	}
+
+	return &messages.QuotaError{
+		Limit:        quotaErr.Limit,
+		Max:          quotaErr.Max,
+		Requested:    quotaErr.Requested,
+		RetryAfterMs: quotaErr.RetryAfter.Milliseconds(),
+	}
 }
 
 // encodeProof Protobuf serializes the proof
@@ -2,12 +2,17 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/config"
 	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/glethuillier/fvs/server/internal/metrics"
 	"github.com/glethuillier/fvs/server/internal/middleware"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -18,68 +23,237 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 4096,
 }
 
+// closeTimeout is how long the server waits for a client to acknowledge
+// the closing of its connection
+const closeTimeout = 2 * time.Second
+
+// maxCloseReasonBytes is the maximum length of the reason of a close
+// frame (whose payload is limited to 125 bytes, including the code)
+const maxCloseReasonBytes = 123
+
 type Client struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn   *websocket.Conn
+	limits messageLimits
 }
 
 // HandleConnections handles the connections between the server and the client
-func HandleConnections(ctx context.Context, service *middleware.Service) http.HandlerFunc {
+func HandleConnections(
+	ctx context.Context,
+	service *middleware.Service,
+	auth *authenticator,
+	queueSize int,
+	limits messageLimits,
+	connections *sync.WaitGroup,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if service.Draining() {
+			http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		// the client is authenticated before the connection is upgraded
+		clientId, err := auth.authenticate(r)
+		if err != nil {
+			logger.Logger.Warn(
+				"unauthenticated connection rejected",
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+			metrics.RejectedConnections.Inc()
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			logger.Logger.Fatal(
+			// the upgrader has already replied to the client
+			logger.Logger.Error(
 				"cannot upgrade the connection to WebSockets",
 				zap.Error(err),
 			)
+			return
 		}
 		defer conn.Close()
 
-		client := &Client{conn: conn, send: make(chan []byte)}
+		connections.Add(1)
+		defer connections.Done()
+
+		// larger messages close the connection
+		conn.SetReadLimit(limits.maxMessageBytes)
+
+		client := &Client{conn: conn, limits: limits}
+
+		// the requests of the connection are processed until
+		// the connection is closed
+		connCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// the reader of the connection feeds the requests queue, which is
+		// drained by the service; the service feeds the responses queue,
+		// which is drained by the writer of the connection
+		responses := make(chan common.Response, queueSize)
+		requests := common.NewRequestQueue(queueSize, func(paused bool) {
+			if paused {
+				metrics.FlowControlPauses.Inc()
+			}
+
+			logger.Logger.Debug(
+				"flow control",
+				zap.String("client_id", clientId),
+				zap.Bool("paused", paused),
+			)
+
+			select {
+			case responses <- common.FlowControl{Paused: paused}:
+			case <-connCtx.Done():
+			}
+		})
+
+		logger.Logger.Info(
+			"client connected",
+			zap.String("client_id", clientId),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+
+		service.Run(connCtx, r.RemoteAddr, clientId, requests, responses)
 
-		requestsC := make(chan interface{})
-		responsesC := make(chan interface{})
+		readsDone := make(chan struct{})
+		go func() {
+			defer close(readsDone)
+			client.handleReads(connCtx, requests)
+			cancel()
+		}()
+		client.handleWrites(connCtx, responses)
 
-		service.Run(ctx, requestsC, responsesC)
+		// the server is shutting down: the client is told to close the
+		// connection, whose reader gets the close frame of the client
+		if ctx.Err() != nil {
+			client.close(readsDone)
+		}
+	}
+}
+
+// close sends a close frame to the client and waits (briefly) for the
+// client to acknowledge it
+func (c *Client) close(readsDone <-chan struct{}) {
+	err := c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+		time.Now().Add(time.Second),
+	)
+	if err != nil {
+		logger.Logger.Debug("cannot send the close frame", zap.Error(err))
+		return
+	}
 
-		go client.handleReads(requestsC)
-		client.handleWrites(responsesC)
+	select {
+	case <-readsDone:
+	case <-time.After(closeTimeout):
 	}
 }
 
-func (c *Client) handleReads(requestsC chan interface{}) {
+// handleReads reads the requests of the client until the connection is
+// closed; while the requests queue is full, the client is not read
+func (c *Client) handleReads(ctx context.Context, requests *common.RequestQueue) {
 	for {
 		msgType, msg, err := c.conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			// (the connection has been closed with a close frame)
+			metrics.InvalidMessages.WithLabelValues("too_large").Inc()
+			logger.Logger.Warn(
+				"message from client too large",
+				zap.Int64("max_bytes", c.limits.maxMessageBytes),
+			)
+			return
+		}
 		if err != nil {
 			logger.Logger.Error("error reading message from client", zap.Error(err))
-			break
+			return
 		}
 
 		if msgType == websocket.PingMessage {
 			continue
 		}
 
-		if err := processIncomingMessage(msg, requestsC); err != nil {
-			logger.Logger.Error(
-				"cannot process message from client",
+		request, err := processIncomingMessage(msg, c.limits)
+		if err != nil {
+			// the client does not follow the protocol: the connection
+			// is closed
+			metrics.InvalidMessages.WithLabelValues("malformed").Inc()
+			logger.Logger.Warn(
+				"malformed message from client",
 				zap.Error(err),
 			)
+			c.closeWithError(err)
+			return
+		}
+
+		if requestError(request) != nil {
+			// the request is answered with its error by the service
+			metrics.InvalidMessages.WithLabelValues("invalid_request").Inc()
+			logger.Logger.Warn(
+				"invalid request from client",
+				zap.Error(requestError(request)),
+			)
+		}
+
+		if err := requests.Push(ctx, request); err != nil {
+			return
 		}
 	}
 }
 
-func (c *Client) handleWrites(responsesC chan interface{}) {
+// closeWithError closes the connection with a protocol error (the
+// reason sent to the client is truncated to fit in a close frame)
+func (c *Client) closeWithError(err error) {
+	reason := err.Error()
+	if len(reason) > maxCloseReasonBytes {
+		reason = reason[:maxCloseReasonBytes]
+	}
+
+	err = c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseProtocolError, reason),
+		time.Now().Add(time.Second),
+	)
+	if err != nil {
+		logger.Logger.Debug("cannot send the close frame", zap.Error(err))
+	}
+}
+
+// requestError returns the error of an invalid request (nil if the
+// request is valid)
+func requestError(request common.Request) error {
+	switch r := request.(type) {
+	case common.TransferRequest:
+		return r.Error
+	case *common.File:
+		return r.Error
+	case common.DownloadRequest:
+		return r.Error
+	}
+
+	return nil
+}
+
+// handleWrites sends the responses to the client until the connection
+// is closed or cannot be written to
+func (c *Client) handleWrites(ctx context.Context, responses <-chan common.Response) {
 	for {
-		response := <-responsesC
+		var response common.Response
+
+		select {
+		case response = <-responses:
+		case <-ctx.Done():
+			return
+		}
+
 		msg, err := prepareOutgoingMessage(response)
 		if err != nil {
 			logger.Logger.Error(
 				"cannot prepare the message to send",
 				zap.Error(err),
 			)
-			responsesC <- &common.TransferAck{
-				Error: err,
-			}
+			continue
 		}
 
 		err = c.conn.WriteMessage(
@@ -88,27 +262,76 @@ func (c *Client) handleWrites(responsesC chan interface{}) {
 		)
 		if err != nil {
 			logger.Logger.Error(
-				"cannot prepare the message to send",
+				"cannot send the message",
 				zap.Error(err),
 			)
-			responsesC <- &common.TransferAck{
-				Error: err,
-			}
+			return
 		}
 	}
 }
 
-func Run(ctx context.Context, service *middleware.Service) {
-	http.HandleFunc("/", HandleConnections(ctx, service))
+// Run serves the clients until the context is canceled, then closes
+// their connections and returns once they are closed
+func Run(ctx context.Context, service *middleware.Service, cfg *config.Config) {
+	auth, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		logger.Logger.Fatal("clients cannot be authenticated", zap.Error(err))
+	}
+
+	if auth.allowAnonymous {
+		logger.Logger.Warn("anonymous clients are allowed to connect")
+	}
 
-	port := os.Getenv("PORT")
-	if len(port) == 0 {
-		port = "3000"
+	limits := messageLimits{
+		maxMessageBytes:   cfg.Limits.MaxMessageSize,
+		maxPreflightFiles: cfg.Limits.MaxPreflightFiles,
 	}
 
-	logger.Logger.Sugar().Infof("Server started on :%s", port)
-	err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%s", port), nil)
+	var connections sync.WaitGroup
+
+	// (the metrics are served by the admin API, behind its token)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", HandleConnections(ctx, service, auth, cfg.Server.QueueSize, limits, &connections))
+
+	certs, err := newCertReloader(cfg.TLS)
 	if err != nil {
+		logger.Logger.Fatal("TLS cannot be configured", zap.Error(err))
+	}
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(cfg.Server.Address, strconv.Itoa(cfg.Server.Port)),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		// no new connection is accepted (the WebSocket connections,
+		// which are not tracked by the HTTP server, close themselves)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Logger.Error("the server cannot be shut down", zap.Error(err))
+		}
+	}()
+
+	if certs == nil {
+		logger.Logger.Warn("TLS disabled: the connections are not encrypted")
+		logger.Logger.Sugar().Infof("Server started on %s", server.Addr)
+		err = server.ListenAndServe()
+	} else {
+		go certs.watch(ctx, time.Duration(cfg.TLS.ReloadInterval))
+
+		server.TLSConfig = certs.tlsConfig()
+		logger.Logger.Sugar().Infof("Server started on %s (TLS)", server.Addr)
+		err = server.ListenAndServeTLS("", "")
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Logger.Error("ListenAndServe: ", zap.Error(err))
 	}
+
+	connections.Wait()
+	logger.Logger.Info("connections closed")
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/config"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"go.uber.org/zap"
+)
+
+// certReloader serves the certificate of the server (and, for mutual
+// TLS, the CA bundle used to verify the client certificates) and
+// reloads them when their files change, so that certificates can be
+// renewed without restarting the server
+type certReloader struct {
+	mu        sync.RWMutex
+	certFile  string
+	keyFile   string
+	caFile    string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// newCertReloader loads the certificate and the key of the server (and
+// the optional CA bundle verifying the client certificates) of the
+// configuration; it returns nil if TLS is not enabled
+func newCertReloader(cfg config.TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	r := &certReloader{
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+		caFile:   cfg.ClientCAFile,
+		modTimes: make(map[string]time.Time),
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+
+	return files
+}
+
+// load reads the certificate, the key and the CA bundle; the previous
+// ones are kept if any of them is invalid
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("invalid certificate or key: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return nil
+}
+
+// hasChanged returns whether any file has been modified since
+// the last successful load
+func (r *certReloader) hasChanged() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// the file may be being replaced
+			continue
+		}
+
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watch reloads the files when they change until the context is canceled
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if !r.hasChanged() {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			logger.Logger.Error(
+				"TLS certificates cannot be reloaded; keeping the previous ones",
+				zap.Error(err),
+			)
+			continue
+		}
+
+		logger.Logger.Info("TLS certificates reloaded")
+	}
+}
+
+// tlsConfig returns a TLS configuration that always uses the last
+// loaded certificate and CA bundle; client certificates are optional
+// (the clients can authenticate with a token instead) but are verified
+// when presented
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeyPair writes a self-signed certificate and its key
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	for _, file := range []string{certFile, keyFile} {
+		assert.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+}
+
+func servedCommonName(t *testing.T, r *certReloader) string {
+	config, err := r.tlsConfig().GetConfigForClient(nil)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	assert.NoError(t, err)
+
+	return cert.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	logger.Init("")
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	now := time.Now()
+
+	writeKeyPair(t, certFile, keyFile, "first", now.Add(-time.Minute))
+
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	assert.NoError(t, r.load())
+	assert.False(t, r.hasChanged())
+	assert.Equal(t, "first", servedCommonName(t, r))
+
+	// renewed certificate
+	writeKeyPair(t, certFile, keyFile, "second", now)
+	assert.True(t, r.hasChanged())
+	assert.NoError(t, r.load())
+	assert.Equal(t, "second", servedCommonName(t, r))
+
+	// invalid certificate: the previous one is kept
+	assert.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	assert.Error(t, r.load())
+	assert.Equal(t, "second", servedCommonName(t, r))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/glethuillier/fvs/lib/pkg/messages"
+	"github.com/google/uuid"
+)
+
+// ErrMalformedMessage is returned for the messages that cannot be
+// attributed to a request (the connection is then closed)
+var ErrMalformedMessage = errors.New("malformed message")
+
+// ErrInvalidRequest is returned for the requests whose contents are
+// invalid (the request is then answered with an error)
+var ErrInvalidRequest = errors.New("invalid request")
+
+// maximum length of a filename (in bytes), as on most file systems
+const maxFilenameBytes = 255
+
+// messageLimits bounds the messages received from the clients
+type messageLimits struct {
+	// maximum size of a message (larger messages close the connection)
+	maxMessageBytes int64
+
+	// maximum number of files listed by a preflight
+	maxPreflightFiles int
+}
+
+// invalidRequest returns an error wrapping ErrInvalidRequest
+func invalidRequest(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+// validatePreflight checks the root hash of a batch and the files listed
+// by its preflight (the files are unique, and as many sizes as files are
+// declared, if any)
+func validatePreflight(rootHash string, preflight *messages.TransferPreflight, limits messageLimits) error {
+	if err := validateRootHash(rootHash); err != nil {
+		return err
+	}
+
+	if preflight.HashAlgorithm != messages.HashAlgorithm_SHA512 {
+		return invalidRequest("unsupported hash algorithm %s", preflight.HashAlgorithm)
+	}
+
+	if len(preflight.Filenames) == 0 {
+		return invalidRequest("the preflight lists no files")
+	}
+
+	if len(preflight.Filenames) > limits.maxPreflightFiles {
+		return invalidRequest(
+			"the preflight lists %d files (max: %d)",
+			len(preflight.Filenames), limits.maxPreflightFiles,
+		)
+	}
+
+	seen := make(map[string]struct{}, len(preflight.Filenames))
+	for _, filename := range preflight.Filenames {
+		if err := validateFilename(filename); err != nil {
+			return err
+		}
+
+		if _, ok := seen[filename]; ok {
+			return invalidRequest("duplicate filename %q", filename)
+		}
+		seen[filename] = struct{}{}
+	}
+
+	if len(preflight.Sizes) > 0 && len(preflight.Sizes) != len(preflight.Filenames) {
+		return invalidRequest(
+			"the preflight declares %d sizes for %d files",
+			len(preflight.Sizes), len(preflight.Filenames),
+		)
+	}
+
+	for _, size := range preflight.Sizes {
+		if size < 0 {
+			return invalidRequest("negative file size")
+		}
+	}
+
+	return nil
+}
+
+// validateFile checks a file of a batch
+func validateFile(rootHash string, file *messages.TransferFile) error {
+	if err := validateRootHash(rootHash); err != nil {
+		return err
+	}
+
+	return validateFilename(file.Filename)
+}
+
+// validateDownloadRequest checks the receipt ID and the filename of
+// a download request
+func validateDownloadRequest(request *messages.DownloadRequest) error {
+	// (the root hash field of a download request holds a receipt ID)
+	if _, err := uuid.Parse(request.RootHash); err != nil {
+		return invalidRequest("invalid receipt ID")
+	}
+
+	return validateFilename(request.Filename)
+}
+
+// validateRootHash checks that a root hash is a lowercase, hex-encoded
+// SHA-512 digest
+func validateRootHash(rootHash string) error {
+	if len(rootHash) != 2*sha512.Size {
+		return invalidRequest("invalid root hash length %d", len(rootHash))
+	}
+
+	for _, c := range rootHash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return invalidRequest("the root hash is not lowercase hexadecimal")
+		}
+	}
+
+	return nil
+}
+
+// validateFilename checks that a filename can be stored as is: it is a
+// valid UTF-8 name of at most 255 bytes, without path separators or
+// control characters, and it is neither "." nor ".."
+func validateFilename(filename string) error {
+	if filename == "" {
+		return invalidRequest("empty filename")
+	}
+
+	if len(filename) > maxFilenameBytes {
+		return invalidRequest("filename longer than %d bytes", maxFilenameBytes)
+	}
+
+	if !utf8.ValidString(filename) {
+		return invalidRequest("the filename is not valid UTF-8")
+	}
+
+	if filename == "." || filename == ".." || strings.ContainsAny(filename, `/\`) {
+		return invalidRequest("invalid filename %q", filename)
+	}
+
+	for _, c := range filename {
+		if unicode.IsControl(c) {
+			return invalidRequest("the filename contains control characters")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/glethuillier/fvs/lib/pkg/messages"
+	"github.com/glethuillier/fvs/server/internal/common"
+	"github.com/glethuillier/fvs/server/internal/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProcessIncomingMessage(t *testing.T) {
+	logger.Init("")
+
+	limits := messageLimits{maxMessageBytes: 1 << 20, maxPreflightFiles: 3}
+	rootHash := strings.Repeat("ab", 64)
+	receiptId := uuid.NewString()
+
+	wrap := func(messageType messages.MessageType, rootHash string, payload proto.Message) []byte {
+		p, err := proto.Marshal(payload)
+		assert.NoError(t, err)
+
+		msg, err := proto.Marshal(&messages.WrapperMessage{
+			MessageId: uuid.NewString(),
+			Type:      messageType,
+			RootHash:  rootHash,
+			Payload:   p,
+		})
+		assert.NoError(t, err)
+
+		return msg
+	}
+
+	preflight := func(rootHash string, filenames ...string) []byte {
+		return wrap(messages.MessageType_TRANSFER_PREFLIGHT, rootHash, &messages.TransferPreflight{
+			HashAlgorithm: messages.HashAlgorithm_SHA512,
+			Filenames:     filenames,
+		})
+	}
+
+	file := func(rootHash, filename string) []byte {
+		return wrap(messages.MessageType_TRANSFER_FILE, rootHash, &messages.TransferFile{
+			Filename: filename,
+			Contents: []byte("contents"),
+		})
+	}
+
+	download := func(receiptId, filename string) []byte {
+		return wrap(messages.MessageType_DOWNLOAD_REQUEST, "", &messages.DownloadRequest{
+			RootHash: receiptId,
+			Filename: filename,
+		})
+	}
+
+	tests := []struct {
+		name              string
+		msg               []byte
+		expectedMalformed bool
+		expectedInvalid   bool
+	}{
+		{
+			name: "Valid preflight",
+			msg:  preflight(rootHash, "a.txt", "b.txt"),
+		},
+		{
+			name:            "Too many files",
+			msg:             preflight(rootHash, "a", "b", "c", "d"),
+			expectedInvalid: true,
+		},
+		{
+			name:            "Duplicate filenames",
+			msg:             preflight(rootHash, "a.txt", "a.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name:            "No files",
+			msg:             preflight(rootHash),
+			expectedInvalid: true,
+		},
+		{
+			name:            "Root hash too short",
+			msg:             preflight("abcd", "a.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name:            "Root hash not hexadecimal",
+			msg:             preflight(strings.Repeat("zz", 64), "a.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name: "Sizes inconsistent with the files",
+			msg: wrap(messages.MessageType_TRANSFER_PREFLIGHT, rootHash, &messages.TransferPreflight{
+				HashAlgorithm: messages.HashAlgorithm_SHA512,
+				Filenames:     []string{"a.txt", "b.txt"},
+				Sizes:         []int64{1},
+			}),
+			expectedInvalid: true,
+		},
+		{
+			name: "Unsupported hash algorithm",
+			msg: wrap(messages.MessageType_TRANSFER_PREFLIGHT, rootHash, &messages.TransferPreflight{
+				HashAlgorithm: messages.HashAlgorithm_SHA256,
+				Filenames:     []string{"a.txt"},
+			}),
+			expectedInvalid: true,
+		},
+		{
+			name: "Valid file",
+			msg:  file(rootHash, "a.txt"),
+		},
+		{
+			name:            "Path traversal",
+			msg:             file(rootHash, "../a.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name:            "Filename with a control character",
+			msg:             file(rootHash, "a\n.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name:            "Filename too long",
+			msg:             file(rootHash, strings.Repeat("a", 256)),
+			expectedInvalid: true,
+		},
+		{
+			name: "Valid download request",
+			msg:  download(receiptId, "a.txt"),
+		},
+		{
+			name:            "Invalid receipt ID",
+			msg:             download("not-a-uuid", "a.txt"),
+			expectedInvalid: true,
+		},
+		{
+			name:              "Not a Protobuf message",
+			msg:               []byte{0xff, 0xff, 0xff},
+			expectedMalformed: true,
+		},
+		{
+			name:              "Invalid message ID",
+			msg:               mustMarshal(t, &messages.WrapperMessage{MessageId: "1"}),
+			expectedMalformed: true,
+		},
+		{
+			name:              "Not a request",
+			msg:               wrap(messages.MessageType_FLOW_CONTROL, "", &messages.FlowControl{}),
+			expectedMalformed: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := processIncomingMessage(tc.msg, limits)
+
+			if tc.expectedMalformed {
+				assert.ErrorIs(t, err, ErrMalformedMessage)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, request)
+
+			if tc.expectedInvalid {
+				assert.ErrorIs(t, requestError(request), ErrInvalidRequest)
+			} else {
+				assert.NoError(t, requestError(request))
+			}
+		})
+	}
+
+	// the contents of a valid request are kept
+	request, err := processIncomingMessage(file(rootHash, "a.txt"), limits)
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", request.(*common.File).Filename)
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	msg, err := proto.Marshal(m)
+	assert.NoError(t, err)
+	return msg
+}
@@ -2,35 +2,62 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/glethuillier/fvs/server/internal/admin"
+	"github.com/glethuillier/fvs/server/internal/config"
 	"github.com/glethuillier/fvs/server/internal/logger"
 	"github.com/glethuillier/fvs/server/internal/middleware"
 	"github.com/glethuillier/fvs/server/internal/server"
 	"go.uber.org/zap"
 )
 
+// after the uploads have been drained, the connections and the
+// background jobs (retention, scrub, admin API) have their own time to
+// stop
+const (
+	closeTimeout = 10 * time.Second
+	jobsTimeout  = 10 * time.Second
+)
+
 func main() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	logger.Init(logLevel)
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, usage)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger.Init(cfg.LogLevel)
+
+	// administrative commands (e.g., `hold`)
+	if len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	done := make(chan struct{})
-
 	signalC := make(chan os.Signal, 1)
 	signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-signalC
-		logger.Logger.Info("closing the server...")
-		cancel()
-		done <- struct{}{}
-	}()
 
-	service, err := middleware.GetService()
+	service, err := middleware.GetService(cfg)
 	if err != nil {
 		logger.Logger.Fatal(
 			"middleware cannot be launched",
@@ -38,7 +65,65 @@ func main() {
 		)
 	}
 
-	go server.Run(ctx, service)
+	var (
+		jobs       sync.WaitGroup
+		serverDone = make(chan struct{})
+	)
+
+	jobs.Add(3)
+	go func() { defer jobs.Done(); service.RunRetention(ctx) }()
+	go func() { defer jobs.Done(); service.RunScrubber(ctx) }()
+	go func() { defer jobs.Done(); admin.Run(ctx, service, cfg.Admin) }()
+
+	go func() {
+		defer close(serverDone)
+		server.Run(ctx, service, cfg)
+	}()
+
+	<-signalC
+	logger.Logger.Info("closing the server...", zap.Duration("timeout", shutdownTimeout))
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	// no new upload is accepted; the uploads in progress can finish
+	service.Shutdown(shutdownCtx)
+
+	// the connections are closed and the background jobs stopped
+	cancel()
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+
+	closed := waitPhase(serverDone, closeTimeout, "connections still open at the end of the shutdown")
+	stopped := waitPhase(jobsDone, jobsTimeout, "background jobs still running at the end of the shutdown")
+
+	// the database is only closed once nothing can use it anymore
+	if closed && stopped {
+		if err := service.Close(); err != nil {
+			logger.Logger.Error("the database cannot be closed", zap.Error(err))
+		}
+	} else {
+		logger.Logger.Warn("the database is left open")
+	}
+
+	logger.Logger.Info("server closed")
+}
+
+// waitPhase waits until a phase of the shutdown is done, or until its
+// timeout expires; it returns whether the phase is done
+func waitPhase(done <-chan struct{}, timeout time.Duration, warning string) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	<-done
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		logger.Logger.Warn(warning, zap.Duration("timeout", timeout))
+		return false
+	}
 }